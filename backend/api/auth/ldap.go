@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	ErrLdapUnknownUser        = errors.New("user not found in the directory")
+	ErrLdapInvalidCredentials = errors.New("invalid directory credentials")
+)
+
+/*
+	An LDAP or Active Directory server users can log in against.
+	The user is looked up with the service account (or anonymously if BindDN is empty)
+	and then authenticated by binding with their own DN and password.
+*/
+type LdapDirectory struct {
+	URL                string // ldap://host:389 or ldaps://host:636
+	StartTLS           bool
+	InsecureSkipVerify bool
+	BindDN             string
+	BindPassword       string
+	BaseDN             string
+	UserFilter         string // {username} gets replaced by the escaped login name
+	EmailAttribute     string
+	GroupAttribute     string
+	Timeout            time.Duration
+}
+
+type LdapIdentity struct {
+	DN     string
+	Email  string
+	Groups []string
+}
+
+func (d *LdapDirectory) Authenticate(username string, password string) (*LdapIdentity, error) {
+
+	// An empty password would be an unauthenticated bind, which most servers accept
+	if strings.TrimSpace(username) == "" || password == "" {
+		return nil, ErrLdapInvalidCredentials
+	}
+
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if d.BindDN != "" {
+		if err := conn.Bind(d.BindDN, d.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service account bind failed: %w", err)
+		}
+	}
+
+	filter := strings.ReplaceAll(d.UserFilter, "{username}", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(d.timeout().Seconds()), false,
+		filter, []string{d.EmailAttribute, d.GroupAttribute}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("ldap search failed: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		// Nobody or more than one user matched, neither can be logged in safely
+		return nil, ErrLdapUnknownUser
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrLdapInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap bind failed: %w", err)
+	}
+
+	return &LdapIdentity{
+		DN:     entry.DN,
+		Email:  entry.GetAttributeValue(d.EmailAttribute),
+		Groups: entry.GetAttributeValues(d.GroupAttribute),
+	}, nil
+}
+
+func (d *LdapDirectory) connect() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: d.InsecureSkipVerify}
+	if host, _, err := net.SplitHostPort(strings.TrimPrefix(strings.TrimPrefix(d.URL, "ldaps://"), "ldap://")); err == nil {
+		tlsConfig.ServerName = host
+	}
+
+	conn, err := ldap.DialURL(d.URL, ldap.DialWithDialer(&net.Dialer{Timeout: d.timeout()}), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach the ldap server: %w", err)
+	}
+	conn.SetTimeout(d.timeout())
+
+	if d.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap StartTLS failed: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (d *LdapDirectory) timeout() time.Duration {
+	if d.Timeout > 0 {
+		return d.Timeout
+	}
+	return 10 * time.Second
+}
+
+// Whether a group from the directory is the configured one, given as full DN or only its CN
+func LdapGroupMatches(configured string, group string) bool {
+	if strings.EqualFold(configured, group) {
+		return true
+	}
+	dn, err := ldap.ParseDN(group)
+	if err != nil || len(dn.RDNs) == 0 {
+		return false
+	}
+	for _, attribute := range dn.RDNs[0].Attributes {
+		if strings.EqualFold(attribute.Type, "cn") && strings.EqualFold(attribute.Value, configured) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLdapGroupMatches(t *testing.T) {
+	tests := []struct {
+		configured string
+		group      string
+		matches    bool
+	}{
+		{"cn=singers,ou=groups,dc=example,dc=org", "cn=singers,ou=groups,dc=example,dc=org", true},
+		{"CN=Singers,OU=Groups,DC=example,DC=org", "cn=singers,ou=groups,dc=example,dc=org", true},
+		{"singers", "cn=singers,ou=groups,dc=example,dc=org", true},
+		{"Singers", "CN=singers,OU=groups,DC=example,DC=org", true},
+		{"singers", "singers", true},
+		// Only the first RDN names the group
+		{"groups", "cn=singers,ou=groups,dc=example,dc=org", false},
+		{"example", "cn=singers,ou=groups,dc=example,dc=org", false},
+		{"singers", "ou=singers,dc=example,dc=org", false},
+		{"singers", "cn=choir-singers,ou=groups,dc=example,dc=org", false},
+		{"singers", "not a dn", false},
+		{"singers", "", false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.matches, LdapGroupMatches(test.configured, test.group), "%s against %s", test.configured, test.group)
+	}
+}
@@ -0,0 +1,443 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+/*
+	A minimal OpenID Connect relying party for the authorization code flow with PKCE.
+	Only what SheetAble needs: discovery, the token exchange, the userinfo endpoint
+	and verifying RSA or EC signed ID tokens against the JWKS of the provider.
+*/
+type OidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+
+	ClientID     string   `json:"-"`
+	ClientSecret string   `json:"-"`
+	RedirectURL  string   `json:"-"`
+	Scopes       []string `json:"-"`
+	GroupsClaim  string   `json:"-"`
+
+	Client *http.Client `json:"-"`
+
+	keysMutex sync.Mutex
+	keys      map[string]interface{}
+}
+
+// What SheetAble takes from the ID token and the userinfo endpoint
+type OidcIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified *bool // nil if the provider doesn't say
+	Name          string
+	Groups        []string
+}
+
+func DiscoverOidcProvider(client *http.Client, issuer string, discoveryURL string) (*OidcProvider, error) {
+	if discoveryURL == "" {
+		discoveryURL = strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	}
+
+	provider := &OidcProvider{Client: client}
+	if err := getJSON(client, discoveryURL, provider); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery returned the issuer %s instead of %s", provider.Issuer, issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JwksURI == "" {
+		return nil, errors.New("oidc discovery document misses an endpoint")
+	}
+	return provider, nil
+}
+
+func NewPkce() (verifier string, challenge string, err error) {
+
+	// S256 code challenge, see RFC 7636
+	verifier, err = randomString()
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func (p *OidcProvider) AuthCodeURL(state string, nonce string, codeChallenge string) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.AuthorizationEndpoint + separator + query.Encode()
+}
+
+func (p *OidcProvider) Exchange(code string, codeVerifier string, nonce string) (*OidcIdentity, error) {
+	/*
+		Trade the authorization code for tokens and return who logged in.
+		The ID token has to be signed by the provider, meant for us and carry our nonce.
+	*/
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {codeVerifier},
+		"client_id":     {p.ClientID},
+	}
+	req, err := http.NewRequest(http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := doJSON(p.client(), req, &tokens); err != nil {
+		return nil, fmt.Errorf("oidc token exchange failed: %w", err)
+	}
+	if tokens.Error != "" {
+		return nil, fmt.Errorf("oidc token exchange failed: %s %s", tokens.Error, tokens.Description)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc provider returned no id_token")
+	}
+
+	claims, err := p.verifyIDToken(tokens.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// Some providers only hand out the email or groups through the userinfo endpoint
+	if p.UserinfoEndpoint != "" && tokens.AccessToken != "" && (claims["email"] == nil || claims[p.groupsClaim()] == nil) {
+		userinfo, err := p.userinfo(tokens.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+		if userinfo["sub"] != claims["sub"] {
+			return nil, errors.New("oidc userinfo belongs to another subject")
+		}
+		for key, value := range userinfo {
+			if _, ok := claims[key]; !ok {
+				claims[key] = value
+			}
+		}
+	}
+	return p.identity(claims), nil
+}
+
+func (p *OidcProvider) verifyIDToken(raw string, nonce string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.key(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid id_token")
+	}
+
+	if !claims.VerifyIssuer(p.Issuer, true) {
+		return nil, errors.New("id_token was issued by someone else")
+	}
+	if !audienceContains(claims["aud"], p.ClientID) {
+		return nil, errors.New("id_token is meant for another client")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("id_token has no expiry")
+	}
+	if claims["nonce"] != nonce {
+		return nil, errors.New("id_token nonce doesn't match")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("id_token has no subject")
+	}
+	return claims, nil
+}
+
+func (p *OidcProvider) identity(claims jwt.MapClaims) *OidcIdentity {
+	identity := &OidcIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = &verified
+	case string:
+		// Some providers send it as a string
+		v := verified == "true"
+		identity.EmailVerified = &v
+	}
+
+	switch groups := claims[p.groupsClaim()].(type) {
+	case []interface{}:
+		for _, group := range groups {
+			if g, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, g)
+			}
+		}
+	case string:
+		identity.Groups = strings.Fields(groups)
+	}
+	return identity
+}
+
+func (p *OidcProvider) userinfo(accessToken string) (map[string]interface{}, error) {
+	req, err := http.NewRequest(http.MethodGet, p.UserinfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	userinfo := map[string]interface{}{}
+	if err := doJSON(p.client(), req, &userinfo); err != nil {
+		return nil, fmt.Errorf("oidc userinfo failed: %w", err)
+	}
+	return userinfo, nil
+}
+
+func (p *OidcProvider) key(kid string) (interface{}, error) {
+	p.keysMutex.Lock()
+	defer p.keysMutex.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	// Unknown key id, the provider might have rotated its keys
+	keys, err := fetchJwks(p.client(), p.JwksURI)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func fetchJwks(client *http.Client, jwksURI string) (map[string]interface{}, error) {
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJSON(client, jwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("oidc jwks failed: %w", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
+
+func (p *OidcProvider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+func (p *OidcProvider) groupsClaim() string {
+	if p.GroupsClaim != "" {
+		return p.GroupsClaim
+	}
+	return "groups"
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getJSON(client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return doJSON(client, req, v)
+}
+
+func doJSON(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Token endpoints report errors as JSON with status 400, let the caller look at them
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("%s responded with %s", req.URL.Host, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+/*
+	State of a login which is in progress, kept in a signed cookie
+	so the server doesn't have to remember anything between login and callback.
+*/
+type OidcFlow struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+func NewOidcFlow() (*OidcFlow, string, error) {
+
+	// A new flow and its PKCE code challenge
+	flow := &OidcFlow{}
+	var err error
+	var challenge string
+	if flow.State, err = randomString(); err != nil {
+		return nil, "", err
+	}
+	if flow.Nonce, err = randomString(); err != nil {
+		return nil, "", err
+	}
+	if flow.CodeVerifier, challenge, err = NewPkce(); err != nil {
+		return nil, "", err
+	}
+	return flow, challenge, nil
+}
+
+func (f *OidcFlow) Sign(ttl time.Duration, apiSecret string) (string, error) {
+	claims := jwt.MapClaims{
+		"state":         f.State,
+		"nonce":         f.Nonce,
+		"code_verifier": f.CodeVerifier,
+		"exp":           time.Now().Add(ttl).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(apiSecret))
+}
+
+func ParseOidcFlow(signed string, apiSecret string) (*OidcFlow, error) {
+	token, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(apiSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid login state")
+	}
+	flow := &OidcFlow{}
+	flow.State, _ = claims["state"].(string)
+	flow.Nonce, _ = claims["nonce"].(string)
+	flow.CodeVerifier, _ = claims["code_verifier"].(string)
+	if flow.State == "" || flow.Nonce == "" || flow.CodeVerifier == "" {
+		return nil, errors.New("invalid login state")
+	}
+	return flow, nil
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// A local identity provider which hands out a code for every authorization request
+type mockIdentityProvider struct {
+	*httptest.Server
+	key        *rsa.PrivateKey
+	challenges map[string]string // code -> code challenge
+	nonces     map[string]string // code -> nonce
+	groups     []string
+}
+
+func newMockIdentityProvider(t *testing.T) *mockIdentityProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	idp := &mockIdentityProvider{key: key, challenges: map[string]string{}, nonces: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"userinfo_endpoint":      idp.URL + "/userinfo",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "test",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		code := r.PostForm.Get("code")
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if idp.challenges[code] == "" || idp.challenges[code] != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            idp.URL,
+			"aud":            "sheetable",
+			"sub":            "user-1",
+			"email":          "alice@example.com",
+			"email_verified": true,
+			"nonce":          idp.nonces[code],
+			"exp":            time.Now().Add(time.Minute).Unix(),
+		})
+		idToken.Header["kid"] = "test"
+		signed, _ := idToken.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "id_token": signed})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"sub": "user-1", "groups": idp.groups})
+	})
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *mockIdentityProvider) authorize(t *testing.T, authURL string) string {
+	u, err := url.Parse(authURL)
+	assert.NoError(t, err)
+	assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	code := "code-" + u.Query().Get("state")
+	idp.challenges[code] = u.Query().Get("code_challenge")
+	idp.nonces[code] = u.Query().Get("nonce")
+	return code
+}
+
+func TestOidcLogin(t *testing.T) {
+	idp := newMockIdentityProvider(t)
+	defer idp.Close()
+	idp.groups = []string{"/musicians"}
+
+	provider, err := DiscoverOidcProvider(idp.Client(), idp.URL, "")
+	assert.NoError(t, err)
+	provider.ClientID = "sheetable"
+	provider.RedirectURL = "http://localhost:8080/api/auth/oidc/callback"
+
+	flow, challenge, err := NewOidcFlow()
+	assert.NoError(t, err)
+	code := idp.authorize(t, provider.AuthCodeURL(flow.State, flow.Nonce, challenge))
+
+	identity, err := provider.Exchange(code, flow.CodeVerifier, flow.Nonce)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", identity.Subject)
+	assert.Equal(t, "alice@example.com", identity.Email)
+	assert.True(t, *identity.EmailVerified)
+	assert.Equal(t, []string{"/musicians"}, identity.Groups)
+}
+
+func TestOidcLoginRejectsWrongVerifierAndNonce(t *testing.T) {
+	idp := newMockIdentityProvider(t)
+	defer idp.Close()
+
+	provider, err := DiscoverOidcProvider(idp.Client(), idp.URL, "")
+	assert.NoError(t, err)
+	provider.ClientID = "sheetable"
+
+	flow, challenge, _ := NewOidcFlow()
+	code := idp.authorize(t, provider.AuthCodeURL(flow.State, flow.Nonce, challenge))
+
+	_, err = provider.Exchange(code, "not-the-verifier", flow.Nonce)
+	assert.Error(t, err)
+	_, err = provider.Exchange(code, flow.CodeVerifier, "another-nonce")
+	assert.Error(t, err)
+
+	provider.ClientID = "someone-else"
+	_, err = provider.Exchange(code, flow.CodeVerifier, flow.Nonce)
+	assert.Error(t, err)
+}
+
+func TestOidcFlowCookie(t *testing.T) {
+	flow, _, err := NewOidcFlow()
+	assert.NoError(t, err)
+	signed, err := flow.Sign(time.Minute, "secret")
+	assert.NoError(t, err)
+
+	parsed, err := ParseOidcFlow(signed, "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, flow, parsed)
+
+	_, err = ParseOidcFlow(signed, "other secret")
+	assert.Error(t, err)
+}
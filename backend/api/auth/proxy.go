@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// What SheetAble takes from the headers of an authenticating reverse proxy
+type ProxyIdentity struct {
+	User   string
+	Email  string
+	Groups []string
+}
+
+/*
+	The networks of the proxies whose headers are trusted.
+	Single addresses like 10.0.0.5 are accepted as well as CIDRs.
+*/
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %s, expected an address or a CIDR", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s, expected an address or a CIDR", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func ProxyTrusted(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+	The address of the client behind the proxies in X-Forwarded-For.
+	The header is read from the right and only as long as the hops are trusted proxies,
+	everything further left could be made up by the client.
+*/
+func ForwardedClientIP(networks []*net.IPNet, remote net.IP, forwardedFor string) net.IP {
+	if !ProxyTrusted(networks, remote) {
+		return remote
+	}
+	client := remote
+	hops := strings.Split(forwardedFor, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !ProxyTrusted(networks, ip) {
+			break
+		}
+	}
+	return client
+}
+
+// The identity from the header values, proxies send the groups comma separated
+func NewProxyIdentity(user string, email string, groups string) *ProxyIdentity {
+	identity := &ProxyIdentity{
+		User:  strings.TrimSpace(user),
+		Email: strings.TrimSpace(email),
+	}
+
+	// oauth2-proxy sends the email as user, some setups only send that
+	if identity.Email == "" && strings.Contains(identity.User, "@") {
+		identity.Email = identity.User
+	}
+	for _, group := range strings.Split(groups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			identity.Groups = append(identity.Groups, group)
+		}
+	}
+	return identity
+}
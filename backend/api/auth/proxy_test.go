@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	networks, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.5,::1, ")
+	assert.NoError(t, err)
+	assert.Len(t, networks, 3)
+
+	assert.True(t, ProxyTrusted(networks, net.ParseIP("10.1.2.3")))
+	assert.True(t, ProxyTrusted(networks, net.ParseIP("192.168.1.5")))
+	assert.True(t, ProxyTrusted(networks, net.ParseIP("::1")))
+	assert.False(t, ProxyTrusted(networks, net.ParseIP("192.168.1.6")))
+	assert.False(t, ProxyTrusted(networks, net.ParseIP("11.0.0.1")))
+	assert.False(t, ProxyTrusted(networks, nil))
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = ParseTrustedProxies("proxy.local")
+	assert.Error(t, err)
+}
+
+func TestNewProxyIdentity(t *testing.T) {
+	identity := NewProxyIdentity(" alice ", "alice@example.com", "singers, admins,")
+	assert.Equal(t, "alice", identity.User)
+	assert.Equal(t, "alice@example.com", identity.Email)
+	assert.Equal(t, []string{"singers", "admins"}, identity.Groups)
+
+	// oauth2-proxy only sends the email
+	identity = NewProxyIdentity("bob@example.com", "", "")
+	assert.Equal(t, "bob@example.com", identity.Email)
+	assert.Empty(t, identity.Groups)
+}
+
+func TestForwardedClientIP(t *testing.T) {
+	networks, err := ParseTrustedProxies("10.0.0.0/8")
+	assert.NoError(t, err)
+	client := net.ParseIP("203.0.113.7")
+
+	// Direct clients can't pick their address
+	assert.Equal(t, client, ForwardedClientIP(networks, client, "198.51.100.1"))
+
+	proxy := net.ParseIP("10.0.0.2")
+	assert.True(t, client.Equal(ForwardedClientIP(networks, proxy, "203.0.113.7")))
+	// Whatever the client put in front of its own address is skipped
+	assert.True(t, client.Equal(ForwardedClientIP(networks, proxy, "198.51.100.1, 203.0.113.7, 10.0.0.3")))
+	assert.True(t, proxy.Equal(ForwardedClientIP(networks, proxy, "")))
+	assert.True(t, proxy.Equal(ForwardedClientIP(networks, proxy, "garbage")))
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -8,11 +9,13 @@ import (
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
-func CreateToken(user_id uint32, apiSecret string) (string, error) {
+func CreateToken(user_id uint32, session_id string, ttl time.Duration, apiSecret string) (string, error) {
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
 	claims["user_id"] = user_id
-	claims["exp"] = time.Now().Add(time.Hour * 168).Unix() // Token expires after 1 week
+	claims["session_id"] = session_id // Lets the server revoke the token together with its session
+	claims["iat"] = time.Now().Unix()
+	claims["exp"] = time.Now().Add(ttl).Unix()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(apiSecret))
 
@@ -51,3 +54,163 @@ func ExtractTokenID(tokenString string, apiSecret string) (uint32, error) {
 	}
 	return 0, nil
 }
+
+func ExtractTokenSession(tokenString string, apiSecret string) (uint32, string, error) {
+
+	// The user and the session an access token belongs to
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(apiSecret), nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return 0, "", errors.New("invalid token")
+	}
+	uid, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["user_id"]), 10, 32)
+	if err != nil {
+		return 0, "", err
+	}
+	if _, ok := claims["media"]; ok {
+		return 0, "", errors.New("media tokens only load images")
+	}
+	sessionID, ok := claims["session_id"].(string)
+	if !ok || sessionID == "" {
+		return 0, "", errors.New("token has no session")
+	}
+	return uint32(uid), sessionID, nil
+}
+
+func CreateMediaToken(user_id uint32, session_id string, ttl time.Duration, apiSecret string) (string, error) {
+	/*
+		Images can't send the Authorization header, they get this token as a cookie instead.
+		It only loads thumbnails and portraits and ends together with its session.
+	*/
+	claims := jwt.MapClaims{}
+	claims["media"] = true
+	claims["user_id"] = user_id
+	claims["session_id"] = session_id
+	claims["iat"] = time.Now().Unix()
+	claims["exp"] = time.Now().Add(ttl).Unix()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(apiSecret))
+}
+
+func ExtractMediaToken(tokenString string, apiSecret string) (uint32, string, error) {
+
+	// The user and the session of a media token
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(apiSecret), nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return 0, "", errors.New("invalid token")
+	}
+	if media, _ := claims["media"].(bool); !media {
+		return 0, "", errors.New("not a media token")
+	}
+	uid, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["user_id"]), 10, 32)
+	if err != nil {
+		return 0, "", err
+	}
+	sessionID, _ := claims["session_id"].(string)
+	return uint32(uid), sessionID, nil
+}
+
+func CreateApiToken(user_id uint32, token_id uint32, expiresAt *time.Time, apiSecret string) (string, error) {
+	/*
+		Personal API tokens are long-lived and only end when they expire or get revoked.
+		The token id is looked up on every request, so deleting it revokes the token.
+	*/
+	claims := jwt.MapClaims{}
+	claims["authorized"] = true
+	claims["user_id"] = user_id
+	claims["token_id"] = token_id
+	claims["iat"] = time.Now().Unix()
+	if expiresAt != nil {
+		claims["exp"] = expiresAt.Unix()
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(apiSecret))
+}
+
+func ExtractApiToken(tokenString string, apiSecret string) (uint32, uint32, error) {
+
+	// The user and the id of a personal API token
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(apiSecret), nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return 0, 0, errors.New("invalid token")
+	}
+	if _, ok := claims["token_id"]; !ok {
+		return 0, 0, errors.New("not an API token")
+	}
+	uid, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["user_id"]), 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	tokenID, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["token_id"]), 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(uid), uint32(tokenID), nil
+}
+
+func CreateInvitationToken(invitation_id uint32, nonce string, expiresAt time.Time, apiSecret string) (string, error) {
+	/*
+		The token of an invite link. The nonce changes whenever the invitation
+		gets resent, which makes the links sent before stop working.
+	*/
+	claims := jwt.MapClaims{}
+	claims["invitation_id"] = invitation_id
+	claims["nonce"] = nonce
+	claims["iat"] = time.Now().Unix()
+	claims["exp"] = expiresAt.Unix()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(apiSecret))
+}
+
+func ExtractInvitationToken(tokenString string, apiSecret string) (uint32, string, error) {
+
+	// The invitation and the nonce of an invite link
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(apiSecret), nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return 0, "", errors.New("invalid token")
+	}
+	if _, ok := claims["invitation_id"]; !ok {
+		return 0, "", errors.New("not an invitation token")
+	}
+	invitationID, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["invitation_id"]), 10, 32)
+	if err != nil {
+		return 0, "", err
+	}
+	nonce, _ := claims["nonce"].(string)
+	return uint32(invitationID), nonce, nil
+}
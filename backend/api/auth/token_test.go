@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Media tokens and access tokens must not stand in for each other
+func TestMediaToken(t *testing.T) {
+	media, err := CreateMediaToken(3, "session", time.Hour, "secret")
+	assert.NoError(t, err)
+
+	uid, sessionID, err := ExtractMediaToken(media, "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), uid)
+	assert.Equal(t, "session", sessionID)
+
+	_, _, err = ExtractTokenSession(media, "secret")
+	assert.Error(t, err)
+
+	access, err := CreateToken(3, "session", time.Hour, "secret")
+	assert.NoError(t, err)
+	_, _, err = ExtractMediaToken(access, "secret")
+	assert.Error(t, err)
+
+	_, _, err = ExtractMediaToken(media, "other secret")
+	assert.Error(t, err)
+}
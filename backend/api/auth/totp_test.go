@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The SHA1 test vectors of RFC 6238, cut to 6 digits
+func TestTotpCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range vectors {
+		code, err := TotpCode(secret, time.Unix(unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code, "time %d", unix)
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	secret, err := NewTotpSecret()
+	assert.NoError(t, err)
+	now := time.Now()
+
+	code, _ := TotpCode(secret, now)
+	step, ok := ValidateTotp(secret, code, now, 0)
+	assert.True(t, ok)
+
+	// Still fine half a minute later, but not a second time
+	_, ok = ValidateTotp(secret, code, now.Add(30*time.Second), 0)
+	assert.True(t, ok)
+	_, ok = ValidateTotp(secret, code, now, step)
+	assert.False(t, ok)
+
+	_, ok = ValidateTotp(secret, code, now.Add(5*time.Minute), 0)
+	assert.False(t, ok)
+	_, ok = ValidateTotp(secret, "12345", now, 0)
+	assert.False(t, ok)
+}
+
+func TestTotpURI(t *testing.T) {
+	uri := TotpURI("ABC", "SheetAble", "alice@example.com")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/SheetAble:alice@example.com?"))
+	assert.Contains(t, uri, "secret=ABC")
+}
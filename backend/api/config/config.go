@@ -0,0 +1,239 @@
+package config
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/golobby/config/v3"
+	"github.com/golobby/config/v3/pkg/feeder"
+)
+
+var (
+	serverConfig ServerConfig
+	configOnce   sync.Once
+)
+
+type configBuilder struct {
+	dotenvFile           string
+	errorOnMissingDotenv bool
+}
+
+func ConfigBuilder() configBuilder {
+	return configBuilder{}
+}
+
+func (b configBuilder) WithDotenvFile(file string) configBuilder {
+	b.dotenvFile = file
+	return b
+}
+
+func (b configBuilder) PanicOnMissingDotenv(status bool) configBuilder {
+	b.errorOnMissingDotenv = status
+	return b
+}
+
+func (b configBuilder) Build() ServerConfig {
+	serverConfig = NewConfig()
+
+	dotenvFile := ".env"
+	if b.dotenvFile != "" {
+		dotenvFile = b.dotenvFile
+	}
+	dotenvFeeder := feeder.DotEnv{Path: dotenvFile}
+	envFeeder := feeder.Env{}
+
+	err := config.New().AddStruct(&serverConfig).AddFeeder(dotenvFeeder).Feed()
+	if err != nil {
+		if strings.Contains(err.Error(), "no such file") && b.errorOnMissingDotenv {
+			log.Fatalf("error loading config from dotenv file %s: %s", dotenvFile, err.Error())
+		}
+	}
+	err = config.New().AddStruct(&serverConfig).AddFeeder(envFeeder).Feed()
+	if err != nil {
+		log.Fatalf("error loding config from environemnt: %s", err.Error())
+	}
+	return serverConfig
+}
+
+func Config() ServerConfig {
+	configOnce.Do(func() {
+		serverConfig = ConfigBuilder().Build()
+	})
+	return serverConfig
+}
+
+type ServerConfig struct {
+	AdminEmail    string `env:"ADMIN_EMAIL"`
+	AdminPassword string `env:"ADMIN_PASSWORD"`
+	ApiSecret     string `env:"API_SECRET"`
+	ServerUrl     string `env:"SERVER_URL"`
+	ConfigPath    string `env:"CONFIG_PATH"`
+
+	Dev  bool `env:"DEV"`
+	Port int  `env:"PORT"`
+
+	// Comma separated reverse proxies whose X-Forwarded-For is believed, e.g. for the login rate limits
+	TrustedProxies string `env:"TRUSTED_PROXIES"`
+
+	AccessTokenMinutes int `env:"ACCESS_TOKEN_MINUTES"` // Lifetime of the JWT sent with every request
+	RefreshTokenDays   int `env:"REFRESH_TOKEN_DAYS"`   // How long a login lasts without using its refresh token
+
+	PasswordResetMinutes int `env:"PASSWORD_RESET_MINUTES"` // How long a password reset link works
+	InvitationDays       int `env:"INVITATION_DAYS"`        // How long an invite link works
+	AuditRetentionDays   int `env:"AUDIT_RETENTION_DAYS"`   // Audit events older than this are dropped, 0 keeps them forever
+
+	// Comma separated, the login tries the authenticators in this order: local, ldap
+	AuthOrder string `env:"AUTH_ORDER"`
+
+	// closed, approval (new users wait for an admin) or open
+	Registration      string `env:"REGISTRATION"`
+	RegistrationRole  string `env:"REGISTRATION_ROLE"`        // Role of users who registered themselves
+	ConfirmationHours int    `env:"EMAIL_CONFIRMATION_HOURS"` // How long the link confirming the email of a registration works
+
+	Database  DatabaseConfig
+	Smtp      SmtpConfig
+	Oidc      OidcConfig
+	Ldap      LdapConfig
+	ProxyAuth ProxyAuthConfig
+}
+
+// Bootstrap the application Config struct with the default config
+func NewConfig() ServerConfig {
+	return ServerConfig{
+		AdminEmail:    "admin@admin.com",
+		AdminPassword: "sheetable",
+		ApiSecret:     "sheetable",
+		ServerUrl:     "http://localhost:8080",
+		ConfigPath:    "./config/",
+
+		AccessTokenMinutes: 15,
+		RefreshTokenDays:   30,
+
+		PasswordResetMinutes: 60,
+		InvitationDays:       7,
+		AuditRetentionDays:   365,
+
+		AuthOrder: "local",
+
+		Registration:      "closed",
+		RegistrationRole:  "viewer",
+		ConfirmationHours: 24,
+
+		Database: DatabaseConfig{
+			Driver: "sqlite",
+		},
+		Smtp: SmtpConfig{
+			Enabled: "0",
+		},
+		Oidc: OidcConfig{
+			Scopes:      "openid email profile groups",
+			GroupsClaim: "groups",
+			DefaultRole: "viewer",
+			AutoCreate:  true,
+		},
+		Ldap: LdapConfig{
+			UserFilter:     "(|(uid={username})(mail={username}))",
+			EmailAttribute: "mail",
+			GroupAttribute: "memberOf",
+			DefaultRole:    "viewer",
+			AutoCreate:     true,
+		},
+		ProxyAuth: ProxyAuthConfig{
+			TrustedProxies: "127.0.0.1/32,::1/128",
+			UserHeader:     "Remote-User",
+			EmailHeader:    "Remote-Email",
+			GroupsHeader:   "Remote-Groups",
+			DefaultRole:    "viewer",
+			AutoCreate:     true,
+		},
+	}
+}
+
+type SmtpConfig struct {
+	Enabled        string `env:"SMTP_ENABLED"`
+	From           string `env:"SMTP_FROM"`
+	HostServerAddr string `env:"SMTP_SERVER_ADDR"`
+	HostServerPort int    `env:"SMTP_HOST_SERVER_PORT"`
+	Username       string `env:"SMTP_USERNAME"`
+	Password       string `env:"SMTP_PASSWORD"`
+}
+
+/*
+	Single sign-on through an OpenID Connect provider like Keycloak or Authelia.
+	The *Groups settings are comma separated lists of provider groups, a user gets
+	the highest role one of their groups maps to and DefaultRole otherwise.
+*/
+type OidcConfig struct {
+	Enabled      bool   `env:"OIDC_ENABLED"`
+	Issuer       string `env:"OIDC_ISSUER"`
+	DiscoveryUrl string `env:"OIDC_DISCOVERY_URL"` // Defaults to <issuer>/.well-known/openid-configuration
+	ClientID     string `env:"OIDC_CLIENT_ID"`
+	ClientSecret string `env:"OIDC_CLIENT_SECRET"`
+	RedirectUrl  string `env:"OIDC_REDIRECT_URL"` // Defaults to <server url>/api/auth/oidc/callback
+	Scopes       string `env:"OIDC_SCOPES"`       // Space separated
+	GroupsClaim  string `env:"OIDC_GROUPS_CLAIM"`
+	AutoCreate   bool   `env:"OIDC_AUTO_CREATE"` // Create users on their first login instead of only linking existing ones
+
+	// Link users by email although the provider doesn't send email_verified, only if it checks the addresses itself
+	TrustUnverifiedEmail bool `env:"OIDC_TRUST_UNVERIFIED_EMAIL"`
+
+	AdminGroups       string `env:"OIDC_ADMIN_GROUPS"`
+	EditorGroups      string `env:"OIDC_EDITOR_GROUPS"`
+	ContributorGroups string `env:"OIDC_CONTRIBUTOR_GROUPS"`
+	ViewerGroups      string `env:"OIDC_VIEWER_GROUPS"`
+	DefaultRole       string `env:"OIDC_DEFAULT_ROLE"` // Role of users in none of the groups, "none" to reject them
+}
+
+/*
+	Login against an LDAP or Active Directory server, add ldap to AUTH_ORDER to use it.
+	For Active Directory use a filter like (sAMAccountName={username}).
+	Groups are matched by their full DN or their CN, like for OIDC the highest role wins.
+*/
+type LdapConfig struct {
+	Url                string `env:"LDAP_URL"` // ldap://host:389 or ldaps://host:636
+	StartTLS           bool   `env:"LDAP_START_TLS"`
+	InsecureSkipVerify bool   `env:"LDAP_INSECURE_SKIP_VERIFY"`
+	BindDN             string `env:"LDAP_BIND_DN"` // Service account to look up users, anonymous if empty
+	BindPassword       string `env:"LDAP_BIND_PASSWORD"`
+	BaseDN             string `env:"LDAP_BASE_DN"`
+	UserFilter         string `env:"LDAP_USER_FILTER"`
+	EmailAttribute     string `env:"LDAP_EMAIL_ATTRIBUTE"`
+	GroupAttribute     string `env:"LDAP_GROUP_ATTRIBUTE"`
+	AutoCreate         bool   `env:"LDAP_AUTO_CREATE"`
+
+	AdminGroups       string `env:"LDAP_ADMIN_GROUPS"`
+	EditorGroups      string `env:"LDAP_EDITOR_GROUPS"`
+	ContributorGroups string `env:"LDAP_CONTRIBUTOR_GROUPS"`
+	ViewerGroups      string `env:"LDAP_VIEWER_GROUPS"`
+	DefaultRole       string `env:"LDAP_DEFAULT_ROLE"` // Role of users in none of the groups, "none" to reject them
+}
+
+/*
+	Login through an authenticating reverse proxy like oauth2-proxy or Authelia.
+	Requests from TrustedProxies carrying the user header count as logged in,
+	the header from any other address is rejected. Groups map to roles like for OIDC.
+*/
+type ProxyAuthConfig struct {
+	Enabled        bool   `env:"PROXY_AUTH_ENABLED"`
+	TrustedProxies string `env:"PROXY_AUTH_TRUSTED_PROXIES"` // Comma separated CIDRs or addresses
+	UserHeader     string `env:"PROXY_AUTH_USER_HEADER"`
+	EmailHeader    string `env:"PROXY_AUTH_EMAIL_HEADER"`
+	GroupsHeader   string `env:"PROXY_AUTH_GROUPS_HEADER"` // Comma separated groups, ignored if empty
+	AutoCreate     bool   `env:"PROXY_AUTH_AUTO_CREATE"`
+
+	AdminGroups       string `env:"PROXY_AUTH_ADMIN_GROUPS"`
+	EditorGroups      string `env:"PROXY_AUTH_EDITOR_GROUPS"`
+	ContributorGroups string `env:"PROXY_AUTH_CONTRIBUTOR_GROUPS"`
+	ViewerGroups      string `env:"PROXY_AUTH_VIEWER_GROUPS"`
+	DefaultRole       string `env:"PROXY_AUTH_DEFAULT_ROLE"` // Role of users in none of the groups, "none" to reject them
+}
+
+type DatabaseConfig struct {
+	Driver   string `env:"DB_DRIVER"`
+	Host     string `env:"DB_HOST"`
+	User     string `env:"DB_USER"`
+	Password string `env:"DB_PASSWORD"`
+	Name     string `env:"DB_NAME"`
+	Port     int    `env:"DB_PORT"`
+}
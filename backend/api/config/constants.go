@@ -0,0 +1,5 @@
+package config
+
+const (
+	ADMIN_UID uint32 = 1
+)
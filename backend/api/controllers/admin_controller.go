@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Lockouts caused by too many failed logins or password reset requests, the latest first.
+	Example request:
+		GET /api/admin/lockouts?scope=login&page=1&limit=20
+*/
+func (server *Server) GetLockoutEvents(c *gin.Context) {
+	var form forms.LockoutEventsRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := form.ValidateForm(); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	pagination := models.Pagination{Limit: form.Limit, Page: form.Page}
+	events, err := models.FindLockoutEvents(server.DB, form.Scope, pagination)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// The IPs and accounts which are locked right now
+func (server *Server) GetActiveLockouts(c *gin.Context) {
+	throttles, err := models.FindLockedThrottles(server.DB)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, throttles)
+}
+
+/*
+	Lift a lock before it runs out, e.g. for a user who locked themselves out.
+	Example request:
+		DELETE /api/admin/lockouts/active
+		Body:
+			- key: login:account:someone@example.com
+*/
+func (server *Server) Unlock(c *gin.Context) {
+	var form forms.UnlockRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := models.ResetThrottle(server.DB, form.Key); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "lockout.unlock", "lockout", form.Key, nil, nil)
+	c.JSON(http.StatusOK, "Unlocked "+form.Key)
+}
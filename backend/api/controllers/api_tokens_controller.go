@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
+	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	List the personal API tokens of the user. The token values themselves
+	are only shown once when they get created.
+	Example request:
+		GET /api/me/tokens
+*/
+func (server *Server) GetApiTokens(c *gin.Context) {
+	tokens, err := models.FindApiTokensByUser(server.DB, middlewares.CurrentUser(c).ID)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+/*
+	Create a personal API token for scripts, send it as Bearer token or in the X-Api-Token header.
+	Example request:
+		POST /api/me/tokens
+		Body:
+			- name: scanner
+			- scopes: ["sheets:read", "sheets:write"]
+			- expires_in_days: 90 (optional, never expires by default)
+*/
+func (server *Server) CreateApiToken(c *gin.Context) {
+	var form forms.ApiTokenRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := form.ValidateForm(); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	scopes, err := models.ParseScopes(form.Scopes)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var expiresAt *time.Time
+	if form.ExpiresInDays > 0 {
+		expires := time.Now().AddDate(0, 0, form.ExpiresInDays)
+		expiresAt = &expires
+	}
+
+	token, value, err := models.CreateApiToken(server.DB, middlewares.CurrentUser(c).ID, form.Name, scopes, expiresAt, Config().ApiSecret)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "api_token.create", "api_token", fmt.Sprint(token.ID), nil, models.AuditSnapshot(token))
+	c.JSON(http.StatusCreated, gin.H{
+		"token":     value,
+		"api_token": token,
+	})
+}
+
+/*
+	Revoke a personal API token, scripts using it are locked out right away.
+	Example request:
+		DELETE /api/me/tokens/3
+*/
+func (server *Server) RevokeApiToken(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = models.RevokeApiToken(server.DB, middlewares.CurrentUser(c).ID, uint32(id))
+	if gorm.IsRecordNotFoundError(err) {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("API token %d not found", id))
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "api_token.revoke", "api_token", fmt.Sprint(id), nil, nil)
+	c.JSON(http.StatusOK, "API token was revoked")
+}
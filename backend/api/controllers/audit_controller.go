@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
+	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	The audit log, the latest events first.
+	Example request:
+		GET /api/admin/audit?action=sheet.delete&from=2022-01-01&page=1&limit=50
+	Filters: actor_id, action, target_type, target_id, from and to
+*/
+func (server *Server) GetAuditEvents(c *gin.Context) {
+	var form forms.AuditEventsRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := form.ValidateForm(); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	from, err := parseAuditTime(form.From, false)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	to, err := parseAuditTime(form.To, true)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := models.AuditFilter{
+		ActorID:    form.ActorID,
+		Action:     form.Action,
+		TargetType: form.TargetType,
+		TargetID:   form.TargetID,
+		From:       from,
+		To:         to,
+	}
+	pagination := models.Pagination{Limit: form.Limit, Page: form.Page}
+	events, err := models.FindAuditEvents(server.DB, filter, pagination)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+func parseAuditTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %s, expected 2006-01-02 or RFC 3339", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// Record what the logged in user did, before and after are taken with models.AuditSnapshot
+func (server *Server) audit(c *gin.Context, action string, targetType string, targetID string, before map[string]interface{}, after map[string]interface{}) {
+	server.auditAs(c, middlewares.CurrentUser(c), action, targetType, targetID, before, after)
+}
+
+// Like audit, for requests without a logged in user like the login itself
+func (server *Server) auditAs(c *gin.Context, actor *models.User, action string, targetType string, targetID string, before map[string]interface{}, after map[string]interface{}) {
+	event := models.AuditEvent{
+		IP:         middlewares.ClientIP(c),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+	}
+	if actor != nil {
+		event.ActorID = actor.ID
+		event.ActorEmail = actor.Email
+	}
+
+	// The change already happened, a missing audit event shouldn't fail the request
+	if err := models.RecordAudit(server.DB, event, before, after); err != nil {
+		log.Printf("error recording audit event %s of %s %s: %s", action, targetType, targetID, err.Error())
+	}
+}
+
+func (server *Server) pruneAuditEvents() {
+
+	// Once at the start and then once a day
+	for {
+		retention := time.Duration(Config().AuditRetentionDays) * 24 * time.Hour
+		if deleted, err := models.PruneAuditEvents(server.DB, retention); err != nil {
+			log.Printf("error pruning the audit log: %s", err.Error())
+		} else if deleted > 0 {
+			log.Printf("pruned %d audit events older than %d days", deleted, Config().AuditRetentionDays)
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"sync"
 	"time"
 
+	"github.com/SheetAble/SheetAble/backend/api/auth"
 	. "github.com/SheetAble/SheetAble/backend/api/config"
 	"github.com/gin-gonic/gin"
 
@@ -24,6 +26,10 @@ import (
 type Server struct {
 	DB     *gorm.DB
 	Router *gin.Engine
+
+	// Discovered on the first single sign-on login, see oidc_controller.go
+	oidcProvider *auth.OidcProvider
+	oidcMutex    sync.Mutex
 }
 
 func (server *Server) Initialize() {
@@ -77,7 +83,28 @@ func (server *Server) Initialize() {
 	server.DB.LogMode(false)
 
 	// Migrate DBs
-	server.DB.AutoMigrate(&models.User{}, &models.Sheet{})
+	server.DB.AutoMigrate(&models.User{}, &models.Sheet{}, &models.Composer{}, &models.SavedSearch{}, &models.Tag{}, &models.SheetTag{}, &models.Setlist{}, &models.SetlistEntry{}, &models.Favorite{}, &models.SheetView{}, &models.Session{}, &models.ApiToken{}, &models.RecoveryCode{}, &models.MfaChallenge{}, &models.Throttle{}, &models.LockoutEvent{}, &models.PasswordReset{}, &models.Invitation{}, &models.AuditEvent{}, &models.Group{}, &models.GroupMember{}, &models.EmailConfirmation{})
+
+	// Move tags of older installations into their own table
+	if err := models.MigrateSheetTags(server.DB); err != nil {
+		log.Fatalf("error migrating sheet tags: %s", err.Error())
+	}
+	if err := models.LinkTagParents(server.DB); err != nil {
+		log.Fatalf("error linking nested tags: %s", err.Error())
+	}
+	if err := models.FillSearchNames(server.DB); err != nil {
+		log.Fatalf("error normalizing names for the search: %s", err.Error())
+	}
+
+	// Admin rights used to be tied to the first user, make sure there is still an admin
+	if err := models.EnsureAdmin(server.DB); err != nil {
+		log.Fatalf("error ensuring an admin exists: %s", err.Error())
+	}
+
+	// Keep the typeahead index in sync with the database
+	models.RegisterSuggestIndexCallbacks(server.DB)
+
+	go server.pruneAuditEvents()
 
 	server.SetupRouter()
 }
@@ -97,6 +124,10 @@ func (server *Server) Run(addr string, dev bool) {
 			"Content-Type",
 			"Accept",
 			"Authorization",
+			"X-Api-Token",
+		},
+		ExposedHeaders: []string{
+			"X-Did-You-Mean",
 		},
 		AllowedMethods: []string{
 			http.MethodHead,
@@ -109,7 +140,7 @@ func (server *Server) Run(addr string, dev bool) {
 		AllowCredentials: true,
 	})
 
-	// Check if run in dev mode, so you can enable CORS or not 
+	// Check if run in dev mode, so you can enable CORS or not
 	srvHandler := handlers.LoggingHandler(os.Stdout, c.Handler(server.Router))
 
 	if !dev {
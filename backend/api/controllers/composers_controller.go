@@ -9,9 +9,11 @@ import (
 
 	. "github.com/SheetAble/SheetAble/backend/api/config"
 	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
 	"github.com/SheetAble/SheetAble/backend/api/models"
 	"github.com/SheetAble/SheetAble/backend/api/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	"github.com/kennygrant/sanitize"
 )
 
@@ -33,6 +35,10 @@ func (server *Server) GetComposersPage(c *gin.Context) {
 		utils.DoError(c, http.StatusBadRequest, err)
 		return
 	}
+	if err := form.ValidateForm(); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
 
 	pagination := models.Pagination{
 		Sort:  form.SortBy,
@@ -41,7 +47,7 @@ func (server *Server) GetComposersPage(c *gin.Context) {
 	}
 
 	var composer models.Composer
-	pageNew, err := composer.List(server.DB, pagination)
+	pageNew, err := composer.List(server.DB, pagination, middlewares.CurrentUser(c))
 	if err != nil {
 		utils.DoError(c, http.StatusInternalServerError, err)
 		return
@@ -79,6 +85,12 @@ func (server *Server) UpdateComposer(c *gin.Context) {
 	uploadSuccess := false
 	uploadSuccess = uploadPortait(form, uploadComposerName, composerName)
 
+	var before map[string]interface{}
+	var composerModel models.Composer
+	if original, err := composerModel.FindComposerBySafeName(server.DB, composerName); err == nil {
+		before = models.AuditSnapshot(original)
+	}
+
 	composer := &models.Composer{}
 	newComp, err := composer.UpdateComposer(server.DB,
 		composerName,
@@ -91,9 +103,49 @@ func (server *Server) UpdateComposer(c *gin.Context) {
 		utils.DoError(c, http.StatusNotFound, fmt.Errorf("composer not found: %v", err))
 		return
 	}
+	server.audit(c, "composer.update", "composer", composerName, before, models.AuditSnapshot(newComp))
 	c.JSON(http.StatusOK, newComp)
 }
 
+/*
+	Choose who sees a composer, hiding a composer hides all of its sheets as well.
+	Example request:
+		PUT /api/composer/chopin/visibility
+		Body:
+			- visibility: private
+*/
+func (server *Server) SetComposerVisibility(c *gin.Context) {
+	composerName := c.Param("composerName")
+	var composerModel models.Composer
+	composer, err := composerModel.FindComposerBySafeName(server.DB, composerName)
+	if gorm.IsRecordNotFoundError(err) {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("composer not found: %s", composerName))
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	var form forms.VisibilityRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	visibility, groupID, ok := server.validateVisibility(c, form.Visibility, form.GroupID)
+	if !ok {
+		return
+	}
+
+	before := models.AuditSnapshot(composer)
+	if err := composer.SetVisibility(server.DB, visibility, groupID); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "composer.visibility", "composer", composer.SafeName, before, models.AuditSnapshot(composer))
+	c.JSON(http.StatusOK, composer)
+}
+
 func (server *Server) DeleteComposer(c *gin.Context) {
 	composerName := c.Param("composerName")
 	if composerName == "" {
@@ -101,12 +153,19 @@ func (server *Server) DeleteComposer(c *gin.Context) {
 		return
 	}
 
+	var before map[string]interface{}
+	var composerModel models.Composer
+	if original, err := composerModel.FindComposerBySafeName(server.DB, composerName); err == nil {
+		before = models.AuditSnapshot(original)
+	}
+
 	composer := &models.Composer{}
 	_, err := composer.DeleteComposer(server.DB, composerName)
 	if err != nil {
 		utils.DoError(c, http.StatusNotFound, fmt.Errorf("failed to delete composer, composer not found: %v", err))
 		return
 	}
+	server.audit(c, "composer.delete", "composer", composerName, before, nil)
 
 	c.JSON(http.StatusOK, "Composer deleted successfully")
 }
@@ -115,10 +174,27 @@ func (server *Server) DeleteComposer(c *gin.Context) {
 	Serve the Composer Portraits
 	Example request:
 		GET /composer/portrait/Chopin
+	Portraits of composers hidden from the user are reported as missing
 */
 func (server *Server) ServePortraits(c *gin.Context) {
 	name := c.Param("composerName")
-	filePath := path.Join(Config().ConfigPath, "composer", name+".png")
+
+	var composerModel models.Composer
+	composer, err := composerModel.FindComposerBySafeName(server.DB, name)
+	visible := false
+	if err == nil {
+		visible, err = composer.VisibleTo(server.DB, middlewares.CurrentUser(c))
+	}
+	if gorm.IsRecordNotFoundError(err) || (err == nil && !visible) {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("unable to find composer: %s", name))
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	filePath := path.Join(Config().ConfigPath, "composer", composer.SafeName+".png")
 	c.File(filePath)
 }
 
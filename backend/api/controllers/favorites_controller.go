@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
+	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	Add a sheet to the favorites of the user.
+	Example request:
+		POST /api/sheet/clair-de-lune/star
+*/
+func (server *Server) StarSheet(c *gin.Context) {
+	uid := middlewares.CurrentUser(c).ID
+
+	sheet := getSheet(server.DB, c)
+	if sheet == nil {
+		return
+	}
+	err := models.StarSheet(server.DB, uid, sheet.SafeSheetName)
+	if gorm.IsRecordNotFoundError(err) {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("unable to find sheet: %s", sheet.SafeSheetName))
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, "Sheet was added to your favorites")
+}
+
+/*
+	Remove a sheet from the favorites of the user.
+	Example request:
+		DELETE /api/sheet/clair-de-lune/star
+*/
+func (server *Server) UnstarSheet(c *gin.Context) {
+	uid := middlewares.CurrentUser(c).ID
+
+	if err := models.UnstarSheet(server.DB, uid, c.Param("sheetName")); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, "Sheet was removed from your favorites")
+}
+
+/*
+	Return the favorite sheets of the user, the most recently starred first.
+	Example request:
+		GET /api/me/favorites?page=1&limit=10
+*/
+func (server *Server) GetFavorites(c *gin.Context) {
+	server.getUserSheets(c, models.FavoriteSheets)
+}
+
+/*
+	Return the sheets the user opened lately, the most recent first.
+	Example request:
+		GET /api/me/recent?page=1&limit=10
+*/
+func (server *Server) GetRecentSheets(c *gin.Context) {
+	server.getUserSheets(c, models.RecentSheets)
+}
+
+func (server *Server) getUserSheets(c *gin.Context, list func(*gorm.DB, *models.User, models.Pagination) (*models.Pagination, error)) {
+
+	var form forms.UserSheetsRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := form.ValidateForm(); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	pagination := models.Pagination{
+		Limit: form.Limit,
+		Page:  form.Page,
+	}
+	page, err := list(server.DB, middlewares.CurrentUser(c), pagination)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func (server *Server) recordSheetView(c *gin.Context, sheetName string) {
+
+	// Remember that the user opened the sheet, failing to do so never fails the request
+	uid := middlewares.CurrentUser(c).ID
+	if err := models.RecordSheetView(server.DB, uid, sheetName); err != nil && !gorm.IsRecordNotFoundError(err) {
+		log.Printf("unable to record view of %s: %s\n", sheetName, err.Error())
+	}
+}
@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
+	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	Groups to share sheets with, users who manage users get all of them
+	and everybody else the groups they are a member of.
+	Example request:
+		GET /api/groups
+*/
+func (server *Server) GetGroups(c *gin.Context) {
+	user := middlewares.CurrentUser(c)
+
+	var groups []*models.Group
+	var err error
+	if user.Can(models.PermissionManageUsers) {
+		groups, err = models.FindAllGroups(server.DB)
+	} else {
+		groups, err = models.FindGroupsOfUser(server.DB, user.ID)
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+/*
+	Example request:
+		POST /api/admin/groups
+		Body:
+			- name: Choir
+*/
+func (server *Server) CreateGroup(c *gin.Context) {
+	var form forms.GroupRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	group := models.Group{Name: form.Name}
+	group.Prepare()
+	if err := group.Validate(); err != nil {
+		utils.DoError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	groupCreated, err := group.SaveGroup(server.DB)
+	if err == models.ErrGroupExists {
+		utils.DoError(c, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "group.create", "group", fmt.Sprint(groupCreated.ID), nil, models.AuditSnapshot(groupCreated))
+	c.JSON(http.StatusCreated, groupCreated)
+}
+
+/*
+	Rename a group.
+	Example request:
+		PUT /api/admin/groups/2
+		Body:
+			- name: Chamber choir
+*/
+func (server *Server) UpdateGroup(c *gin.Context) {
+	group := server.getGroup(c)
+	if group == nil {
+		return
+	}
+	var form forms.GroupRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	before := models.AuditSnapshot(group)
+	group.Name = form.Name
+	group.Prepare()
+	if err := group.Validate(); err != nil {
+		utils.DoError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	groupUpdated, err := group.UpdateGroup(server.DB)
+	if err == models.ErrGroupExists {
+		utils.DoError(c, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "group.update", "group", fmt.Sprint(group.ID), before, models.AuditSnapshot(groupUpdated))
+	c.JSON(http.StatusOK, groupUpdated)
+}
+
+/*
+	Delete a group, the sheets and composers shared with it become private.
+	Example request:
+		DELETE /api/admin/groups/2
+*/
+func (server *Server) DeleteGroup(c *gin.Context) {
+	group := server.getGroup(c)
+	if group == nil {
+		return
+	}
+	if err := group.DeleteGroup(server.DB); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "group.delete", "group", fmt.Sprint(group.ID), models.AuditSnapshot(group), nil)
+	c.JSON(http.StatusOK, "Group was deleted")
+}
+
+/*
+	Example request:
+		POST /api/admin/groups/2/members
+		Body:
+			- user_id: 5
+*/
+func (server *Server) AddGroupMember(c *gin.Context) {
+	group := server.getGroup(c)
+	if group == nil {
+		return
+	}
+	var form forms.GroupMemberRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err := group.AddMember(server.DB, form.UserID)
+	if gorm.IsRecordNotFoundError(err) {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("user %d not found", form.UserID))
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "group.member_add", "group", fmt.Sprint(group.ID), nil, gin.H{"user_id": form.UserID})
+	c.JSON(http.StatusOK, group)
+}
+
+/*
+	Example request:
+		DELETE /api/admin/groups/2/members/5
+*/
+func (server *Server) RemoveGroupMember(c *gin.Context) {
+	group := server.getGroup(c)
+	if group == nil {
+		return
+	}
+	uid, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := group.RemoveMember(server.DB, uint32(uid)); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "group.member_remove", "group", fmt.Sprint(group.ID), gin.H{"user_id": uid}, nil)
+	c.JSON(http.StatusOK, group)
+}
+
+func (server *Server) getGroup(c *gin.Context) *models.Group {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return nil
+	}
+	group, err := models.FindGroupByID(server.DB, uint32(id))
+	if gorm.IsRecordNotFoundError(err) {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("group %d not found", id))
+		return nil
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return nil
+	}
+	return group
+}
+
+func (server *Server) validateVisibility(c *gin.Context, visibility string, groupID uint32) (string, uint32, bool) {
+	visibility, groupID, err := models.ValidateVisibility(server.DB, middlewares.CurrentUser(c), visibility, groupID)
+	switch err {
+	case nil:
+		return visibility, groupID, true
+	case models.ErrNotGroupMember:
+		utils.DoError(c, http.StatusForbidden, err)
+	case models.ErrUnknownVisibility, models.ErrVisibilityGroup:
+		utils.DoError(c, http.StatusUnprocessableEntity, err)
+	default:
+		utils.DoError(c, http.StatusInternalServerError, err)
+	}
+	return "", 0, false
+}
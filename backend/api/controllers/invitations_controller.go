@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
+	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/SheetAble/SheetAble/backend/api/utils/formaterror"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// Invitations which weren't accepted yet
+func (server *Server) GetInvitations(c *gin.Context) {
+	invitations, err := models.FindPendingInvitations(server.DB)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, invitations)
+}
+
+/*
+	Invite someone by email, they choose their password through the link in it.
+	The link is part of the response as well, to pass it on when SMTP isn't set up.
+	Example request:
+		POST /api/admin/invitations
+		Body:
+			- email: someone@example.com
+			- role: contributor
+*/
+func (server *Server) CreateInvitation(c *gin.Context) {
+	var form forms.InvitationRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	role, err := models.ParseRole(form.Role)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	invitation, token, err := models.CreateInvitation(server.DB, form.Email, role, middlewares.CurrentUser(c).ID, invitationTTL(), Config().ApiSecret)
+	if err == models.ErrInvitationUserExists {
+		utils.DoError(c, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	server.audit(c, "invitation.create", "invitation", fmt.Sprint(invitation.ID), nil, models.AuditSnapshot(invitation))
+	server.sendInvitation(c, http.StatusCreated, invitation, token)
+}
+
+/*
+	Send an invitation again with a fresh expiry, the link sent before stops working.
+	Example request:
+		POST /api/admin/invitations/3/resend
+*/
+func (server *Server) ResendInvitation(c *gin.Context) {
+	invitation, ok := server.findPendingInvitation(c)
+	if !ok {
+		return
+	}
+	token, err := invitation.Resend(server.DB, invitationTTL(), Config().ApiSecret)
+	if err == models.ErrInvitationUserExists {
+		utils.DoError(c, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "invitation.resend", "invitation", fmt.Sprint(invitation.ID), nil, gin.H{"expires_at": invitation.ExpiresAt})
+	server.sendInvitation(c, http.StatusOK, invitation, token)
+}
+
+/*
+	Revoke an invitation, its link stops working.
+	Example request:
+		DELETE /api/admin/invitations/3
+*/
+func (server *Server) RevokeInvitation(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	err = models.RevokeInvitation(server.DB, uint32(id))
+	if gorm.IsRecordNotFoundError(err) {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("pending invitation %d not found", id))
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "invitation.revoke", "invitation", fmt.Sprint(id), nil, nil)
+	c.JSON(http.StatusOK, "Invitation was revoked")
+}
+
+/*
+	The invitee sets their password, which creates their user.
+	Example request:
+		POST /api/invitations/<token of the link>/accept
+		Body:
+			- password: their password
+*/
+func (server *Server) AcceptInvitation(c *gin.Context) {
+	var form forms.AcceptInvitationRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := models.AcceptInvitation(server.DB, c.Param("token"), form.Password, Config().ApiSecret)
+	if err == models.ErrInvalidInvitation {
+		utils.DoError(c, http.StatusNotFound, err)
+		return
+	}
+	if err == models.ErrInvitationUserExists {
+		utils.DoError(c, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusUnprocessableEntity, formaterror.FormatError(err.Error()))
+		return
+	}
+	server.auditAs(c, user, "invitation.accept", "user", fmt.Sprint(user.ID), nil, models.AuditSnapshot(user))
+	c.JSON(http.StatusCreated, user)
+}
+
+func (server *Server) findPendingInvitation(c *gin.Context) (*models.Invitation, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return nil, false
+	}
+	invitation, err := models.FindPendingInvitation(server.DB, uint32(id))
+	if gorm.IsRecordNotFoundError(err) {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("pending invitation %d not found", id))
+		return nil, false
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return invitation, true
+}
+
+func (server *Server) sendInvitation(c *gin.Context, status int, invitation *models.Invitation, token string) {
+	if err := utils.SendInvitationEmail(token, invitation.Email, invitationTTL()); err != nil {
+		utils.DoError(c, http.StatusBadGateway, fmt.Errorf("the invitation was saved but the email could not be sent: %s", err.Error()))
+		return
+	}
+	c.JSON(status, gin.H{
+		"invitation": invitation,
+		"link":       utils.InvitationLink(token),
+		"email_sent": Config().Smtp.Enabled != "0",
+	})
+}
+
+func invitationTTL() time.Duration {
+	return time.Duration(Config().InvitationDays) * 24 * time.Hour
+}
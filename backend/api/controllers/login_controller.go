@@ -1,17 +1,34 @@
 package controllers
 
 import (
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/SheetAble/SheetAble/backend/api/auth"
 	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
 	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
 	"github.com/SheetAble/SheetAble/backend/api/utils/formaterror"
-	"golang.org/x/crypto/bcrypt"
 )
 
+const refreshTokenCookie = "refresh_token"
+
+/*
+	Log in with email and password.
+	Responds with a short-lived access token, the refresh token to get a new one
+	is only set as an http-only cookie, so scripts on the page can't read it.
+	Users with two-factor authentication get {mfa_required, challenge_id} instead
+	and continue at /api/login/mfa.
+*/
 func (server *Server) Login(c *gin.Context) {
 	var user models.User
 	err := c.BindJSON(&user)
@@ -21,33 +38,228 @@ func (server *Server) Login(c *gin.Context) {
 	}
 
 	user.Prepare()
-	err = user.Validate("login")
+	validation := "login"
+	if strings.Contains(strings.ToLower(Config().AuthOrder), "ldap") {
+		// Directory users may log in with their username instead of an email
+		validation = "username-login"
+	}
+	err = user.Validate(validation)
 	if err != nil {
 		c.String(http.StatusUnprocessableEntity, err.Error())
 		return
 	}
-	token, err := server.SignIn(user.Email, user.Password)
+
+	ipKey, accountKey := loginThrottleKeys(c, user.Email)
+	if server.throttled(c, ipKey, accountKey) {
+		return
+	}
+	signedIn, err := server.SignIn(user.Email, user.Password)
+	if models.IsExternalLoginRejected(err) || err == models.ErrAccountPending || err == models.ErrEmailUnconfirmed {
+		server.auditAs(c, nil, "login.rejected", "user", user.Email, nil, gin.H{"reason": err.Error()})
+		c.String(http.StatusForbidden, err.Error())
+		return
+	}
 	if err != nil {
+		server.registerLoginFailure(c, user.Email)
 		formattedError := formaterror.FormatError(err.Error())
 		c.String(http.StatusUnprocessableEntity, formattedError.Error())
 		return
 	}
-	c.JSON(http.StatusOK, token)
+
+	// With two-factor authentication the token is only handed out by LoginMfa
+	if signedIn.TotpEnabled {
+		challenge, err := models.CreateMfaChallenge(server.DB, signedIn.ID)
+		if err != nil {
+			utils.DoError(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"challenge_id": challenge.ID,
+		})
+		return
+	}
+
+	// Failures only count until the login is complete, so the second factor can't be guessed in between
+	models.ResetThrottle(server.DB, accountKey)
+	session, refreshToken, err := models.CreateSession(server.DB, signedIn.ID, c.Request.UserAgent(), refreshTokenTTL())
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.auditAs(c, signedIn, "login.success", "user", fmt.Sprint(signedIn.ID), nil, gin.H{"method": "password"})
+	server.respondWithTokens(c, session, refreshToken)
 }
 
-func (server *Server) SignIn(email, password string) (string, error) {
+func (server *Server) SignIn(login, password string) (*models.User, error) {
 
-	var err error
+	// Local passwords, LDAP or both, in the order of AUTH_ORDER
+	authenticators, err := models.Authenticators(Config().AuthOrder, Config().Ldap)
+	if err != nil {
+		return nil, err
+	}
+	return models.SignIn(server.DB, authenticators, login, password)
+}
+
+/*
+	Trade a refresh token for a new access token. The refresh token rotates,
+	the old one can't be used again.
+	Example request:
+		POST /api/token/refresh
+		Body:
+			- refresh_token: (optional if the refresh_token cookie is set)
+*/
+func (server *Server) RefreshToken(c *gin.Context) {
+	var form forms.RefreshTokenRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	if form.RefreshToken == "" {
+		form.RefreshToken, _ = c.Cookie(refreshTokenCookie)
+	}
+	if form.RefreshToken == "" {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
 
-	user := models.User{}
+	session, refreshToken, err := models.RotateSession(server.DB, form.RefreshToken, refreshTokenTTL())
+	if err == models.ErrInvalidRefreshToken {
+		clearRefreshTokenCookie(c)
+		utils.DoError(c, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.respondWithTokens(c, session, refreshToken)
+}
 
-	err = server.DB.Model(models.User{}).Where("email = ?", email).Take(&user).Error
+/*
+	End the current session, its access and refresh tokens stop working right away.
+	Example request:
+		POST /api/logout
+		Body:
+			- all: true (optional, log out on every device)
+*/
+func (server *Server) Logout(c *gin.Context) {
+	uid, sessionID, err := auth.ExtractTokenSession(utils.ExtractToken(c), Config().ApiSecret)
 	if err != nil {
-		return "", err
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
 	}
-	err = models.VerifyPassword(user.Password, password)
-	if err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
-		return "", err
+
+	var form forms.LogoutRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if form.All {
+		err = models.RevokeUserSessions(server.DB, uid, "")
+	} else {
+		err = models.RevokeSession(server.DB, sessionID)
 	}
-	return auth.CreateToken(user.ID, Config().ApiSecret)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	clearRefreshTokenCookie(c)
+	server.audit(c, "logout", "user", fmt.Sprint(uid), nil, gin.H{"all": form.All})
+	c.JSON(http.StatusOK, "Logged out successfully")
+}
+
+/*
+	Lets the frontend know whether the reverse proxy logs users in, see PROXY_AUTH_ENABLED.
+	authenticated tells if this request came with the user header of a trusted proxy.
+*/
+func (server *Server) GetProxyAuthStatus(c *gin.Context) {
+	proxyConfig := Config().ProxyAuth
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":       proxyConfig.Enabled,
+		"authenticated": proxyConfig.Enabled && c.GetHeader(proxyConfig.UserHeader) != "",
+	})
+}
+
+func (server *Server) respondWithTokens(c *gin.Context, session *models.Session, refreshToken string) {
+
+	// The body stays the plain access token, clients that only know about it keep working
+	accessToken, err := auth.CreateToken(session.UserID, session.ID, time.Duration(Config().AccessTokenMinutes)*time.Minute, Config().ApiSecret)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	mediaToken, err := auth.CreateMediaToken(session.UserID, session.ID, refreshTokenTTL(), Config().ApiSecret)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	setRefreshToken(c, refreshToken)
+	setMediaToken(c, mediaToken)
+	c.JSON(http.StatusOK, accessToken)
+}
+
+func loginThrottleKeys(c *gin.Context, account string) (string, string) {
+	return models.ThrottleKey(models.LoginIPRule.Scope, "ip", middlewares.ClientIP(c)),
+		models.ThrottleKey(models.LoginAccountRule.Scope, "account", account)
+}
+
+func (server *Server) registerLoginFailure(c *gin.Context, account string) {
+
+	// Failed logins have no user yet, their target is the login that was tried
+	server.auditAs(c, nil, "login.failure", "user", account, nil, nil)
+
+	ipKey, accountKey := loginThrottleKeys(c, account)
+	if err := models.RegisterThrottleFailure(server.DB, models.LoginIPRule, ipKey, middlewares.ClientIP(c), account); err != nil {
+		log.Printf("error counting failed login: %s", err.Error())
+	}
+	if err := models.RegisterThrottleFailure(server.DB, models.LoginAccountRule, accountKey, middlewares.ClientIP(c), account); err != nil {
+		log.Printf("error counting failed login: %s", err.Error())
+	}
+}
+
+func (server *Server) throttled(c *gin.Context, keys ...string) bool {
+
+	// Responds with 429 if one of the keys is locked because of too many attempts
+	retryAfter, err := models.ThrottleRetryAfter(server.DB, keys...)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return true
+	}
+	if retryAfter <= 0 {
+		return false
+	}
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.String(http.StatusTooManyRequests, fmt.Sprintf("Too many attempts, try again in %s", (time.Duration(seconds)*time.Second).String()))
+	return true
+}
+
+func setRefreshToken(c *gin.Context, refreshToken string) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(refreshTokenCookie, refreshToken, int(refreshTokenTTL().Seconds()), "/api", "", strings.HasPrefix(Config().ServerUrl, "https"), true)
+}
+
+func clearRefreshTokenCookie(c *gin.Context) {
+	c.SetCookie(refreshTokenCookie, "", -1, "/api", "", strings.HasPrefix(Config().ServerUrl, "https"), true)
+	for _, cookiePath := range mediaTokenPaths {
+		c.SetCookie(middlewares.MediaTokenCookie, "", -1, cookiePath, "", strings.HasPrefix(Config().ServerUrl, "https"), true)
+	}
+}
+
+// The media token is only sent along with requests for images
+var mediaTokenPaths = []string{"/api/sheet/thumbnail", "/api/composer/portrait"}
+
+func setMediaToken(c *gin.Context, mediaToken string) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	for _, cookiePath := range mediaTokenPaths {
+		c.SetCookie(middlewares.MediaTokenCookie, mediaToken, int(refreshTokenTTL().Seconds()), cookiePath, "", strings.HasPrefix(Config().ServerUrl, "https"), true)
+	}
+}
+
+func refreshTokenTTL() time.Duration {
+	return time.Duration(Config().RefreshTokenDays) * 24 * time.Hour
 }
@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
+	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Second step of the login for users with two-factor authentication.
+	Example request:
+		POST /api/login/mfa
+		Body:
+			- challenge_id: (from the response of /api/login)
+			- code: 123456 (or one of the recovery codes)
+*/
+func (server *Server) LoginMfa(c *gin.Context) {
+	var form forms.MfaLoginRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	challengeUser, err := models.FindMfaChallengeUser(server.DB, form.ChallengeID)
+	if errors.Is(err, models.ErrInvalidMfaChallenge) {
+		utils.DoError(c, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Wrong codes count against the account like wrong passwords
+	ipKey, accountKey := loginThrottleKeys(c, challengeUser.Email)
+	if server.throttled(c, ipKey, accountKey) {
+		return
+	}
+	user, err := models.CompleteMfaChallenge(server.DB, form.ChallengeID, form.Code)
+	if errors.Is(err, models.ErrInvalidMfaCode) || errors.Is(err, models.ErrInvalidMfaChallenge) {
+		server.registerLoginFailure(c, challengeUser.Email)
+		utils.DoError(c, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	models.ResetThrottle(server.DB, accountKey)
+
+	session, refreshToken, err := models.CreateSession(server.DB, user.ID, c.Request.UserAgent(), refreshTokenTTL())
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.auditAs(c, user, "login.success", "user", fmt.Sprint(user.ID), nil, gin.H{"method": "password+totp"})
+	server.respondWithTokens(c, session, refreshToken)
+}
+
+// Whether two-factor authentication is on and how many recovery codes are left
+func (server *Server) GetMfaStatus(c *gin.Context) {
+	user := middlewares.CurrentUser(c)
+	remaining, err := models.RemainingRecoveryCodes(server.DB, user.ID)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"totp_enabled":        user.TotpEnabled,
+		"recovery_codes_left": remaining,
+	})
+}
+
+/*
+	Start setting up an authenticator app. Show the uri as QR code and
+	confirm with a code of the app at /api/me/mfa/totp/verify.
+	Example request:
+		POST /api/me/mfa/totp
+*/
+func (server *Server) StartTotpEnrollment(c *gin.Context) {
+	user := middlewares.CurrentUser(c)
+	secret, err := user.StartTotpEnrollment(server.DB)
+	if errors.Is(err, models.ErrMfaAlreadyEnabled) {
+		utils.DoError(c, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret": secret,
+		"uri":    auth.TotpURI(secret, "SheetAble", user.Email),
+	})
+}
+
+/*
+	Turn two-factor authentication on with a code of the newly set up app.
+	Responds with the recovery codes, they are only shown this once.
+	Example request:
+		POST /api/me/mfa/totp/verify
+		Body:
+			- code: 123456
+*/
+func (server *Server) EnableTotp(c *gin.Context) {
+	var form forms.MfaCodeRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	codes, err := middlewares.CurrentUser(c).EnableTotp(server.DB, form.Code)
+	if errors.Is(err, models.ErrMfaAlreadyEnabled) {
+		utils.DoError(c, http.StatusConflict, err)
+		return
+	}
+	if errors.Is(err, models.ErrInvalidMfaCode) || errors.Is(err, models.ErrMfaEnrollmentNeeded) {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	user := middlewares.CurrentUser(c)
+	server.audit(c, "user.mfa_enable", "user", fmt.Sprint(user.ID), gin.H{"totp_enabled": false}, gin.H{"totp_enabled": true})
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+/*
+	Turn two-factor authentication off, needs a current code.
+	Example request:
+		DELETE /api/me/mfa/totp
+		Body:
+			- code: 123456
+*/
+func (server *Server) DisableTotp(c *gin.Context) {
+	user, ok := server.verifyMfaCode(c)
+	if !ok {
+		return
+	}
+	if err := user.DisableTotp(server.DB); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "user.mfa_disable", "user", fmt.Sprint(user.ID), gin.H{"totp_enabled": true}, gin.H{"totp_enabled": false})
+	c.JSON(http.StatusOK, "Two-factor authentication was turned off")
+}
+
+/*
+	Replace the recovery codes, needs a current code.
+	Example request:
+		POST /api/me/mfa/recovery-codes
+		Body:
+			- code: 123456
+*/
+func (server *Server) RegenerateRecoveryCodes(c *gin.Context) {
+	user, ok := server.verifyMfaCode(c)
+	if !ok {
+		return
+	}
+	codes, err := models.NewRecoveryCodes(server.DB, user.ID)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "user.mfa_recovery_codes", "user", fmt.Sprint(user.ID), nil, nil)
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+/*
+	Turn two-factor authentication off for a user who lost their app and recovery codes (admins only).
+	Example request:
+		DELETE /api/users/2/mfa
+*/
+func (server *Server) ResetUserMfa(c *gin.Context) {
+	uid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var userModel models.User
+	user, err := userModel.FindUserByID(server.DB, uint32(uid))
+	if err != nil {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("user %d not found", uid))
+		return
+	}
+	before := models.AuditSnapshot(user)
+	if err := user.DisableTotp(server.DB); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "user.mfa_reset", "user", fmt.Sprint(user.ID), before, models.AuditSnapshot(user))
+	c.JSON(http.StatusOK, user)
+}
+
+func (server *Server) verifyMfaCode(c *gin.Context) (*models.User, bool) {
+	var form forms.MfaCodeRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	user := middlewares.CurrentUser(c)
+	err := user.VerifyMfaCode(server.DB, form.Code)
+	if errors.Is(err, models.ErrInvalidMfaCode) || errors.Is(err, models.ErrMfaNotEnabled) {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return nil, false
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const oidcFlowCookie = "oidc_flow"
+
+// Lets the login page know whether to offer single sign-on
+func (server *Server) GetOidcStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"enabled": Config().Oidc.Enabled})
+}
+
+/*
+	Start a single sign-on login, redirects to the identity provider.
+	Example request:
+		GET /api/auth/oidc/login
+*/
+func (server *Server) OidcLogin(c *gin.Context) {
+	if !Config().Oidc.Enabled {
+		utils.DoError(c, http.StatusNotFound, errors.New("single sign-on is not enabled"))
+		return
+	}
+	provider, err := server.oidc()
+	if err != nil {
+		utils.DoError(c, http.StatusBadGateway, err)
+		return
+	}
+
+	flow, codeChallenge, err := auth.NewOidcFlow()
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	signedFlow, err := flow.Sign(10*time.Minute, Config().ApiSecret)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Lax, the cookie has to come along when the provider redirects back
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcFlowCookie, signedFlow, 600, "/api/auth/oidc", "", strings.HasPrefix(Config().ServerUrl, "https"), true)
+	c.Redirect(http.StatusFound, provider.AuthCodeURL(flow.State, flow.Nonce, codeChallenge))
+}
+
+/*
+	The identity provider redirects here after the login.
+	Creates or links the user, starts a session and sends the browser to the app,
+	which trades the refresh token cookie for an access token.
+*/
+func (server *Server) OidcCallback(c *gin.Context) {
+	if !Config().Oidc.Enabled {
+		utils.DoError(c, http.StatusNotFound, errors.New("single sign-on is not enabled"))
+		return
+	}
+	if providerError := c.Query("error"); providerError != "" {
+		utils.DoError(c, http.StatusUnauthorized, errors.New(strings.TrimSpace(providerError+" "+c.Query("error_description"))))
+		return
+	}
+
+	signedFlow, _ := c.Cookie(oidcFlowCookie)
+	c.SetCookie(oidcFlowCookie, "", -1, "/api/auth/oidc", "", strings.HasPrefix(Config().ServerUrl, "https"), true)
+	flow, err := auth.ParseOidcFlow(signedFlow, Config().ApiSecret)
+	if err != nil || c.Query("state") != flow.State {
+		utils.DoError(c, http.StatusBadRequest, errors.New("the login expired or was started somewhere else, please try again"))
+		return
+	}
+
+	provider, err := server.oidc()
+	if err != nil {
+		utils.DoError(c, http.StatusBadGateway, err)
+		return
+	}
+	identity, err := provider.Exchange(c.Query("code"), flow.CodeVerifier, flow.Nonce)
+	if err != nil {
+		utils.DoError(c, http.StatusUnauthorized, err)
+		return
+	}
+
+	user, err := models.SignInWithOidc(server.DB, identity, Config().Oidc)
+	if models.IsExternalLoginRejected(err) || err == models.ErrAccountPending {
+		server.auditAs(c, nil, "login.rejected", "user", identity.Email, nil, gin.H{"reason": err.Error(), "method": "oidc"})
+		utils.DoError(c, http.StatusForbidden, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, refreshToken, err := models.CreateSession(server.DB, user.ID, c.Request.UserAgent(), refreshTokenTTL())
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.auditAs(c, user, "login.success", "user", fmt.Sprint(user.ID), nil, gin.H{"method": "oidc"})
+	setRefreshToken(c, refreshToken)
+	c.Redirect(http.StatusFound, strings.TrimSuffix(Config().ServerUrl, "/")+"/login/oidc")
+}
+
+func (server *Server) oidc() (*auth.OidcProvider, error) {
+
+	// Discover the provider once it is needed, so SheetAble starts even if it is down
+	server.oidcMutex.Lock()
+	defer server.oidcMutex.Unlock()
+	if server.oidcProvider != nil {
+		return server.oidcProvider, nil
+	}
+
+	oidcConfig := Config().Oidc
+	provider, err := auth.DiscoverOidcProvider(&http.Client{Timeout: 10 * time.Second}, oidcConfig.Issuer, oidcConfig.DiscoveryUrl)
+	if err != nil {
+		return nil, err
+	}
+	provider.ClientID = oidcConfig.ClientID
+	provider.ClientSecret = oidcConfig.ClientSecret
+	provider.RedirectURL = oidcConfig.RedirectUrl
+	if provider.RedirectURL == "" {
+		provider.RedirectURL = strings.TrimSuffix(Config().ServerUrl, "/") + "/api/auth/oidc/callback"
+	}
+	provider.Scopes = strings.Fields(oidcConfig.Scopes)
+	provider.GroupsClaim = oidcConfig.GroupsClaim
+
+	server.oidcProvider = provider
+	return provider, nil
+}
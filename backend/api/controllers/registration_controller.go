@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
+	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/SheetAble/SheetAble/backend/api/utils/formaterror"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// Lets the login page know whether to offer signing up: closed, approval or open
+func (server *Server) GetRegistrationStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"mode": registrationMode()})
+}
+
+// Without SMTP nobody could confirm their email address, so the registration stays closed
+func registrationMode() string {
+	if Config().Smtp.Enabled == "0" {
+		return models.RegistrationClosed
+	}
+	return models.RegistrationMode(Config().Registration)
+}
+
+/*
+	Sign up, only if REGISTRATION isn't closed.
+	The user gets an email with a link to confirm their address, see ConfirmEmail.
+	Example request:
+		POST /api/register
+		Body:
+			- email: someone@example.com
+			- password: their password
+*/
+func (server *Server) Register(c *gin.Context) {
+	mode := registrationMode()
+	if mode == models.RegistrationClosed {
+		utils.DoError(c, http.StatusNotFound, models.ErrRegistrationClosed)
+		return
+	}
+	var form forms.RegisterRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Every request counts, so nobody can flood the instance with accounts
+	ipKey := models.ThrottleKey(models.RegisterIPRule.Scope, "ip", middlewares.ClientIP(c))
+	if server.throttled(c, ipKey) {
+		return
+	}
+	if err := models.RegisterThrottleFailure(server.DB, models.RegisterIPRule, ipKey, middlewares.ClientIP(c), form.Email); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	role, err := models.ParseRole(Config().RegistrationRole)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	user, err := models.Register(server.DB, form.Email, form.Password, role, mode)
+	if err != nil {
+		utils.DoError(c, http.StatusUnprocessableEntity, formaterror.FormatError(err.Error()))
+		return
+	}
+	server.auditAs(c, user, "user.register", "user", fmt.Sprint(user.ID), nil, models.AuditSnapshot(user))
+
+	token, err := models.CreateEmailConfirmation(server.DB, user.ID, confirmationTTL())
+	if err == nil {
+		err = utils.SendEmailConfirmationEmail(token, user.Email, confirmationTTL())
+	}
+	if err != nil {
+		// Nobody could ever confirm the user, so the address may register again right away
+		if _, err := user.DeleteAUser(server.DB, user.ID); err != nil {
+			log.Printf("error removing the registration of user %d: %s", user.ID, err.Error())
+		}
+		utils.DoError(c, http.StatusBadGateway, fmt.Errorf("the confirmation email could not be sent: %s", err.Error()))
+		return
+	}
+	c.JSON(http.StatusAccepted, user)
+}
+
+/*
+	Confirm the email address of a registration with the link of the email.
+	With approval the admins get told about the registration now.
+	Example request:
+		POST /api/register/confirm/<token of the link>
+*/
+func (server *Server) ConfirmEmail(c *gin.Context) {
+	user, err := models.ConfirmEmail(server.DB, c.Param("token"))
+	if err == models.ErrInvalidEmailConfirmation {
+		utils.DoError(c, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.auditAs(c, user, "user.confirm_email", "user", fmt.Sprint(user.ID), nil, nil)
+
+	if user.Pending {
+		go func() {
+			admins, err := models.FindAdminEmails(server.DB)
+			if err == nil {
+				err = utils.SendRegistrationRequestEmail(admins, user.Email)
+			}
+			if err != nil {
+				log.Printf("error notifying admins about the registration of user %d: %s", user.ID, err.Error())
+			}
+		}()
+	}
+	c.JSON(http.StatusOK, gin.H{"pending": user.Pending})
+}
+
+func confirmationTTL() time.Duration {
+	return time.Duration(Config().ConfirmationHours) * time.Hour
+}
+
+// Registrations waiting for an admin
+func (server *Server) GetPendingRegistrations(c *gin.Context) {
+	users, err := models.FindPendingUsers(server.DB)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+/*
+	Let a registered user log in.
+	Example request:
+		POST /api/admin/registrations/4/approve
+		Body:
+			- role: contributor (optional, REGISTRATION_ROLE otherwise)
+*/
+func (server *Server) ApproveRegistration(c *gin.Context) {
+	user, ok := server.findPendingUser(c)
+	if !ok {
+		return
+	}
+	var form forms.ApproveRegistrationRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	role := user.Role
+	if form.Role != "" {
+		var err error
+		if role, err = models.ParseRole(form.Role); err != nil {
+			utils.DoError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	before := models.AuditSnapshot(user)
+	if err := user.Approve(server.DB, role); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "user.approve", "user", fmt.Sprint(user.ID), before, models.AuditSnapshot(user))
+	go func() {
+		if err := utils.SendRegistrationApprovedEmail(user.Email); err != nil {
+			log.Printf("error notifying %s about the approval: %s", user.Email, err.Error())
+		}
+	}()
+	c.JSON(http.StatusOK, user)
+}
+
+/*
+	Decline a registration, the user gets deleted.
+	Example request:
+		POST /api/admin/registrations/4/reject
+*/
+func (server *Server) RejectRegistration(c *gin.Context) {
+	user, ok := server.findPendingUser(c)
+	if !ok {
+		return
+	}
+	if err := user.Reject(server.DB); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "user.reject", "user", fmt.Sprint(user.ID), models.AuditSnapshot(user), nil)
+	go func() {
+		if err := utils.SendRegistrationRejectedEmail(user.Email); err != nil {
+			log.Printf("error notifying %s about the rejection: %s", user.Email, err.Error())
+		}
+	}()
+	c.JSON(http.StatusOK, "Registration was rejected")
+}
+
+func (server *Server) findPendingUser(c *gin.Context) (*models.User, bool) {
+	uid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return nil, false
+	}
+	user, err := models.FindPendingUser(server.DB, uint32(uid))
+	if gorm.IsRecordNotFoundError(err) {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("pending registration %d not found", uid))
+		return nil, false
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return user, true
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/SheetAble/SheetAble/backend/api/middlewares"
+	"github.com/SheetAble/SheetAble/backend/api/models"
 	"github.com/gin-gonic/gin"
 
 	rice "github.com/GeertJohan/go.rice"
@@ -13,7 +14,7 @@ import (
 
 func (server *Server) SetupRouter() {
 	r := gin.New()
-	r.Use(gin.Recovery())
+	r.Use(gin.Recovery(), middlewares.ResolveClientIP(), middlewares.ProxyHeaderGuard())
 
 	// Health checks
 	r.GET("/health", func(c *gin.Context) {
@@ -26,48 +27,160 @@ func (server *Server) SetupRouter() {
 	api.GET("/version", server.Version)
 	// SecureApi is still rooted at /api/... but it has the auth middleware so it'server routes check token on each call
 	secureApi := api.Group("")
-	secureApi.Use(middlewares.AuthMiddleware())
+	readOnly := middlewares.ReadOnlyRoutes{}
+	secureApi.Use(middlewares.AuthMiddleware(server.DB, readOnly), middlewares.LoadUser(server.DB))
+
+	// POST routes which don't change anything, API tokens limited to sheets:read may use them
+	readingPOST := func(relativePath string, handlers ...gin.HandlerFunc) {
+		secureApi.POST(relativePath, handlers...)
+		readOnly[path.Join(secureApi.BasePath(), relativePath)] = true
+	}
+
+	// Images loaded by the browser itself authenticate with the media token cookie
+	mediaApi := api.Group("")
+	mediaApi.Use(middlewares.MediaAuthMiddleware(server.DB), middlewares.LoadUser(server.DB))
+
+	// Routes which need more than being logged in declare the permission of the role
+	canUpload := middlewares.RequirePermission(models.PermissionUploadSheets)
+	canManageLibrary := middlewares.RequirePermission(models.PermissionManageLibrary)
+	canManageUsers := middlewares.RequirePermission(models.PermissionManageUsers)
+	// Account changes need a real login, personal API tokens are rejected
+	needsSession := middlewares.RequireSession()
 
 	// Login routes
 	api.POST("/login", server.Login)
+	api.POST("/login/mfa", server.LoginMfa)
+	api.POST("/token/refresh", server.RefreshToken)
+	secureApi.POST("/logout", needsSession, server.Logout)
+
+	// Single sign-on through OpenID Connect
+	api.GET("/auth/oidc", server.GetOidcStatus)
+	api.GET("/auth/oidc/login", server.OidcLogin)
+	api.GET("/auth/oidc/callback", server.OidcCallback)
+	api.GET("/auth/proxy", server.GetProxyAuthStatus)
 
 	// Users routes
-	api.POST("/users", server.CreateUser)
-	secureApi.GET("/users", server.GetUsers)
+	secureApi.POST("/users", canManageUsers, server.CreateUser)
+	secureApi.GET("/users", canManageUsers, server.GetUsers)
 	secureApi.GET("/users/:id", server.GetUser)
-	secureApi.PUT("/users/:id", server.UpdateUser)
-	secureApi.DELETE("/users/:id", server.DeleteUser)
+	secureApi.PUT("/users/:id", needsSession, server.UpdateUser)
+	secureApi.DELETE("/users/:id", needsSession, server.DeleteUser)
+	secureApi.PUT("/users/:id/role", canManageUsers, server.UpdateUserRole)
+	secureApi.DELETE("/users/:id/mfa", canManageUsers, needsSession, server.ResetUserMfa)
 	api.POST("/reset_password", server.ResetPassword)
 	api.POST("/request_password_reset", server.RequestPasswordReset)
 
+	// Invitations by email
+	secureApi.GET("/admin/invitations", canManageUsers, server.GetInvitations)
+	secureApi.POST("/admin/invitations", canManageUsers, server.CreateInvitation)
+	secureApi.POST("/admin/invitations/:id/resend", canManageUsers, server.ResendInvitation)
+	secureApi.DELETE("/admin/invitations/:id", canManageUsers, server.RevokeInvitation)
+	api.POST("/invitations/:token/accept", server.AcceptInvitation)
+
+	// Self-service registration, see REGISTRATION
+	api.GET("/registration", server.GetRegistrationStatus)
+	api.POST("/register", server.Register)
+	api.POST("/register/confirm/:token", server.ConfirmEmail)
+	secureApi.GET("/admin/registrations", canManageUsers, server.GetPendingRegistrations)
+	secureApi.POST("/admin/registrations/:id/approve", canManageUsers, server.ApproveRegistration)
+	secureApi.POST("/admin/registrations/:id/reject", canManageUsers, server.RejectRegistration)
+
+	// Two-factor authentication of the logged in user
+	secureApi.GET("/me/mfa", needsSession, server.GetMfaStatus)
+	secureApi.POST("/me/mfa/totp", needsSession, server.StartTotpEnrollment)
+	secureApi.POST("/me/mfa/totp/verify", needsSession, server.EnableTotp)
+	secureApi.DELETE("/me/mfa/totp", needsSession, server.DisableTotp)
+	secureApi.POST("/me/mfa/recovery-codes", needsSession, server.RegenerateRecoveryCodes)
+
+	// Personal API tokens
+	secureApi.GET("/me/tokens", needsSession, server.GetApiTokens)
+	secureApi.POST("/me/tokens", needsSession, server.CreateApiToken)
+	secureApi.DELETE("/me/tokens/:id", needsSession, server.RevokeApiToken)
+
+	// Admin routes
+	secureApi.GET("/admin/lockouts", canManageUsers, server.GetLockoutEvents)
+	secureApi.GET("/admin/lockouts/active", canManageUsers, server.GetActiveLockouts)
+	secureApi.DELETE("/admin/lockouts/active", canManageUsers, server.Unlock)
+	secureApi.GET("/admin/audit", canManageUsers, server.GetAuditEvents)
+
+	// Groups to share sheets and composers with
+	secureApi.GET("/groups", server.GetGroups)
+	secureApi.POST("/admin/groups", canManageUsers, server.CreateGroup)
+	secureApi.PUT("/admin/groups/:id", canManageUsers, server.UpdateGroup)
+	secureApi.DELETE("/admin/groups/:id", canManageUsers, server.DeleteGroup)
+	secureApi.POST("/admin/groups/:id/members", canManageUsers, server.AddGroupMember)
+	secureApi.DELETE("/admin/groups/:id/members/:userId", canManageUsers, server.RemoveGroupMember)
+
 	// Sheet routes
-	secureApi.POST("/upload", server.UploadFile)
+	secureApi.POST("/upload", canUpload, server.UploadFile)
 	secureApi.GET("/sheets", server.GetSheetsPage)
-	secureApi.POST("/sheets", server.GetSheetsPage)
-	api.GET("/sheet/thumbnail/:name", server.GetThumbnail)
+	readingPOST("/sheets", server.GetSheetsPage)
+	readingPOST("/sheets/query", server.QuerySheets)
+	secureApi.POST("/sheets/bulk", canManageLibrary, server.BulkSheets)
+	mediaApi.GET("/sheet/thumbnail/:name", server.GetThumbnail)
 	secureApi.GET("/sheet/pdf/:composer/:sheetName", server.GetPDF)
 	secureApi.GET("/sheet/:sheetName", server.GetSheet)
-	secureApi.PUT("/sheet/:sheetName", server.UpdateSheet)
-	secureApi.DELETE("/sheet/:sheetName", server.DeleteSheet)
+	secureApi.PUT("/sheet/:sheetName", canUpload, server.UpdateSheet)
+	secureApi.DELETE("/sheet/:sheetName", canUpload, server.DeleteSheet)
+	secureApi.PUT("/sheet/:sheetName/owner", canManageUsers, server.TransferSheetOwnership)
+	secureApi.PUT("/sheet/:sheetName/visibility", canUpload, server.SetSheetVisibility)
 	secureApi.GET("/search/:searchValue", server.SearchSheets)
 	secureApi.GET("/search/composers/:searchValue", server.SearchComposers)
-	secureApi.PUT("/sheet/:sheetName/info", server.UpdateSheetInformationText)
-	secureApi.POST("/sheet/:sheetName/info", server.UpdateSheetInformationText)
+	secureApi.GET("/suggest", server.Suggest)
+	secureApi.PUT("/sheet/:sheetName/info", canUpload, server.UpdateSheetInformationText)
+	secureApi.POST("/sheet/:sheetName/info", canUpload, server.UpdateSheetInformationText)
+
+	// Favorites and recently viewed sheets of the logged in user
+	secureApi.POST("/sheet/:sheetName/star", server.StarSheet)
+	secureApi.DELETE("/sheet/:sheetName/star", server.UnstarSheet)
+	secureApi.GET("/me/favorites", server.GetFavorites)
+	secureApi.GET("/me/recent", server.GetRecentSheets)
 
 	// Sheet tag routes
-	secureApi.DELETE("/tag/sheet/:sheetName", server.DeleteTag)
-	secureApi.POST("/tag/delete/sheet/:sheetName", server.DeleteTag)
-	secureApi.POST("/tag/sheet/:sheetName", server.AppendTag)
-	secureApi.GET("/tag/sheet/:sheetName", server.AppendTag)
+	secureApi.DELETE("/tag/sheet/:sheetName", canUpload, server.DeleteTag)
+	secureApi.POST("/tag/delete/sheet/:sheetName", canUpload, server.DeleteTag)
+	secureApi.POST("/tag/sheet/:sheetName", canUpload, server.AppendTag)
+	secureApi.GET("/tag/sheet/:sheetName", canUpload, server.AppendTag)
 	secureApi.GET("/tag", server.FindSheetsByTag)
-	secureApi.POST("/tag", server.FindSheetsByTag)
+	readingPOST("/tag", server.FindSheetsByTag)
+
+	// Library-wide tag routes
+	secureApi.GET("/tags", server.GetTags)
+	secureApi.GET("/tags/tree", server.GetTagTree)
+	secureApi.POST("/tags/merge", canManageLibrary, server.MergeTags)
+	secureApi.POST("/tags/move", canManageLibrary, server.MoveTag)
+	secureApi.PUT("/tags/*tagName", canManageLibrary, server.UpdateTag)
+	secureApi.DELETE("/tags/*tagName", canManageLibrary, server.DeleteTagEverywhere)
+
+	// Smart collection routes
+	secureApi.GET("/smart-collections", server.GetSmartCollections)
+	secureApi.POST("/smart-collections", server.CreateSmartCollection)
+	secureApi.GET("/smart-collections/:id", server.GetSmartCollection)
+	secureApi.PUT("/smart-collections/:id", server.UpdateSmartCollection)
+	secureApi.DELETE("/smart-collections/:id", server.DeleteSmartCollection)
+	secureApi.GET("/smart-collections/:id/sheets", server.GetSmartCollectionSheets)
+	readingPOST("/smart-collections/:id/sheets", server.GetSmartCollectionSheets)
+
+	// Setlist routes
+	secureApi.GET("/setlists", server.GetSetlists)
+	secureApi.POST("/setlists", server.CreateSetlist)
+	secureApi.GET("/setlists/:id", server.GetSetlist)
+	secureApi.PUT("/setlists/:id", server.UpdateSetlist)
+	secureApi.DELETE("/setlists/:id", server.DeleteSetlist)
+	secureApi.GET("/setlists/:id/pdf", server.GetSetlistPDF)
+	secureApi.PUT("/setlists/:id/order", server.ReorderSetlist)
+	secureApi.POST("/setlists/:id/entries", server.AddSetlistEntry)
+	secureApi.PUT("/setlists/:id/entries/:entryId", server.UpdateSetlistEntry)
+	secureApi.DELETE("/setlists/:id/entries/:entryId", server.DeleteSetlistEntry)
+	secureApi.PUT("/setlists/:id/entries/:entryId/position", server.MoveSetlistEntry)
 
 	// Composer routes
 	secureApi.GET("/composers", server.GetComposersPage)
-	secureApi.POST("/composers", server.GetComposersPage)
-	secureApi.PUT("/composer/:composerName", server.UpdateComposer)
-	secureApi.DELETE("/composer/:composerName", server.DeleteComposer)
-	api.GET("/composer/portrait/:composerName", server.ServePortraits)
+	readingPOST("/composers", server.GetComposersPage)
+	secureApi.PUT("/composer/:composerName", canManageLibrary, server.UpdateComposer)
+	secureApi.DELETE("/composer/:composerName", canManageLibrary, server.DeleteComposer)
+	secureApi.PUT("/composer/:composerName/visibility", canManageLibrary, server.SetComposerVisibility)
+	mediaApi.GET("/composer/portrait/:composerName", server.ServePortraits)
 
 	// Serve React
 	appBox := rice.MustFindBox("../../../frontend/build")
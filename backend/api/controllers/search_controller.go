@@ -2,23 +2,96 @@ package controllers
 
 import (
 	"net/http"
+	"net/url"
 
+	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
 	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
 	"github.com/gin-gonic/gin"
 )
 
+/*
+	Search sheets by name.
+	Example request:
+		GET /api/search/Beethovn
+
+	When nothing matches, the X-Did-You-Mean header holds the closest sheet or composer name.
+	Names aren't limited to ASCII, so it is percent-encoded (decodeURIComponent in the browser).
+*/
 func (server *Server) SearchSheets(c *gin.Context) {
 	searchValue := c.Param("searchValue")
 
-	sheets := models.SearchSheet(server.DB, searchValue)
+	sheets, err := models.SearchSheet(server.DB, searchValue, middlewares.CurrentUser(c))
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Give the client a hint when nothing matched, e.g. "Beethovn" -> "Ludwig van Beethoven"
+	if len(sheets) == 0 {
+		suggestion, err := models.SuggestSearchTerm(server.DB, searchValue, middlewares.CurrentUser(c))
+		if err != nil {
+			utils.DoError(c, http.StatusInternalServerError, err)
+			return
+		}
+		if suggestion != "" {
+			c.Header("X-Did-You-Mean", url.PathEscape(suggestion))
+		}
+	}
 
 	c.JSON(http.StatusOK, sheets)
 }
 
+// Search composers by name, with the same X-Did-You-Mean header as SearchSheets
 func (server *Server) SearchComposers(c *gin.Context) {
 	searchValue := c.Param("searchValue")
 
-	composers := models.SearchComposer(server.DB, searchValue)
+	composers, err := models.SearchComposer(server.DB, searchValue, middlewares.CurrentUser(c))
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(composers) == 0 {
+		suggestion, err := models.SuggestSearchTerm(server.DB, searchValue, middlewares.CurrentUser(c))
+		if err != nil {
+			utils.DoError(c, http.StatusInternalServerError, err)
+			return
+		}
+		if suggestion != "" {
+			c.Header("X-Did-You-Mean", url.PathEscape(suggestion))
+		}
+	}
 
 	c.JSON(http.StatusOK, composers)
 }
+
+/*
+	Typeahead suggestions for search-as-you-type.
+	Example request:
+		GET /api/suggest?q=chop&types=sheet,composer,tag&limit=5
+
+	Return:
+		- sheets: [{safe_sheet_name, sheet_name, safe_composer}]
+		- composers: [{safe_name, name}]
+		- tags: ["..."]
+*/
+func (server *Server) Suggest(c *gin.Context) {
+	var form forms.SuggestRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := form.ValidateForm(); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	suggestions, err := models.Suggest(server.DB, form.Query, form.TypeList(), form.Limit, middlewares.CurrentUser(c))
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, suggestions)
+}
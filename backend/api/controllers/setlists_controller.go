@@ -0,0 +1,465 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
+	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/kennygrant/sanitize"
+)
+
+/*
+	Return all setlists of the user, newest performance first.
+	Example request:
+		GET /api/setlists
+*/
+func (server *Server) GetSetlists(c *gin.Context) {
+	uid := middlewares.CurrentUser(c).ID
+
+	setlists, err := models.FindSetlistsByOwner(server.DB, uid)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, setlists)
+}
+
+/*
+	Create a new (empty) setlist.
+	Example request:
+		POST /api/setlists
+		Body:
+			- name: Spring concert
+			- date: 2022-04-23
+			- venue: Town hall
+			- notes: Bring the music stands
+*/
+func (server *Server) CreateSetlist(c *gin.Context) {
+	uid := middlewares.CurrentUser(c).ID
+
+	var form forms.SetlistRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	setlist := models.Setlist{OwnerID: uid}
+	if err := applySetlistForm(&setlist, form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	setlist.Prepare()
+	if err := setlist.Validate(); err != nil {
+		utils.DoError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	created, err := setlist.SaveSetlist(server.DB)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	created.Entries = []*models.SetlistEntry{}
+	server.audit(c, "setlist.create", "setlist", fmt.Sprint(created.ID), nil, setlistSnapshot(created))
+	c.JSON(http.StatusCreated, created)
+}
+
+// Return a setlist with its entries in order
+func (server *Server) GetSetlist(c *gin.Context) {
+	setlist := server.getSetlist(c)
+	if setlist == nil {
+		return
+	}
+	c.JSON(http.StatusOK, setlist)
+}
+
+// Update name, date, venue and notes of a setlist
+func (server *Server) UpdateSetlist(c *gin.Context) {
+	setlist := server.getSetlist(c)
+	if setlist == nil {
+		return
+	}
+
+	var form forms.SetlistRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	before := setlistSnapshot(setlist)
+	if err := applySetlistForm(setlist, form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	setlist.Prepare()
+	if err := setlist.Validate(); err != nil {
+		utils.DoError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	updated, err := setlist.UpdateSetlist(server.DB)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "setlist.update", "setlist", fmt.Sprint(updated.ID), before, setlistSnapshot(updated))
+	c.JSON(http.StatusOK, updated)
+}
+
+func (server *Server) DeleteSetlist(c *gin.Context) {
+	setlist := server.getSetlist(c)
+	if setlist == nil {
+		return
+	}
+
+	before := setlistSnapshot(setlist)
+	if err := setlist.DeleteSetlist(server.DB); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "setlist.delete", "setlist", fmt.Sprint(setlist.ID), before, nil)
+	c.JSON(http.StatusOK, "Setlist was successfully deleted")
+}
+
+/*
+	Add a sheet to a setlist, optionally only a range of its pages.
+	Example request:
+		POST /api/setlists/1/entries
+		Body:
+			- sheet: clair-de-lune
+			- page_from: 2
+			- page_to: 5
+			- note: Skip the repeat
+			- position: 1 (optional, appends the entry if empty)
+*/
+func (server *Server) AddSetlistEntry(c *gin.Context) {
+	setlist := server.getSetlist(c)
+	if setlist == nil {
+		return
+	}
+
+	var form forms.SetlistEntryRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	entry := models.SetlistEntry{
+		SafeSheetName: form.Sheet,
+		PageFrom:      form.PageFrom,
+		PageTo:        form.PageTo,
+		Note:          form.Note,
+	}
+	if err := entry.Validate(); err != nil {
+		utils.DoError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if findVisibleSheet(server.DB, c, entry.SafeSheetName) == nil {
+		return
+	}
+
+	before := setlistSnapshot(setlist)
+	if _, err := setlist.AddEntry(server.DB, &entry, form.Position); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	server.respondWithSetlist(c, http.StatusCreated, setlist, "setlist.entry_add", before)
+}
+
+/*
+	Change the page range or note of an entry.
+	Example request:
+		PUT /api/setlists/1/entries/3
+		Body:
+			- page_from: 1
+			- page_to: 0 (until the last page)
+			- note: Play it slower
+*/
+func (server *Server) UpdateSetlistEntry(c *gin.Context) {
+	setlist := server.getSetlist(c)
+	if setlist == nil {
+		return
+	}
+	entryID := getSetlistEntryID(c)
+	if entryID == 0 {
+		return
+	}
+
+	var form forms.UpdateSetlistEntryRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	before := setlistSnapshot(setlist)
+	entry, err := setlist.UpdateEntry(server.DB, entryID, form.PageFrom, form.PageTo, form.Note)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	server.audit(c, "setlist.entry_update", "setlist", fmt.Sprint(setlist.ID), before, setlistSnapshot(setlist))
+	c.JSON(http.StatusOK, entry)
+}
+
+func (server *Server) DeleteSetlistEntry(c *gin.Context) {
+	setlist := server.getSetlist(c)
+	if setlist == nil {
+		return
+	}
+	entryID := getSetlistEntryID(c)
+	if entryID == 0 {
+		return
+	}
+
+	before := setlistSnapshot(setlist)
+	if err := setlist.DeleteEntry(server.DB, entryID); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	server.respondWithSetlist(c, http.StatusOK, setlist, "setlist.entry_remove", before)
+}
+
+/*
+	Move a single entry to a new position (1 based).
+	Example request:
+		PUT /api/setlists/1/entries/3/position
+		Body:
+			- position: 1
+*/
+func (server *Server) MoveSetlistEntry(c *gin.Context) {
+	setlist := server.getSetlist(c)
+	if setlist == nil {
+		return
+	}
+	entryID := getSetlistEntryID(c)
+	if entryID == 0 {
+		return
+	}
+
+	var form forms.MoveSetlistEntryRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	before := setlistSnapshot(setlist)
+	if err := setlist.MoveEntry(server.DB, entryID, form.Position); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	server.respondWithSetlist(c, http.StatusOK, setlist, "setlist.entry_move", before)
+}
+
+/*
+	Reorder all entries of a setlist at once.
+	Example request:
+		PUT /api/setlists/1/order
+		Body (JSON):
+		{
+			"entries": [3, 1, 2]
+		}
+*/
+func (server *Server) ReorderSetlist(c *gin.Context) {
+	setlist := server.getSetlist(c)
+	if setlist == nil {
+		return
+	}
+
+	var form forms.ReorderSetlistRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := form.ValidateForm(); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	before := setlistSnapshot(setlist)
+	if err := setlist.Reorder(server.DB, form.Entries); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	server.respondWithSetlist(c, http.StatusOK, setlist, "setlist.reorder", before)
+}
+
+/*
+	Merge the PDFs of all entries into a single document for printing.
+	Page ranges of the entries are respected and every piece gets a bookmark.
+	The pieces are written one after the other, long setlists don't need more memory.
+	Example request:
+		GET /api/setlists/1/pdf?separators=true
+*/
+func (server *Server) GetSetlistPDF(c *gin.Context) {
+	setlist := server.getSetlist(c)
+	if setlist == nil {
+		return
+	}
+
+	var form forms.SetlistPdfRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var parts []utils.PdfPart
+	for _, entry := range setlist.Entries {
+		if entry.Sheet == nil {
+			continue
+		}
+		part := utils.PdfPart{
+			Path:     path.Join(Config().ConfigPath, "sheets/uploaded-sheets", entry.Sheet.SafeComposer, entry.Sheet.SafeSheetName+".pdf"),
+			PageFrom: entry.PageFrom,
+			PageTo:   entry.PageTo,
+			Bookmark: entry.Sheet.SheetName,
+		}
+		if form.Separators {
+			part.Separator = []string{entry.Sheet.SheetName, entry.Sheet.Composer}
+			if entry.Note != "" {
+				part.Separator = append(part.Separator, entry.Note)
+			}
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		utils.DoError(c, http.StatusBadRequest, errors.New("the setlist has no entries"))
+		return
+	}
+
+	// Merge into a temporary file first, so errors can still be reported and the response is streamed from disk
+	file, err := ioutil.TempFile("", "setlist-*.pdf")
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := utils.MergePdfs(parts, file); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, fmt.Errorf("unable to merge the setlist: %v", err))
+		return
+	}
+	info, err := file.Stat()
+	if err == nil {
+		_, err = file.Seek(0, 0)
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.DataFromReader(http.StatusOK, info.Size(), "application/pdf", file, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=\"%s.pdf\"", sanitize.BaseName(setlist.Name)),
+	})
+}
+
+func applySetlistForm(setlist *models.Setlist, form forms.SetlistRequest) error {
+	setlist.Name = form.Name
+	setlist.Venue = form.Venue
+	setlist.Notes = form.Notes
+	setlist.Date = time.Time{}
+	if form.Date != "" {
+		date, err := time.Parse("2006-01-02", form.Date)
+		if err != nil {
+			return fmt.Errorf("invalid date %s, expected YYYY-MM-DD", form.Date)
+		}
+		setlist.Date = date
+	}
+	return nil
+}
+
+func (server *Server) respondWithSetlist(c *gin.Context, status int, setlist *models.Setlist, action string, before map[string]interface{}) {
+
+	// Reload the entries so the response and the audit event carry the final positions
+	if err := setlist.LoadEntries(server.DB); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := server.hideInvisibleEntries(c, setlist); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, action, "setlist", fmt.Sprint(setlist.ID), before, setlistSnapshot(setlist))
+	c.JSON(status, setlist)
+}
+
+func (server *Server) getSetlist(c *gin.Context) *models.Setlist {
+
+	// Find a setlist by its id, only its owner or an admin may access it
+	uid := middlewares.CurrentUser(c).ID
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, errors.New("invalid setlist id"))
+		return nil
+	}
+
+	var setlistModel models.Setlist
+	setlist, err := setlistModel.FindSetlistByID(server.DB, uint32(id))
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			utils.DoError(c, http.StatusNotFound, fmt.Errorf("setlist %d not found", id))
+			return nil
+		}
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return nil
+	}
+
+	if setlist.OwnerID != uid && !middlewares.CurrentUser(c).IsAdmin() {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("setlist %d not found", id))
+		return nil
+	}
+	if err := server.hideInvisibleEntries(c, setlist); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return nil
+	}
+	return setlist
+}
+
+func (server *Server) hideInvisibleEntries(c *gin.Context, setlist *models.Setlist) error {
+
+	// Entries whose sheet got hidden from the user stay, but without the sheet, like deleted sheets
+	visible, err := models.VisibleSheetNames(server.DB, middlewares.CurrentUser(c))
+	if err != nil || visible == nil {
+		return err
+	}
+	for _, entry := range setlist.Entries {
+		if entry.Sheet != nil && !visible[entry.SafeSheetName] {
+			entry.Sheet = nil
+		}
+	}
+	return nil
+}
+
+func setlistSnapshot(setlist *models.Setlist) map[string]interface{} {
+
+	// The entries without their sheets, those have a history of their own
+	snapshot := *setlist
+	snapshot.Entries = make([]*models.SetlistEntry, len(setlist.Entries))
+	for i, entry := range setlist.Entries {
+		plain := *entry
+		plain.Sheet = nil
+		snapshot.Entries[i] = &plain
+	}
+	return models.AuditSnapshot(&snapshot)
+}
+
+func getSetlistEntryID(c *gin.Context) uint32 {
+	id, err := strconv.ParseUint(c.Param("entryId"), 10, 32)
+	if err != nil || id == 0 {
+		utils.DoError(c, http.StatusBadRequest, errors.New("invalid entry id"))
+		return 0
+	}
+	return uint32(id)
+}
@@ -1,14 +1,21 @@
 package controllers
 
 import (
+	"archive/zip"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"os"
 	"path"
+	"strings"
+	"time"
 
-	"github.com/SheetAble/SheetAble/backend/api/auth"
 	. "github.com/SheetAble/SheetAble/backend/api/config"
 	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
 	"github.com/SheetAble/SheetAble/backend/api/models"
 	"github.com/SheetAble/SheetAble/backend/api/utils"
 	"github.com/gin-gonic/gin"
@@ -34,6 +41,10 @@ func (server *Server) GetSheetsPage(c *gin.Context) {
 		utils.DoError(c, http.StatusBadRequest, err)
 		return
 	}
+	if err := form.ValidateForm(); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
 
 	pagination := models.Pagination{
 		Sort:  form.SortBy,
@@ -42,7 +53,7 @@ func (server *Server) GetSheetsPage(c *gin.Context) {
 	}
 
 	var sheet models.Sheet
-	pageNew, err := sheet.List(server.DB, pagination, form.Composer)
+	pageNew, err := sheet.List(server.DB, pagination, form.Composer, middlewares.CurrentUser(c))
 	if err != nil {
 		utils.DoError(c, http.StatusInternalServerError, err)
 		return
@@ -50,6 +61,191 @@ func (server *Server) GetSheetsPage(c *gin.Context) {
 	c.JSON(http.StatusOK, pageNew)
 }
 
+/*
+	This endpoint combines several filters and returns one page of matching sheets.
+	Example request:
+		POST /api/sheets/query
+		Body (JSON):
+		{
+			"text": "etude",
+			"composers": ["frederic-chopin"],
+			"epochs": ["Romantic"],
+			"tags": ["christmas", "choir"],
+			"tag_mode": "any",
+			"released_after": "1800-01-01",
+			"released_before": "1900-12-31",
+			"uploader_id": 1,
+			"created_after": "2021-01-01",
+			"created_before": "2021-12-31",
+			"has_attachments": true,
+			"page": 1,
+			"limit": 10,
+			"sort_by": "updated_at desc"
+		}
+
+	Every word of text has to appear in the sheet name, accents and casing are ignored.
+	The information text is the only attachment of a sheet next to its PDF so far,
+	has_attachments finds the sheets with (true) or without (false) one.
+
+	Return:
+		The usual pagination fields plus facet counts for
+		composers, epochs, tags and uploaders
+*/
+func (server *Server) QuerySheets(c *gin.Context) {
+	var form forms.SheetQueryRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := form.ValidateForm(); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	query := models.SheetQuery{
+		Text:           form.Text,
+		Composers:      form.Composers,
+		Epochs:         form.Epochs,
+		Tags:           form.Tags,
+		MatchAnyTag:    form.TagMode == "any",
+		UploaderID:     form.UploaderID,
+		HasAttachments: form.HasAttachments,
+		Viewer:         middlewares.CurrentUser(c),
+	}
+
+	dates := []struct {
+		value  string
+		target *time.Time
+	}{
+		{form.ReleasedAfter, &query.ReleasedAfter},
+		{form.ReleasedBefore, &query.ReleasedBefore},
+		{form.CreatedAfter, &query.CreatedAfter},
+		{form.CreatedBefore, &query.CreatedBefore},
+	}
+	for _, date := range dates {
+		if date.value == "" {
+			continue
+		}
+		parsed, err := time.Parse("2006-01-02", date.value)
+		if err != nil {
+			utils.DoError(c, http.StatusBadRequest, fmt.Errorf("invalid date %s, expected format YYYY-MM-DD", date.value))
+			return
+		}
+		*date.target = parsed
+	}
+
+	pagination := models.Pagination{
+		Sort:  form.SortBy,
+		Limit: form.Limit,
+		Page:  form.Page,
+	}
+
+	result, err := query.Run(server.DB, pagination)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+/*
+	Apply one action to many sheets at once. Everything runs in one transaction,
+	so either all sheets are changed or none.
+	Example request:
+		POST /api/sheets/bulk
+		Body (JSON):
+		{
+			"sheets": ["fuer-elise", "etude-n-1"],
+			"action": "add_tags",
+			"tags": ["Christmas"]
+		}
+	Actions:
+		- add_tags / remove_tags: (tags)
+		- set_composer: (composer, safe name of an existing composer)
+		- set_category: (category)
+		- delete
+		- export: responds with a zip of the PDFs instead of JSON
+
+	Return:
+		- success: true if every sheet was processed
+		- results: [{sheet, success, error}]
+*/
+func (server *Server) BulkSheets(c *gin.Context) {
+	var form forms.BulkSheetsRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := form.ValidateForm(); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	action := models.BulkAction{
+		Action:   form.Action,
+		Sheets:   form.Sheets,
+		Tags:     form.Tags,
+		Composer: form.Composer,
+		Category: form.Category,
+	}
+	if form.Action == models.BulkExport {
+		results, sheets, success := action.Run(server.DB)
+		if !success {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"success": false, "results": results})
+			return
+		}
+		exportSheets(c, sheets, results)
+		return
+	}
+
+	results, _, success := action.Run(server.DB)
+	if !success {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"success": false, "results": results})
+		return
+	}
+
+	// One event per sheet, so the history of a sheet is complete
+	for _, result := range results {
+		server.audit(c, "sheet.bulk_"+form.Action, "sheet", result.Sheet, result.Before, result.After)
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "results": results})
+}
+
+func exportSheets(c *gin.Context, sheets []*models.Sheet, results []models.BulkResult) {
+
+	// Stream a zip with one folder per composer and a manifest of the exported sheets
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"sheetable-export-%s.zip\"", time.Now().Format("2006-01-02")))
+	c.Status(http.StatusOK)
+
+	archive := zip.NewWriter(c.Writer)
+	defer archive.Close()
+
+	for _, sheet := range sheets {
+		filePath := path.Join(Config().ConfigPath, "sheets/uploaded-sheets", sheet.SafeComposer, sheet.SafeSheetName+".pdf")
+		file, err := os.Open(filePath)
+		if err != nil {
+			log.Printf("export: unable to open %s: %s\n", filePath, err.Error())
+			continue
+		}
+		entry, err := archive.Create(path.Join(sheet.SafeComposer, sheet.SafeSheetName+".pdf"))
+		if err == nil {
+			_, err = io.Copy(entry, file)
+		}
+		file.Close()
+		if err != nil {
+			log.Printf("export: unable to add %s: %s\n", filePath, err.Error())
+			return
+		}
+	}
+
+	manifest, err := archive.Create("sheets.json")
+	if err != nil {
+		return
+	}
+	json.NewEncoder(manifest).Encode(gin.H{"sheets": sheets, "results": results})
+}
+
 /*	
 	Get PDF file and information about an individual sheet.
 	Example request:
@@ -57,16 +253,8 @@ func (server *Server) GetSheetsPage(c *gin.Context) {
 	Has to be safeName
 */
 func (server *Server) GetSheet(c *gin.Context) {
-	sheetName := c.Param("sheetName")
-	if sheetName == "" {
-		utils.DoError(c, http.StatusBadRequest, errors.New("missing URL parameter 'sheetName'"))
-		return
-	}
-
-	var sheetModel models.Sheet
-	sheet, err := sheetModel.FindSheetBySafeName(server.DB, sheetName)
-	if err != nil {
-		utils.DoError(c, http.StatusInternalServerError, fmt.Errorf("unable to get sheet %s: %s", sheetName, err.Error()))
+	sheet := getSheet(server.DB, c)
+	if sheet == nil {
 		return
 	}
 	c.JSON(http.StatusOK, sheet)
@@ -79,19 +267,35 @@ func (server *Server) GetSheet(c *gin.Context) {
 	sheetname and composer name have to be the safeName of them
 */
 func (server *Server) GetPDF(c *gin.Context) {
-	sheetName := c.Param("sheetName") + ".pdf"
-	composer := c.Param("composer")
-	filePath := path.Join(Config().ConfigPath, "sheets/uploaded-sheets", composer, sheetName)
+	sheet := getSheet(server.DB, c)
+	if sheet == nil {
+		return
+	}
+	if sheet.SafeComposer != c.Param("composer") {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("unable to find sheet: %s", sheet.SafeSheetName))
+		return
+	}
+	filePath := path.Join(Config().ConfigPath, "sheets/uploaded-sheets", sheet.SafeComposer, sheet.SafeSheetName+".pdf")
+
+	// Opening a sheet loads its information and its PDF, only the PDF counts as a view.
+	// Viewers fetching the rest of the file in ranges don't count again
+	if rangeHeader := c.GetHeader("Range"); rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-") {
+		server.recordSheetView(c, sheet.SafeSheetName)
+	}
 	c.File(filePath)
 }
 
 /*
 	Serve the thumbnail file
 	name = safename of sheet
+	Images can't send the Authorization header, the browser sends the media token cookie instead
 */
 func (server *Server) GetThumbnail(c *gin.Context) {
-	name := c.Param("name") + ".png"
-	filePath := path.Join(Config().ConfigPath, "sheets/thumbnails", name)
+	sheet := findVisibleSheet(server.DB, c, c.Param("name"))
+	if sheet == nil {
+		return
+	}
+	filePath := path.Join(Config().ConfigPath, "sheets/thumbnails", sheet.SafeSheetName+".png")
 	c.File(filePath)
 }
 
@@ -100,28 +304,24 @@ func (server *Server) GetThumbnail(c *gin.Context) {
 func (server *Server) DeleteSheet(c *gin.Context) {
 	sheetName := c.Param("sheetName")
 
-	// Is this user authenticated?
-	token := utils.ExtractToken(c)
-	_, err := auth.ExtractTokenID(token, Config().ApiSecret)
-	if err != nil {
-		c.String(http.StatusUnauthorized, "Unauthorized")
+	// Check if the sheet exist, hidden ones are reported as missing
+	sheet := getSheet(server.DB, c)
+	if sheet == nil || !checkSheetOwnership(c, sheet) {
 		return
 	}
 
-	// Check if the sheet exist
-	sheet := models.Sheet{}
-	err = server.DB.Model(models.Sheet{}).Where("safe_sheet_name = ?", sheetName).Take(&sheet).Error
+	before := models.AuditSnapshot(sheet)
+	_, err := sheet.DeleteSheet(server.DB, sheetName)
 	if err != nil {
-		c.String(http.StatusNotFound, "sheet not found")
+		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
-
-	_, err = sheet.DeleteSheet(server.DB, sheetName)
-	if err != nil {
-		c.String(http.StatusBadRequest, err.Error())
+	if err := models.DeleteSheetReferences(server.DB, sheetName); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
 		return
 	}
 
+	server.audit(c, "sheet.delete", "sheet", sheetName, before, nil)
 	c.JSON(http.StatusOK, "Sheet was successfully deleted")
 }
 
@@ -133,7 +333,7 @@ func (server *Server) DeleteTag(c *gin.Context) {
 	*/
 
 	sheet := getSheet(server.DB, c)
-	if sheet == nil {
+	if sheet == nil || !checkSheetOwnership(c, sheet) {
 		return
 	}
 
@@ -143,11 +343,13 @@ func (server *Server) DeleteTag(c *gin.Context) {
 		return
 	}
 
+	before := models.AuditSnapshot(gin.H{"tags": sheet.Tags})
 	tagNotFound := sheet.DelteTag(server.DB, updateTagForm.TagValue)
 	if !tagNotFound {
 		utils.DoError(c, http.StatusNotFound, fmt.Errorf("unable to find tag: %s", updateTagForm.TagValue))
 		return
 	}
+	server.audit(c, "sheet.tag_remove", "sheet", sheet.SafeSheetName, before, models.AuditSnapshot(gin.H{"tags": sheet.Tags}))
 
 	c.JSON(http.StatusOK, "Tag: ["+updateTagForm.TagValue+"] was successfully deleted")
 }
@@ -162,7 +364,7 @@ func (server *Server) AppendTag(c *gin.Context) {
 	*/
 
 	sheet := getSheet(server.DB, c)
-	if sheet == nil {
+	if sheet == nil || !checkSheetOwnership(c, sheet) {
 		return
 	}
 
@@ -176,13 +378,25 @@ func (server *Server) AppendTag(c *gin.Context) {
 		return
 	}
 
-	sheet.AppendTag(server.DB, tagForm.TagValue)
+	before := models.AuditSnapshot(gin.H{"tags": sheet.Tags})
+	if err := sheet.AppendTag(server.DB, tagForm.TagValue); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, fmt.Errorf("unable to append tag: %v", err))
+		return
+	}
+	server.audit(c, "sheet.tag_add", "sheet", sheet.SafeSheetName, before, models.AuditSnapshot(gin.H{"tags": sheet.Tags}))
 
 	c.JSON(http.StatusOK, "Tag: ["+tagForm.TagValue+"] was successfully appended")
 }
 
 func (server *Server) FindSheetsByTag(c *gin.Context) {
-
+	/*
+		This endpoint will return all sheets with a given Tag
+		Example Request
+		POST /api/tag
+			Body (FormValue):
+			- tagValue: Instrument/Strings
+			- includeDescendants: true (also return sheets tagged with e.g. Instrument/Strings/Violin)
+	*/
 	var tagForm forms.TagRequest
 	if err := c.ShouldBind(&tagForm); err != nil {
 		utils.DoError(c, http.StatusBadRequest, fmt.Errorf("bad upload request: %v", err))
@@ -193,7 +407,7 @@ func (server *Server) FindSheetsByTag(c *gin.Context) {
 		return
 	}
 
-	sheets := models.FindSheetByTag(server.DB, tagForm.TagValue)
+	sheets := models.FindSheetByTag(server.DB, tagForm.TagValue, tagForm.IncludeDescendants, middlewares.CurrentUser(c))
 
 	c.JSON(http.StatusOK, sheets)
 
@@ -209,7 +423,7 @@ func (server *Server) UpdateSheetInformationText(c *gin.Context) {
 	*/
 
 	sheet := getSheet(server.DB, c)
-	if sheet == nil {
+	if sheet == nil || !checkSheetOwnership(c, sheet) {
 		return
 	}
 
@@ -223,7 +437,9 @@ func (server *Server) UpdateSheetInformationText(c *gin.Context) {
 		return
 	}
 
+	before := models.AuditSnapshot(sheet)
 	newSheet := sheet.UpdateSheetInformationText(server.DB, informationForm.InformationText, sheet)
+	server.audit(c, "sheet.update_info", "sheet", sheet.SafeSheetName, before, models.AuditSnapshot(newSheet))
 
 	c.JSON(http.StatusOK, newSheet)
 }
@@ -236,13 +452,101 @@ func getSheet(db *gorm.DB, c *gin.Context) *models.Sheet {
 		utils.DoError(c, http.StatusBadRequest, errors.New("missing URL parameter 'sheetName'"))
 		return nil
 	}
+	return findVisibleSheet(db, c, sheetName)
+}
+
+func findVisibleSheet(db *gorm.DB, c *gin.Context, sheetName string) *models.Sheet {
 
+	// Sheets hidden from the user are reported as missing, so not even their name leaks
 	var sheetModel models.Sheet
 	sheet, err := sheetModel.FindSheetBySafeName(db, sheetName)
+	visible := false
+	if err == nil {
+		visible, err = sheet.VisibleTo(db, middlewares.CurrentUser(c))
+	}
+	if gorm.IsRecordNotFoundError(err) || (err == nil && !visible) {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("unable to find sheet: %s", sheetName))
+		return nil
+	}
 	if err != nil {
 		utils.DoError(c, http.StatusInternalServerError, fmt.Errorf("unable to get sheet %s: %s", sheetName, err.Error()))
 		return nil
 	}
-
 	return sheet
 }
+
+func checkSheetOwnership(c *gin.Context, sheet *models.Sheet) bool {
+
+	// Answer with a 403 if the user may not modify the sheet
+	if !sheet.CanBeModifiedBy(middlewares.CurrentUser(c)) {
+		utils.DoError(c, http.StatusForbidden, fmt.Errorf("only the uploader of %s or an editor is able to modify it", sheet.SheetName))
+		return false
+	}
+	return true
+}
+
+/*
+	Hand a sheet over to another user (admins only).
+	Example request:
+		PUT /api/sheet/fuer-elise/owner
+		Body:
+			- user_id: 2
+*/
+func (server *Server) TransferSheetOwnership(c *gin.Context) {
+	sheet := getSheet(server.DB, c)
+	if sheet == nil {
+		return
+	}
+
+	var form forms.TransferOwnershipRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	before := models.AuditSnapshot(sheet)
+	updatedSheet, err := sheet.TransferOwnership(server.DB, form.UserID)
+	if gorm.IsRecordNotFoundError(err) {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("user %d not found", form.UserID))
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "sheet.transfer", "sheet", sheet.SafeSheetName, before, models.AuditSnapshot(updatedSheet))
+	c.JSON(http.StatusOK, updatedSheet)
+}
+
+/*
+	Choose who sees a sheet, its uploader and editors always do.
+	Example request:
+		PUT /api/sheet/fuer-elise/visibility
+		Body:
+			- visibility: group
+			- group_id: 2
+*/
+func (server *Server) SetSheetVisibility(c *gin.Context) {
+	sheet := getSheet(server.DB, c)
+	if sheet == nil || !checkSheetOwnership(c, sheet) {
+		return
+	}
+
+	var form forms.VisibilityRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	visibility, groupID, ok := server.validateVisibility(c, form.Visibility, form.GroupID)
+	if !ok {
+		return
+	}
+
+	before := models.AuditSnapshot(sheet)
+	if err := sheet.SetVisibility(server.DB, visibility, groupID); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "sheet.visibility", "sheet", sheet.SafeSheetName, before, models.AuditSnapshot(sheet))
+	c.JSON(http.StatusOK, sheet)
+}
@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
+	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	Return all smart collections of the user plus the ones shared by others.
+	Example request:
+		GET /api/smart-collections
+*/
+func (server *Server) GetSmartCollections(c *gin.Context) {
+	uid := middlewares.CurrentUser(c).ID
+
+	searches, err := models.FindSavedSearchesForUser(server.DB, uid)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, searches)
+}
+
+/*
+	Save a search as a smart collection.
+	Example request:
+		POST /api/smart-collections
+		Body (JSON):
+		{
+			"name": "Baroque + unlearned",
+			"shared": false,
+			"text": "",
+			"composers": [],
+			"epochs": ["Baroque"],
+			"tags": ["unlearned"],
+			"tag_mode": "all"
+		}
+*/
+func (server *Server) CreateSmartCollection(c *gin.Context) {
+	uid := middlewares.CurrentUser(c).ID
+
+	var form forms.SmartCollectionRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	search := models.SavedSearch{UserID: uid}
+	applySmartCollectionForm(&search, form)
+	search.Prepare()
+	if err := search.Validate(); err != nil {
+		utils.DoError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	created, err := search.SaveSavedSearch(server.DB)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "smart_collection.create", "smart_collection", fmt.Sprint(created.ID), nil, models.AuditSnapshot(created))
+	c.JSON(http.StatusCreated, created)
+}
+
+func (server *Server) GetSmartCollection(c *gin.Context) {
+	search := server.getSmartCollection(c, false)
+	if search == nil {
+		return
+	}
+	c.JSON(http.StatusOK, search)
+}
+
+// Only the owner or an admin can update a smart collection
+func (server *Server) UpdateSmartCollection(c *gin.Context) {
+	search := server.getSmartCollection(c, true)
+	if search == nil {
+		return
+	}
+
+	var form forms.SmartCollectionRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	before := models.AuditSnapshot(search)
+	applySmartCollectionForm(search, form)
+	search.Prepare()
+	if err := search.Validate(); err != nil {
+		utils.DoError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	updated, err := search.UpdateSavedSearch(server.DB)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "smart_collection.update", "smart_collection", fmt.Sprint(updated.ID), before, models.AuditSnapshot(updated))
+	c.JSON(http.StatusOK, updated)
+}
+
+// Only the owner or an admin can delete a smart collection
+func (server *Server) DeleteSmartCollection(c *gin.Context) {
+	search := server.getSmartCollection(c, true)
+	if search == nil {
+		return
+	}
+
+	before := models.AuditSnapshot(search)
+	if err := search.DeleteSavedSearch(server.DB); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "smart_collection.delete", "smart_collection", fmt.Sprint(search.ID), before, nil)
+	c.JSON(http.StatusOK, "Smart collection was successfully deleted")
+}
+
+/*
+	Return the sheets currently matching a smart collection.
+	Paginated the same way as GetSheetsPage:
+		- sort_by: (how is it sorted)
+		- page: (what page)
+		- limit: (limit number)
+*/
+func (server *Server) GetSmartCollectionSheets(c *gin.Context) {
+	search := server.getSmartCollection(c, false)
+	if search == nil {
+		return
+	}
+
+	var form forms.GetSmartCollectionSheetsRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := form.ValidateForm(); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	pagination := models.Pagination{
+		Sort:  form.SortBy,
+		Limit: form.Limit,
+		Page:  form.Page,
+	}
+
+	query := search.SheetQuery()
+	query.Viewer = middlewares.CurrentUser(c)
+	result, err := query.Run(server.DB, pagination)
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, result.Pagination)
+}
+
+func applySmartCollectionForm(search *models.SavedSearch, form forms.SmartCollectionRequest) {
+	search.Name = form.Name
+	search.Shared = form.Shared
+	search.Filters = models.SavedSearchFilters{
+		Text:      form.Text,
+		Composers: form.Composers,
+		Epochs:    form.Epochs,
+		Tags:      form.Tags,
+		TagMode:   form.TagMode,
+	}
+}
+
+func (server *Server) getSmartCollection(c *gin.Context, modify bool) *models.SavedSearch {
+
+	// Find a smart collection by its id and check if the user may access it
+	uid := middlewares.CurrentUser(c).ID
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, errors.New("invalid smart collection id"))
+		return nil
+	}
+
+	var searchModel models.SavedSearch
+	search, err := searchModel.FindSavedSearchByID(server.DB, uint32(id))
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			utils.DoError(c, http.StatusNotFound, fmt.Errorf("smart collection %d not found", id))
+			return nil
+		}
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return nil
+	}
+
+	isAdmin := middlewares.CurrentUser(c).IsAdmin()
+	if !search.VisibleTo(uid) && !isAdmin {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("smart collection %d not found", id))
+		return nil
+	}
+	if modify && search.UserID != uid && !isAdmin {
+		utils.DoError(c, http.StatusForbidden, errors.New("only the owner is able to modify this smart collection"))
+		return nil
+	}
+	return search
+}
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
+	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	Return every tag in the library together with the number of sheets
+	the user may see that use it.
+	Example request:
+		GET /api/tags
+*/
+func (server *Server) GetTags(c *gin.Context) {
+	tags, err := models.ListTagsWithCount(server.DB, middlewares.CurrentUser(c))
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+/*
+	Rename a tag on every sheet and/or update its color and description (editors and admins only).
+	Example request:
+		PUT /api/tags/xmas
+		Body:
+			- name: Christmas
+			- color: #c0392b
+			- description: Pieces for the christmas concert
+	Leaving out a field keeps it, an empty color or description clears it.
+*/
+func (server *Server) UpdateTag(c *gin.Context) {
+	tag := getTag(server.DB, c)
+	if tag == nil {
+		return
+	}
+
+	var form forms.UpdateTagRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	before := models.AuditSnapshot(tag)
+	updatedTag, err := tag.UpdateTag(server.DB, form.Name, form.Color, form.Description)
+	if errors.Is(err, models.ErrTagExists) {
+		utils.DoError(c, http.StatusConflict, err)
+		return
+	}
+	if errors.Is(err, models.ErrTagBelowItself) {
+		utils.DoError(c, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "tag.update", "tag", fmt.Sprint(tag.ID), before, models.AuditSnapshot(updatedTag))
+	c.JSON(http.StatusOK, updatedTag)
+}
+
+/*
+	Merge several tags into one (editors and admins only).
+	Example request:
+		POST /api/tags/merge
+		Body (JSON):
+		{
+			"sources": ["xmas", "x-mas"],
+			"target": "Christmas"
+		}
+*/
+func (server *Server) MergeTags(c *gin.Context) {
+	var form forms.MergeTagsRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := form.ValidateForm(); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	tag, err := models.MergeTags(server.DB, form.Sources, form.Target)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, fmt.Errorf("unable to merge tags: %v", err))
+		return
+	}
+	server.audit(c, "tag.merge", "tag", fmt.Sprint(tag.ID), gin.H{"tags": form.Sources}, gin.H{"tags": []string{tag.Name}})
+	c.JSON(http.StatusOK, tag)
+}
+
+/*
+	Delete a tag from every sheet at once (editors and admins only).
+	Example request:
+		DELETE /api/tags/xmas
+*/
+func (server *Server) DeleteTagEverywhere(c *gin.Context) {
+	tag := getTag(server.DB, c)
+	if tag == nil {
+		return
+	}
+
+	before := models.AuditSnapshot(tag)
+	affected, err := tag.DeleteTag(server.DB)
+	if errors.Is(err, models.ErrNestedTags) {
+		utils.DoError(c, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "tag.delete", "tag", fmt.Sprint(tag.ID), before, nil)
+	c.JSON(http.StatusOK, fmt.Sprintf("Tag: [%s] was removed from %d sheets", tag.Name, affected))
+}
+
+/*
+	Return all tags as a tree, nested tags are listed as children of their parent.
+	Example request:
+		GET /api/tags/tree
+*/
+func (server *Server) GetTagTree(c *gin.Context) {
+	tree, err := models.BuildTagTree(server.DB, middlewares.CurrentUser(c))
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, tree)
+}
+
+/*
+	Move a tag and all its children below another tag (editors and admins only).
+	Example request:
+		POST /api/tags/move
+		Body (JSON):
+		{
+			"tag": "Violin",
+			"parent": "Instrument/Strings"
+		}
+	Results in the tag "Instrument/Strings/Violin". An empty parent moves the tag to the top level.
+*/
+func (server *Server) MoveTag(c *gin.Context) {
+	var form forms.MoveTagRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := form.ValidateForm(); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var tagModel models.Tag
+	tag, err := tagModel.FindTagByName(server.DB, form.Tag)
+	if err != nil {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("unable to find tag: %s", form.Tag))
+		return
+	}
+
+	before := models.AuditSnapshot(tag)
+	movedTag, err := models.MoveTag(server.DB, tag, form.Parent)
+	if err != nil {
+		utils.DoError(c, http.StatusConflict, err)
+		return
+	}
+	server.audit(c, "tag.move", "tag", fmt.Sprint(tag.ID), before, models.AuditSnapshot(movedTag))
+	c.JSON(http.StatusOK, movedTag)
+}
+
+func getTag(db *gorm.DB, c *gin.Context) *models.Tag {
+
+	// Find a tag by its name, nested names contain slashes so the parameter is a catch-all
+	tagName := strings.TrimPrefix(c.Param("tagName"), "/")
+	if tagName == "" {
+		utils.DoError(c, http.StatusBadRequest, errors.New("missing URL parameter 'tagName'"))
+		return nil
+	}
+
+	var tagModel models.Tag
+	tag, err := tagModel.FindTagByName(db, tagName)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			utils.DoError(c, http.StatusNotFound, fmt.Errorf("unable to find tag: %s", tagName))
+			return nil
+		}
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return nil
+	}
+	return tag
+}
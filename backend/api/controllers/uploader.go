@@ -11,6 +11,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
@@ -18,12 +19,12 @@ import (
 	"strings"
 	"time"
 
-	"github.com/SheetAble/SheetAble/backend/api/auth"
 	"github.com/SheetAble/SheetAble/backend/api/forms"
 	. "github.com/fiam/gounidecode/unidecode"
 	"github.com/gin-gonic/gin"
 
 	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
 	"github.com/SheetAble/SheetAble/backend/api/models"
 	"github.com/SheetAble/SheetAble/backend/api/utils"
 	"github.com/kennygrant/sanitize"
@@ -32,7 +33,7 @@ import (
 // Structs for handling the response on the Open Opus API
 
 type Response struct {
-	Composers *[]Comp `json: "composers"`
+	Composers *[]Comp `json:"composers"`
 }
 
 type Comp struct {
@@ -46,22 +47,31 @@ type Comp struct {
 }
 
 func (server *Server) UploadFile(c *gin.Context) {
-	// Check for authentication
-	token := utils.ExtractToken(c)
-	uid, err := auth.ExtractTokenID(token, Config().ApiSecret)
-	if err != nil || uid == 0 {
-		c.String(http.StatusUnauthorized, "Unauthorized")
-		return
+	uid := middlewares.CurrentUser(c).ID
+	if sheet := server.uploadFile(c, uid, nil); sheet != nil {
+		server.audit(c, "sheet.upload", "sheet", sheet.SafeSheetName, nil, models.AuditSnapshot(sheet))
 	}
+}
 
+// Returns the new sheet, nil if the upload failed. A new version keeps the visibility of the original unless the form sets one.
+func (server *Server) uploadFile(c *gin.Context, uid uint32, original *models.Sheet) *models.Sheet {
 	var uploadForm forms.UploadRequest
-	if err = c.ShouldBind(&uploadForm); err != nil {
+	if err := c.ShouldBind(&uploadForm); err != nil {
 		utils.DoError(c, http.StatusBadRequest, fmt.Errorf("bad upload request: %v", err))
-		return
+		return nil
 	}
-	if err = uploadForm.ValidateForm(); err != nil {
+	if err := uploadForm.ValidateForm(); err != nil {
 		utils.DoError(c, http.StatusBadRequest, err)
-		return
+		return nil
+	}
+	visibility, groupID := models.VisibilityEveryone, uint32(0)
+	if original != nil && uploadForm.Visibility == "" {
+		visibility, groupID = original.Visibility, original.GroupID
+	} else {
+		var ok bool
+		if visibility, groupID, ok = server.validateVisibility(c, uploadForm.Visibility, uploadForm.GroupID); !ok {
+			return nil
+		}
 	}
 
 	prePath := path.Join(Config().ConfigPath, "sheets")
@@ -69,7 +79,7 @@ func (server *Server) UploadFile(c *gin.Context) {
 	thumbnailPath := path.Join(Config().ConfigPath, "sheets/thumbnails")
 
 	// Save composer in the database
-	comp := safeComposer(server, uploadForm.Composer)
+	comp := safeComposer(server, uploadForm.Composer, uid)
 
 	utils.CreateDir(prePath)
 	utils.CreateDir(uploadPath)
@@ -84,52 +94,61 @@ func (server *Server) UploadFile(c *gin.Context) {
 
 	fullpath, err := checkFile(uploadPath, sheetName)
 	if fullpath == "" || err != nil {
-		return
+		return nil
 	}
 
 	// Create file
 	theFile, err := uploadForm.File.Open()
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
-		return
+		return nil
 	}
 	defer theFile.Close()
-	err = createFile(uid, server, fullpath, theFile, comp, sheetName, releaseDate, uploadForm.InformationText)
+	sheet, err := createFile(uid, server, fullpath, theFile, comp, sheetName, releaseDate, uploadForm.InformationText, visibility, groupID)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
-		return
+		return nil
 	}
 
 	// Send POST request to python server for creating the thumbnail (first page of pdf as an image)
 	if !utils.RequestToPdfToImage(fullpath, sanitize.Name(Unidecode(sheetName))) {
-		return
+		return sheet
 	}
 
 	// Return that we have successfully uploaded our file!
 	c.JSON(http.StatusAccepted, "File uploaded successfully")
+	return sheet
 }
 
 func (server *Server) UpdateSheet(c *gin.Context) {
-
-	// Check for authentication
-	token := utils.ExtractToken(c)
-	uid, err := auth.ExtractTokenID(token, Config().ApiSecret)
-	if err != nil || uid == 0 {
-		c.String(http.StatusUnauthorized, "Unauthorized")
+	sheetName := c.Param("sheetName")
+	sheet := getSheet(server.DB, c)
+	if sheet == nil || !checkSheetOwnership(c, sheet) {
 		return
 	}
+	before := models.AuditSnapshot(sheet)
 
-	sheetName := c.Param("sheetName")
-
-	// Delete Sheet
-	var sheet models.Sheet
-	_, err = sheet.DeleteSheet(server.DB, sheetName)
+	// Delete Sheet, its setlist entries, favorites and views stay for the new version
+	_, err := sheet.DeleteSheet(server.DB, sheetName)
 	if err != nil {
 		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
 
-	server.UploadFile(c)
+	// Upload the new version, it stays with the original uploader
+	updated := server.uploadFile(c, sheet.UploaderID, sheet)
+	if updated != nil {
+		server.audit(c, "sheet.update", "sheet", sheetName, before, models.AuditSnapshot(updated))
+	} else {
+		server.audit(c, "sheet.delete", "sheet", sheetName, before, nil)
+	}
+
+	// Without a new version under the same name the old references point nowhere
+	if updated == nil || updated.SafeSheetName != sheetName {
+		if err := models.DeleteSheetReferences(server.DB, sheetName); err != nil {
+			log.Printf("error removing references of sheet %s: %s", sheetName, err.Error())
+		}
+	}
 
 }
 
@@ -172,7 +191,7 @@ func getPortraitURL(composerName string) Comp {
 	return composers[0]
 }
 
-func safeComposer(server *Server, composer string) Comp {
+func safeComposer(server *Server, composer string, uid uint32) Comp {
 
 	compo := getPortraitURL(composer)
 
@@ -187,6 +206,7 @@ func safeComposer(server *Server, composer string) Comp {
 		SafeName:    compo.SafeName,
 		PortraitURL: compo.Portrait,
 		Epoch:       compo.Epoch,
+		OwnerID:     uid,
 	}
 
 	comp.Prepare()
@@ -207,7 +227,7 @@ func checkComposer(path string, comp Comp) string {
 	return path
 }
 
-func createFile(uid uint32, server *Server, fullpath string, file multipart.File, comp Comp, sheetName string, releaseDate string, informationText string) error {
+func createFile(uid uint32, server *Server, fullpath string, file multipart.File, comp Comp, sheetName string, releaseDate string, informationText string, visibility string, groupID uint32) (*models.Sheet, error) {
 	// Create database entry
 	sheet := models.Sheet{
 		SafeSheetName:   sanitize.Name(Unidecode(sheetName)),
@@ -217,19 +237,21 @@ func createFile(uid uint32, server *Server, fullpath string, file multipart.File
 		UploaderID:      uid,
 		ReleaseDate:     createDate(releaseDate),
 		InformationText: informationText,
+		Visibility:      visibility,
+		GroupID:         groupID,
 	}
 	sheet.Prepare()
 
 	_, err := sheet.SaveSheet(server.DB)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	err = utils.OsCreateFile(fullpath, file)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return &sheet, nil
 }
 
 func createDate(date string) time.Time {
@@ -1,38 +1,29 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/SheetAble/SheetAble/backend/api/auth"
 	. "github.com/SheetAble/SheetAble/backend/api/config"
 	"github.com/SheetAble/SheetAble/backend/api/forms"
+	"github.com/SheetAble/SheetAble/backend/api/middlewares"
 	"github.com/SheetAble/SheetAble/backend/api/models"
 	"github.com/SheetAble/SheetAble/backend/api/utils"
 	"github.com/SheetAble/SheetAble/backend/api/utils/formaterror"
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 
+	"log"
 	"net/http"
 	"strconv"
 )
 
+// Only users allowed to manage users reach this, see routes.go
 func (server *Server) CreateUser(c *gin.Context) {
-
-	// Check for authentication
-	token := utils.ExtractToken(c)
-	uid, err := auth.ExtractTokenID(token, Config().ApiSecret)
-	if err != nil {
-		c.String(http.StatusUnauthorized, "Unauthorized")
-		return
-	}
-
-	if uid != ADMIN_UID {
-		c.String(http.StatusUnauthorized, "only Admins are able to persue this command")
-		return
-	}
-
 	var user models.User
-	err = c.BindJSON(&user)
+	err := c.BindJSON(&user)
 	if err != nil {
 		c.String(http.StatusUnprocessableEntity, err.Error())
 		return
@@ -50,24 +41,13 @@ func (server *Server) CreateUser(c *gin.Context) {
 		c.String(http.StatusUnprocessableEntity, formattedError.Error())
 		return
 	}
+	server.audit(c, "user.create", "user", fmt.Sprint(userCreated.ID), nil, models.AuditSnapshot(userCreated))
 	c.Header("Location", fmt.Sprintf("%s%s/%d", c.Request.Host, c.Request.RequestURI, userCreated.ID))
 	c.JSON(http.StatusCreated, userCreated)
 }
 
+// Only users allowed to manage users reach this, see routes.go
 func (server *Server) GetUsers(c *gin.Context) {
-
-	// Check for authentication
-	token := utils.ExtractToken(c)
-	uid, err := auth.ExtractTokenID(token, Config().ApiSecret)
-	if err != nil {
-		c.String(http.StatusUnauthorized, "Unauthorized")
-		return
-	}
-	if uid != ADMIN_UID {
-		c.String(http.StatusUnauthorized, "Only admins are able to persue this command")
-		return
-	}
-
 	user := models.User{}
 
 	users, err := user.FindAllUsers(server.DB)
@@ -86,11 +66,8 @@ func (server *Server) GetUser(c *gin.Context) {
 		return
 	}
 
-	token := utils.ExtractToken(c)
-	userId, err := auth.ExtractTokenID(token, Config().ApiSecret)
-	if err != nil {
-		c.String(http.StatusUnauthorized, "Unauthorized")
-	}
+	currentUser := middlewares.CurrentUser(c)
+	userId := currentUser.ID
 
 	var newUid uint32 = uint32(uid)
 	if uid == 0 {
@@ -100,9 +77,9 @@ func (server *Server) GetUser(c *gin.Context) {
 		newUid = userId
 	}
 
-	// Check for admin
-	if uid != 0 && userId != ADMIN_UID {
-		c.String(http.StatusUnauthorized, "Only admins are able to look at user that aren't themselves. Try the endpoint /users/0 to look at your own user details")
+	// Check for the permission to look at other users
+	if uid != 0 && newUid != userId && !currentUser.Can(models.PermissionManageUsers) {
+		c.String(http.StatusForbidden, "Only admins are able to look at user that aren't themselves. Try the endpoint /users/0 to look at your own user details")
 		return
 	}
 
@@ -143,14 +120,9 @@ func (server *Server) UpdateUser(c *gin.Context) {
 		return
 	}
 
-	token := utils.ExtractToken(c)
-	tokenID, err := auth.ExtractTokenID(token, Config().ApiSecret)
-	if err != nil {
-		c.String(http.StatusUnauthorized, "Unauthorized")
-		return
-	}
-	if tokenID != uint32(uid) && tokenID != ADMIN_UID {
-		c.String(http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+	currentUser := middlewares.CurrentUser(c)
+	if currentUser.ID != uint32(uid) && !currentUser.Can(models.PermissionManageUsers) {
+		c.String(http.StatusForbidden, http.StatusText(http.StatusForbidden))
 		return
 	}
 	user.Prepare()
@@ -159,6 +131,14 @@ func (server *Server) UpdateUser(c *gin.Context) {
 		c.String(http.StatusUnprocessableEntity, err.Error())
 		return
 	}
+	var before map[string]interface{}
+	passwordChanged := true
+	var userModel models.User
+	if original, err := userModel.FindUserByID(server.DB, uint32(uid)); err == nil {
+		before = models.AuditSnapshot(original)
+		// The form always sends the password, it only changed if it doesn't match the stored hash
+		passwordChanged = models.VerifyPassword(original.Password, user.Password) != nil
+	}
 	updatedUser, err := user.UpdateAUser(server.DB, uint32(uid))
 	if err != nil {
 		fmt.Println(err)
@@ -166,6 +146,22 @@ func (server *Server) UpdateUser(c *gin.Context) {
 		c.String(http.StatusUnprocessableEntity, formattedError.Error())
 		return
 	}
+
+	// A new password logs the user out on every other device and drops their API tokens
+	if passwordChanged {
+		_, sessionID, _ := auth.ExtractTokenSession(utils.ExtractToken(c), Config().ApiSecret)
+		if err := models.RevokeUserSessions(server.DB, uint32(uid), sessionID); err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err := models.RevokeUserApiTokens(server.DB, uint32(uid)); err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	after := models.AuditSnapshot(updatedUser)
+	after["password_changed"] = passwordChanged
+	server.audit(c, "user.update", "user", fmt.Sprint(uid), before, after)
 	c.JSON(http.StatusOK, updatedUser)
 }
 
@@ -178,27 +174,79 @@ func (server *Server) DeleteUser(c *gin.Context) {
 		return
 	}
 
-	token := utils.ExtractToken(c)
-	tokenID, err := auth.ExtractTokenID(token, Config().ApiSecret)
-	if err != nil {
-		c.String(http.StatusUnauthorized, "Unauthorized")
+	currentUser := middlewares.CurrentUser(c)
+	if currentUser.ID != uint32(uid) && !currentUser.Can(models.PermissionManageUsers) {
+		c.String(http.StatusForbidden, http.StatusText(http.StatusForbidden))
 		return
 	}
-	if tokenID != 1 && tokenID != uint32(uid) {
-		c.String(http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
-		return
+
+	var before map[string]interface{}
+	var userModel models.User
+	if original, err := userModel.FindUserByID(server.DB, uint32(uid)); err == nil {
+		before = models.AuditSnapshot(original)
 	}
 
 	var user models.User
 	_, err = user.DeleteAUser(server.DB, uint32(uid))
+	if errors.Is(err, models.ErrLastAdmin) {
+		c.String(http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	server.audit(c, "user.delete", "user", fmt.Sprint(uid), before, nil)
 	c.Header("Entity", fmt.Sprint(uid))
 	c.JSON(http.StatusNoContent, gin.H{})
 }
 
+/*
+	Change the role of a user (admins only).
+	Example request:
+		PUT /api/users/2/role
+		Body:
+			- role: editor (one of admin, editor, contributor, viewer)
+*/
+func (server *Server) UpdateUserRole(c *gin.Context) {
+	uid, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var form forms.UpdateRoleRequest
+	if err := c.ShouldBind(&form); err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+	role, err := models.ParseRole(form.Role)
+	if err != nil {
+		utils.DoError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var userModel models.User
+	user, err := userModel.FindUserByID(server.DB, uint32(uid))
+	if err != nil {
+		utils.DoError(c, http.StatusNotFound, fmt.Errorf("user %d not found", uid))
+		return
+	}
+
+	before := models.AuditSnapshot(user)
+	err = user.SetRole(server.DB, role)
+	if errors.Is(err, models.ErrLastAdmin) {
+		utils.DoError(c, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	server.audit(c, "user.role", "user", fmt.Sprint(user.ID), before, models.AuditSnapshot(user))
+	c.JSON(http.StatusOK, user)
+}
+
 func (server *Server) ResetPassword(c *gin.Context) {
 	var form forms.ResetPasswordRequest
 	if err := c.ShouldBind(&form); err != nil {
@@ -215,10 +263,15 @@ func (server *Server) ResetPassword(c *gin.Context) {
 		c.JSON(statusCode, err.Error())
 		return
 	}
+	server.auditAs(c, user, "user.password_reset", "user", fmt.Sprint(user.ID), nil, gin.H{"password_changed": true})
 
 	c.JSON(http.StatusOK, user)
 }
 
+/*
+	Send a password reset link. The response is the same whether the email
+	belongs to an account or not, so it can't be used to find out who has one.
+*/
 func (server *Server) RequestPasswordReset(c *gin.Context) {
 	var form forms.RequestResetPasswordRequest
 	if err := c.ShouldBind(&form); err != nil {
@@ -230,15 +283,33 @@ func (server *Server) RequestPasswordReset(c *gin.Context) {
 		return
 	}
 
-	resetPasswordId, err := models.RequestPasswordReset(server.DB, form.Email)
-	if err != nil {
-		c.JSON(http.StatusNotFound, err.Error())
+	// Every request counts, the endpoint sends emails to whoever is entered
+	ipKey := models.ThrottleKey(models.ResetIPRule.Scope, "ip", middlewares.ClientIP(c))
+	accountKey := models.ThrottleKey(models.ResetAccountRule.Scope, "account", form.Email)
+	if server.throttled(c, ipKey, accountKey) {
+		return
+	}
+	if err := models.RegisterThrottleFailure(server.DB, models.ResetIPRule, ipKey, middlewares.ClientIP(c), form.Email); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := models.RegisterThrottleFailure(server.DB, models.ResetAccountRule, accountKey, middlewares.ClientIP(c), form.Email); err != nil {
+		utils.DoError(c, http.StatusInternalServerError, err)
 		return
 	}
+
 	if Config().Smtp.Enabled == "0" {
 		c.JSON(http.StatusBadGateway, "SMTP backend not configured. Go take a look at the docs to get started with emails.")
 		return
 	}
-	utils.SendPasswordResetEmail(resetPasswordId, form.Email)
-	c.JSON(http.StatusOK, "Sent email successfully")
+
+	ttl := time.Duration(Config().PasswordResetMinutes) * time.Minute
+	resetToken, err := models.RequestPasswordReset(server.DB, form.Email, ttl)
+	if err == nil {
+		// In the background, so the response doesn't take longer for existing accounts
+		go utils.SendPasswordResetEmail(resetToken, form.Email, ttl)
+	} else if !gorm.IsRecordNotFoundError(err) {
+		log.Printf("error requesting password reset: %s", err.Error())
+	}
+	c.JSON(http.StatusOK, "If the email belongs to an account, a reset link was sent to it")
 }
@@ -0,0 +1,43 @@
+package forms
+
+type LockoutEventsRequest struct {
+	Scope string `form:"scope"` // login, password_reset or register, all if empty
+	Limit int    `form:"limit,default=20"`
+	Page  int    `form:"page,default=1"`
+}
+
+func (req *LockoutEventsRequest) ValidateForm() error {
+	return validatePage(req.Page, req.Limit)
+}
+
+type AuditEventsRequest struct {
+	ActorID    uint32 `form:"actor_id"`
+	Action     string `form:"action"`      // e.g. sheet.delete
+	TargetType string `form:"target_type"` // sheet, composer, tag, user, ...
+	TargetID   string `form:"target_id"`
+	From       string `form:"from"` // 2006-01-02 or RFC 3339
+	To         string `form:"to"`   // Exclusive, a plain date means the end of that day
+	Limit      int    `form:"limit,default=50"`
+	Page       int    `form:"page,default=1"`
+}
+
+func (req *AuditEventsRequest) ValidateForm() error {
+	return validatePage(req.Page, req.Limit)
+}
+
+type UnlockRequest struct {
+	Key string `form:"key" json:"key" binding:"required"` // Key of the lock, e.g. login:account:someone@example.com
+}
+
+type InvitationRequest struct {
+	Email string `form:"email" json:"email" binding:"required"`
+	Role  string `form:"role" json:"role" binding:"required"` // admin, editor, contributor or viewer
+}
+
+type ApproveRegistrationRequest struct {
+	Role string `form:"role" json:"role"` // Keeps the role of the registration if empty
+}
+
+type AcceptInvitationRequest struct {
+	Password string `form:"password" json:"password" binding:"required"`
+}
@@ -1,7 +1,51 @@
 package forms
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Columns lists of sheets and composers may be sorted by
+var (
+	sheetSortColumns    = []string{"sheet_name", "safe_sheet_name", "composer", "safe_composer", "release_date", "uploader_id", "category", "created_at", "updated_at"}
+	composerSortColumns = []string{"name", "safe_name", "epoch", "created_at", "updated_at"}
+)
+
 type PaginatedRequest struct {
 	SortBy string `form:"sort_by,default=updated_at desc"`
 	Limit  int    `form:"limit,default=10"`
 	Page   int    `form:"page,default=1"`
 }
+
+func validatePage(page int, limit int) error {
+	if page < 1 {
+		return errors.New("page has to be at least 1")
+	}
+	if limit < 1 {
+		return errors.New("limit has to be at least 1")
+	}
+	return nil
+}
+
+func validateSort(sortBy string, columns []string) (string, error) {
+	/*
+		sort_by ends up in ORDER BY, so only "<column> asc" or "<column> desc"
+		with one of the given columns is accepted. Empty means newest first.
+	*/
+	fields := strings.Fields(strings.ToLower(sortBy))
+	if len(fields) == 0 {
+		return "updated_at desc", nil
+	}
+	if len(fields) == 1 {
+		fields = append(fields, "asc")
+	}
+	known := false
+	for _, column := range columns {
+		known = known || fields[0] == column
+	}
+	if len(fields) != 2 || !known || (fields[1] != "asc" && fields[1] != "desc") {
+		return "", fmt.Errorf("sort_by has to be one of %s followed by asc or desc", strings.Join(columns, ", "))
+	}
+	return fields[0] + " " + fields[1], nil
+}
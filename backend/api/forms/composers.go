@@ -6,6 +6,14 @@ type GetComposersPageRequest struct {
 	PaginatedRequest
 }
 
+func (req *GetComposersPageRequest) ValidateForm() error {
+	var err error
+	if req.SortBy, err = validateSort(req.SortBy, composerSortColumns); err != nil {
+		return err
+	}
+	return validatePage(req.Page, req.Limit)
+}
+
 type UpdateComposersRequest struct {
 	Name        string                `form:"name"`
 	PortraitUrl string                `form:"portrait_url"`
@@ -0,0 +1,14 @@
+package forms
+
+type GroupRequest struct {
+	Name string `form:"name" json:"name" binding:"required"`
+}
+
+type GroupMemberRequest struct {
+	UserID uint32 `form:"user_id" json:"user_id" binding:"required"`
+}
+
+type VisibilityRequest struct {
+	Visibility string `form:"visibility" json:"visibility" binding:"required"` // private, group or everyone
+	GroupID    uint32 `form:"group_id" json:"group_id"`                        // Only for the visibility group
+}
@@ -0,0 +1,33 @@
+package forms
+
+import "errors"
+
+// Favorites and recent sheets always come in the order they were starred / opened
+type UserSheetsRequest struct {
+	Limit int `form:"limit,default=10"`
+	Page  int `form:"page,default=1"`
+}
+
+func (req *UserSheetsRequest) ValidateForm() error {
+	return validatePage(req.Page, req.Limit)
+}
+
+type ApiTokenRequest struct {
+	Name          string   `form:"name" json:"name" binding:"required"`
+	Scopes        []string `form:"scopes" json:"scopes" binding:"required"` // sheets:read, sheets:write, library:manage, users:manage
+	ExpiresInDays int      `form:"expires_in_days" json:"expires_in_days"`  // 0 for a token that never expires
+}
+
+func (req *ApiTokenRequest) ValidateForm() error {
+	if len(req.Name) > 100 {
+		return errors.New("The name of a token can't be longer than 100 characters.")
+	}
+	if req.ExpiresInDays < 0 {
+		return errors.New("expires_in_days can't be negative.")
+	}
+	return nil
+}
+
+type MfaCodeRequest struct {
+	Code string `form:"code" json:"code" binding:"required"` // Code of the authenticator app or a recovery code
+}
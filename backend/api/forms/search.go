@@ -0,0 +1,36 @@
+package forms
+
+import (
+	"errors"
+	"strings"
+)
+
+type SuggestRequest struct {
+	Query string `form:"q"`
+	Types string `form:"types"`
+	Limit int    `form:"limit,default=5"`
+}
+
+func (req *SuggestRequest) ValidateForm() error {
+	if req.Limit < 1 || req.Limit > 20 {
+		return errors.New("limit has to be between 1 and 20")
+	}
+	for _, t := range req.TypeList() {
+		if t != "sheet" && t != "composer" && t != "tag" {
+			return errors.New("unknown suggestion type: " + t)
+		}
+	}
+	return nil
+}
+
+// Requested suggestion types, all of them if none are given
+func (req *SuggestRequest) TypeList() []string {
+	if strings.TrimSpace(req.Types) == "" {
+		return []string{"sheet", "composer", "tag"}
+	}
+	types := strings.Split(req.Types, ",")
+	for i := range types {
+		types[i] = strings.TrimSpace(types[i])
+	}
+	return types
+}
@@ -0,0 +1,43 @@
+package forms
+
+import "errors"
+
+type SetlistRequest struct {
+	Name  string `form:"name" json:"name" binding:"required"`
+	Date  string `form:"date" json:"date"` // YYYY-MM-DD
+	Venue string `form:"venue" json:"venue"`
+	Notes string `form:"notes" json:"notes"`
+}
+
+type SetlistEntryRequest struct {
+	Sheet    string `form:"sheet" json:"sheet" binding:"required"` // Safe sheet name
+	PageFrom int    `form:"page_from" json:"page_from"`
+	PageTo   int    `form:"page_to" json:"page_to"`
+	Note     string `form:"note" json:"note"`
+	Position int    `form:"position" json:"position"` // 1 based, empty appends the entry
+}
+
+type UpdateSetlistEntryRequest struct {
+	PageFrom int    `form:"page_from" json:"page_from"`
+	PageTo   int    `form:"page_to" json:"page_to"`
+	Note     string `form:"note" json:"note"`
+}
+
+type MoveSetlistEntryRequest struct {
+	Position int `form:"position" json:"position" binding:"required"`
+}
+
+type ReorderSetlistRequest struct {
+	Entries []uint32 `form:"entries" json:"entries"` // Entry ids in the new order
+}
+
+func (r *ReorderSetlistRequest) ValidateForm() error {
+	if len(r.Entries) == 0 {
+		return errors.New("entries must not be empty")
+	}
+	return nil
+}
+
+type SetlistPdfRequest struct {
+	Separators bool `form:"separators" json:"separators"` // Put a page with title and composer in front of every piece
+}
@@ -1,6 +1,85 @@
 package forms
 
+import "errors"
+
 type GetSheetsPageRequest struct {
 	PaginatedRequest
 	Composer string `form:"composer"`
 }
+
+func (req *GetSheetsPageRequest) ValidateForm() error {
+	var err error
+	if req.SortBy, err = validateSort(req.SortBy, sheetSortColumns); err != nil {
+		return err
+	}
+	return validatePage(req.Page, req.Limit)
+}
+
+type SheetQueryRequest struct {
+	SortBy         string   `form:"sort_by" json:"sort_by"`
+	Limit          int      `form:"limit" json:"limit"`
+	Page           int      `form:"page" json:"page"`
+	Text           string   `form:"text" json:"text"`
+	Composers      []string `form:"composers" json:"composers"`
+	Epochs         []string `form:"epochs" json:"epochs"`
+	Tags           []string `form:"tags" json:"tags"`
+	TagMode        string   `form:"tag_mode" json:"tag_mode"` // "all" (default) or "any"
+	ReleasedAfter  string   `form:"released_after" json:"released_after"`
+	ReleasedBefore string   `form:"released_before" json:"released_before"`
+	UploaderID     uint32   `form:"uploader_id" json:"uploader_id"`
+	CreatedAfter   string   `form:"created_after" json:"created_after"`
+	CreatedBefore  string   `form:"created_before" json:"created_before"`
+	HasAttachments *bool    `form:"has_attachments" json:"has_attachments"` // Unset finds sheets with and without
+}
+
+func (req *SheetQueryRequest) ValidateForm() error {
+	if req.TagMode == "" {
+		req.TagMode = "all"
+	}
+	if req.TagMode != "all" && req.TagMode != "any" {
+		return errors.New("tag_mode has to be either 'all' or 'any'")
+	}
+	var err error
+	if req.SortBy, err = validateSort(req.SortBy, sheetSortColumns); err != nil {
+		return err
+	}
+	if req.Limit == 0 {
+		req.Limit = 10
+	}
+	if req.Page == 0 {
+		req.Page = 1
+	}
+	return validatePage(req.Page, req.Limit)
+}
+
+type BulkSheetsRequest struct {
+	Sheets   []string `form:"sheets" json:"sheets"`
+	Action   string   `form:"action" json:"action"`
+	Tags     []string `form:"tags" json:"tags"`
+	Composer string   `form:"composer" json:"composer"`
+	Category string   `form:"category" json:"category"`
+}
+
+func (req *BulkSheetsRequest) ValidateForm() error {
+	if len(req.Sheets) == 0 {
+		return errors.New("You need to give at least one sheet (sheets).")
+	}
+	switch req.Action {
+	case "add_tags", "remove_tags":
+		if len(req.Tags) == 0 {
+			return errors.New("You need to give at least one tag (tags).")
+		}
+	case "set_composer":
+		if req.Composer == "" {
+			return errors.New("You need to give a composer (composer).")
+		}
+	case "set_category", "delete", "export":
+	default:
+		return errors.New("action has to be one of add_tags, remove_tags, set_composer, set_category, delete or export")
+	}
+	return nil
+}
+
+type TransferOwnershipRequest struct {
+	UserID uint32 `form:"user_id" json:"user_id" binding:"required"`
+}
@@ -0,0 +1,23 @@
+package forms
+
+type SmartCollectionRequest struct {
+	Name      string   `form:"name" json:"name"`
+	Shared    bool     `form:"shared" json:"shared"`
+	Text      string   `form:"text" json:"text"`
+	Composers []string `form:"composers" json:"composers"`
+	Epochs    []string `form:"epochs" json:"epochs"`
+	Tags      []string `form:"tags" json:"tags"`
+	TagMode   string   `form:"tag_mode" json:"tag_mode"` // "all" (default) or "any"
+}
+
+type GetSmartCollectionSheetsRequest struct {
+	PaginatedRequest
+}
+
+func (req *GetSmartCollectionSheetsRequest) ValidateForm() error {
+	var err error
+	if req.SortBy, err = validateSort(req.SortBy, sheetSortColumns); err != nil {
+		return err
+	}
+	return validatePage(req.Page, req.Limit)
+}
@@ -1,5 +1,42 @@
 package forms
 
+import "errors"
+
 type TagRequest struct {
-	TagValue string `form:"tagValue"`
+	TagValue           string `form:"tagValue"`
+	IncludeDescendants bool   `form:"includeDescendants"`
+}
+
+// Fields which aren't sent stay as they are, an empty color or description clears it
+type UpdateTagRequest struct {
+	Name        string  `form:"name" json:"name"`
+	Color       *string `form:"color" json:"color"`
+	Description *string `form:"description" json:"description"`
+}
+
+type MergeTagsRequest struct {
+	Sources []string `form:"sources" json:"sources"`
+	Target  string   `form:"target" json:"target"`
+}
+
+func (req *MergeTagsRequest) ValidateForm() error {
+	if len(req.Sources) == 0 {
+		return errors.New("You need to give at least one tag to merge (sources).")
+	}
+	if req.Target == "" {
+		return errors.New("You need to give a tag to merge into (target).")
+	}
+	return nil
+}
+
+type MoveTagRequest struct {
+	Tag    string `form:"tag" json:"tag"`
+	Parent string `form:"parent" json:"parent"` // Empty to move the tag to the top level
+}
+
+func (req *MoveTagRequest) ValidateForm() error {
+	if req.Tag == "" {
+		return errors.New("You need to give the tag to move (tag).")
+	}
+	return nil
 }
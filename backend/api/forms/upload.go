@@ -10,6 +10,8 @@ type UploadRequest struct {
 	Categories      string                `form:"categories"`
 	Tags            string                `form:"tags"`
 	InformationText string                `form:"informationText"`
+	Visibility      string                `form:"visibility"` // private, group or everyone, everyone if empty
+	GroupID         uint32                `form:"group_id"`
 }
 
 // Currently a no-op but enables us to add any custom form validation in without having to change any calling code.
@@ -27,3 +27,25 @@ func (req *RequestResetPasswordRequest) ValidateForm() error {
 	}
 	return nil
 }
+
+type RegisterRequest struct {
+	Email    string `form:"email" json:"email" binding:"required"`
+	Password string `form:"password" json:"password" binding:"required"`
+}
+
+type UpdateRoleRequest struct {
+	Role string `form:"role" json:"role" binding:"required"` // admin, editor, contributor or viewer
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `form:"refresh_token" json:"refresh_token"` // Falls back to the refresh_token cookie
+}
+
+type LogoutRequest struct {
+	All bool `form:"all" json:"all"` // End every session of the user, not only the current one
+}
+
+type MfaLoginRequest struct {
+	ChallengeID string `form:"challenge_id" json:"challenge_id" binding:"required"`
+	Code        string `form:"code" json:"code" binding:"required"` // Code of the authenticator app or a recovery code
+}
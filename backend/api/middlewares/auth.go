@@ -1,15 +1,31 @@
 package middlewares
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/SheetAble/SheetAble/backend/api/auth"
 	"github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/SheetAble/SheetAble/backend/api/models"
 	"github.com/SheetAble/SheetAble/backend/api/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// Full paths of the POST routes which only read, like queries sent as a body
+type ReadOnlyRoutes map[string]bool
+
+func (routes ReadOnlyRoutes) Allow(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPost:
+		return routes[c.FullPath()]
+	}
+	return false
+}
+
+func AuthMiddleware(db *gorm.DB, readOnly ReadOnlyRoutes) gin.HandlerFunc {
 	/*
 		Do any initial setup for the middleware once here
 
@@ -18,14 +34,114 @@ func AuthMiddleware() gin.HandlerFunc {
 		- Like fetching over the net to a vault/secrets server
 	*/
 	secret := config.Config().ApiSecret
+	proxyConfig := config.Config().ProxyAuth
+	networks := trustedProxies(proxyConfig)
 
 	return func(c *gin.Context) {
-		err := auth.TokenValid(utils.ExtractToken(c), secret)
+		// Behind an authenticating proxy its user header takes the place of the token
+		if proxyConfig.Enabled && c.GetHeader(proxyConfig.UserHeader) != "" && fromTrustedProxy(c, networks) {
+			user, status, err := useProxyUser(db, c, proxyConfig)
+			if status == http.StatusForbidden {
+				c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				c.AbortWithStatusJSON(status, gin.H{"error": "Unable to log in the user of the proxy"})
+				return
+			}
+			c.Set(currentUserKey, user)
+			c.Next()
+			return
+		}
+
+		token := utils.ExtractToken(c)
+		uid, sessionID, err := auth.ExtractTokenSession(token, secret)
 		if err != nil {
+			// Not a login, maybe a personal API token
+			if apiToken, err := useApiToken(db, token, secret); err == nil {
+				if apiToken.ReadOnly() && !readOnly.Allow(c) {
+					c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This API token may only read"})
+					return
+				}
+				c.Set(apiTokenKey, apiToken)
+				c.Next()
+				return
+			}
+
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
 
+		// Tokens of sessions which were logged out or revoked are rejected even before they expire
+		active, err := models.SessionActive(db, sessionID, uid)
+		if err != nil || !active {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			return
 		}
 		c.Next()
 	}
 }
+
+const MediaTokenCookie = "media_token"
+
+func MediaAuthMiddleware(db *gorm.DB) gin.HandlerFunc {
+	/*
+		For the images the browser loads on its own, which can't send the Authorization header.
+		They authenticate with the http-only media token cookie set on login,
+		requests without it go through the AuthMiddleware as usual.
+	*/
+	secret := config.Config().ApiSecret
+	authenticate := AuthMiddleware(db, nil)
+
+	return func(c *gin.Context) {
+		cookie, err := c.Cookie(MediaTokenCookie)
+		if err != nil || cookie == "" {
+			authenticate(c)
+			return
+		}
+
+		uid, sessionID, err := auth.ExtractMediaToken(cookie, secret)
+		if err == nil {
+			var active bool
+			if active, err = models.SessionActive(db, sessionID, uid); err == nil && !active {
+				err = errors.New("session ended")
+			}
+		}
+		if err != nil {
+			// A stale cookie, the request may still carry a valid token
+			authenticate(c)
+			return
+		}
+
+		var userModel models.User
+		user, err := userModel.FindUserByID(db, uid)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Set(currentUserKey, user)
+		c.Next()
+	}
+}
+
+func RequireSession() gin.HandlerFunc {
+	/*
+		For routes which manage the account itself, like creating API tokens.
+		A leaked API token must not be able to create new ones or change the password.
+	*/
+	return func(c *gin.Context) {
+		if _, ok := c.Get(apiTokenKey); ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This action needs a login, API tokens can't be used"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func useApiToken(db *gorm.DB, token string, secret string) (*models.ApiToken, error) {
+	uid, tokenID, err := auth.ExtractApiToken(token, secret)
+	if err != nil {
+		return nil, err
+	}
+	return models.UseApiToken(db, tokenID, uid)
+}
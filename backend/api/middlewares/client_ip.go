@@ -0,0 +1,43 @@
+package middlewares
+
+import (
+	"log"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	"github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/gin-gonic/gin"
+)
+
+const clientIPKey = "clientIP"
+
+func ResolveClientIP() gin.HandlerFunc {
+	/*
+		Find the address of the client once for the rate limits and the audit log.
+		gin's ClientIP believes X-Forwarded-For from everybody, here it only counts
+		if the connection comes from one of TRUSTED_PROXIES.
+	*/
+	networks, err := auth.ParseTrustedProxies(config.Config().TrustedProxies)
+	if err != nil {
+		log.Fatalf("error in TRUSTED_PROXIES: %s", err.Error())
+	}
+
+	return func(c *gin.Context) {
+		remote, _ := c.RemoteIP()
+		if ip := auth.ForwardedClientIP(networks, remote, c.GetHeader("X-Forwarded-For")); ip != nil {
+			c.Set(clientIPKey, ip.String())
+		}
+		c.Next()
+	}
+}
+
+// The address set by ResolveClientIP, the address of the connection if it didn't run
+func ClientIP(c *gin.Context) string {
+	if ip, ok := c.Get(clientIPKey); ok {
+		return ip.(string)
+	}
+	remote, _ := c.RemoteIP()
+	if remote == nil {
+		return ""
+	}
+	return remote.String()
+}
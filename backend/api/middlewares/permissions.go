@@ -0,0 +1,77 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	"github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	currentUserKey = "currentUser"
+	apiTokenKey    = "apiToken"
+)
+
+func LoadUser(db *gorm.DB) gin.HandlerFunc {
+	/*
+		Load the user behind the token once per request.
+		Has to run after the AuthMiddleware, handlers get the user through CurrentUser.
+	*/
+	secret := config.Config().ApiSecret
+
+	return func(c *gin.Context) {
+		// Already loaded by the AuthMiddleware for users of a reverse proxy
+		if CurrentUser(c) != nil {
+			c.Next()
+			return
+		}
+
+		uid, err := auth.ExtractTokenID(utils.ExtractToken(c), secret)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var userModel models.User
+		user, err := userModel.FindUserByID(db, uid)
+		if err != nil {
+			// The user got deleted while the token was still valid
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if apiToken, ok := c.Get(apiTokenKey); ok {
+			user.RestrictToApiToken(apiToken.(*models.ApiToken))
+		}
+		c.Set(currentUserKey, user)
+		c.Next()
+	}
+}
+
+func RequirePermission(permission models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if user == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if !user.Can(permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Your role doesn't allow this action", "permission": permission})
+			return
+		}
+		c.Next()
+	}
+}
+
+// The user loaded by LoadUser, nil outside of the secure routes
+func CurrentUser(c *gin.Context) *models.User {
+	value, ok := c.Get(currentUserKey)
+	if !ok {
+		return nil
+	}
+	user, _ := value.(*models.User)
+	return user
+}
@@ -0,0 +1,76 @@
+package middlewares
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	"github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/SheetAble/SheetAble/backend/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+func ProxyHeaderGuard() gin.HandlerFunc {
+	/*
+		With PROXY_AUTH_ENABLED anybody sending the user header would be logged in as
+		that user, so the headers are only accepted from the trusted proxies.
+		Runs on every route, not just the secure ones.
+	*/
+	proxyConfig := config.Config().ProxyAuth
+	networks := trustedProxies(proxyConfig)
+
+	return func(c *gin.Context) {
+		if proxyConfig.Enabled && hasProxyHeaders(c, proxyConfig) && !fromTrustedProxy(c, networks) {
+			log.Printf("rejected reverse proxy login headers from untrusted address %s", c.Request.RemoteAddr)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Login headers are only accepted from trusted proxies"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func trustedProxies(proxyConfig config.ProxyAuthConfig) []*net.IPNet {
+	if !proxyConfig.Enabled {
+		return nil
+	}
+	networks, err := auth.ParseTrustedProxies(proxyConfig.TrustedProxies)
+	if err != nil {
+		log.Fatalf("error in PROXY_AUTH_TRUSTED_PROXIES: %s", err.Error())
+	}
+	return networks
+}
+
+func hasProxyHeaders(c *gin.Context, proxyConfig config.ProxyAuthConfig) bool {
+	for _, header := range []string{proxyConfig.UserHeader, proxyConfig.EmailHeader, proxyConfig.GroupsHeader} {
+		if header != "" && c.GetHeader(header) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the connection itself comes from a trusted proxy, forwarded-for headers can be forged
+func fromTrustedProxy(c *gin.Context, networks []*net.IPNet) bool {
+	ip, _ := c.RemoteIP()
+	return auth.ProxyTrusted(networks, ip)
+}
+
+func useProxyUser(db *gorm.DB, c *gin.Context, proxyConfig config.ProxyAuthConfig) (*models.User, int, error) {
+	identity := auth.NewProxyIdentity(
+		c.GetHeader(proxyConfig.UserHeader),
+		c.GetHeader(proxyConfig.EmailHeader),
+		c.GetHeader(proxyConfig.GroupsHeader),
+	)
+	user, err := models.SignInWithProxy(db, identity, proxyConfig)
+	if models.IsExternalLoginRejected(err) || errors.Is(err, models.ErrAccountPending) {
+		return nil, http.StatusForbidden, err
+	}
+	if err != nil {
+		log.Printf("reverse proxy login of %s failed: %s", identity.User, err.Error())
+		return nil, http.StatusInternalServerError, err
+	}
+	return user, http.StatusOK, nil
+}
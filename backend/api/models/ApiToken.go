@@ -0,0 +1,158 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	Personal API tokens let scripts act as their user without knowing the password.
+	The scopes narrow down what the role of the user allows, a token never grants
+	more than its user could do.
+*/
+type ApiToken struct {
+	ID         uint32     `gorm:"primary_key;auto_increment" json:"id"`
+	UserID     uint32     `gorm:"not null;index" json:"user_id"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	Scopes     string     `gorm:"size:255;not null" json:"scopes"` // Space separated like in OAuth, e.g. "sheets:read sheets:write"
+	ExpiresAt  *time.Time `json:"expires_at"`                      // Never expires if nil
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+const (
+	ScopeSheetsRead    = "sheets:read"
+	ScopeSheetsWrite   = "sheets:write"
+	ScopeLibraryManage = "library:manage"
+	ScopeUsersManage   = "users:manage"
+)
+
+var scopePermissions = map[string][]Permission{
+	ScopeSheetsRead:    {PermissionViewSheets},
+	ScopeSheetsWrite:   {PermissionViewSheets, PermissionUploadSheets},
+	ScopeLibraryManage: {PermissionViewSheets, PermissionUploadSheets, PermissionManageLibrary},
+	ScopeUsersManage:   {PermissionManageUsers},
+}
+
+var ErrInvalidApiToken = errors.New("invalid, expired or revoked API token")
+
+func ParseScopes(scopes []string) (string, error) {
+	parsed := []string{}
+	for _, scope := range scopes {
+		// Accept "a b" and "a,b" as well as separate values
+		for _, s := range strings.FieldsFunc(scope, func(r rune) bool { return r == ' ' || r == ',' }) {
+			s = strings.ToLower(s)
+			if _, ok := scopePermissions[s]; !ok {
+				return "", fmt.Errorf("unknown scope %s, expected sheets:read, sheets:write, library:manage or users:manage", s)
+			}
+			parsed = append(parsed, s)
+		}
+	}
+	if len(parsed) == 0 {
+		return "", errors.New("a token needs at least one scope")
+	}
+	return strings.Join(parsed, " "), nil
+}
+
+func (t *ApiToken) Grants(permission Permission) bool {
+	for _, scope := range strings.Fields(t.Scopes) {
+		for _, p := range scopePermissions[scope] {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (t *ApiToken) ReadOnly() bool {
+
+	// Tokens which may only read can't use routes which change anything, see ReadOnlyRoutes
+	for _, scope := range strings.Fields(t.Scopes) {
+		if scope != ScopeSheetsRead {
+			return false
+		}
+	}
+	return true
+}
+
+func CreateApiToken(db *gorm.DB, uid uint32, name string, scopes string, expiresAt *time.Time, apiSecret string) (*ApiToken, string, error) {
+	/*
+		Create a token and return it together with its secret value.
+		The value can't be shown again later, only the token id is kept.
+	*/
+	token := &ApiToken{
+		UserID:    uid,
+		Name:      strings.TrimSpace(name),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	if err := db.Create(token).Error; err != nil {
+		return &ApiToken{}, "", err
+	}
+
+	value, err := auth.CreateApiToken(uid, token.ID, expiresAt, apiSecret)
+	if err != nil {
+		db.Delete(token)
+		return &ApiToken{}, "", err
+	}
+	return token, value, nil
+}
+
+func FindApiTokensByUser(db *gorm.DB, uid uint32) ([]*ApiToken, error) {
+	tokens := []*ApiToken{}
+	err := db.Where("user_id = ?", uid).Order("created_at desc").Find(&tokens).Error
+	return tokens, err
+}
+
+func RevokeApiToken(db *gorm.DB, uid uint32, id uint32) error {
+	result := db.Where("id = ? AND user_id = ?", id, uid).Delete(&ApiToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Whoever got hold of the password may have created tokens with it, drop them all when it changes
+func RevokeUserApiTokens(db *gorm.DB, uid uint32) error {
+	return db.Where("user_id = ?", uid).Delete(&ApiToken{}).Error
+}
+
+func UseApiToken(db *gorm.DB, id uint32, uid uint32) (*ApiToken, error) {
+
+	// Look up a token presented by a client and remember when it was last used
+	token := &ApiToken{}
+	err := db.Where("id = ? AND user_id = ?", id, uid).Take(token).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &ApiToken{}, ErrInvalidApiToken
+	}
+	if err != nil {
+		return &ApiToken{}, err
+	}
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now()) {
+		return &ApiToken{}, ErrInvalidApiToken
+	}
+
+	now := time.Now()
+	token.LastUsedAt = &now
+	err = db.Model(&ApiToken{}).Where("id = ?", token.ID).UpdateColumn("last_used_at", now).Error
+	return token, err
+}
+
+// Limit the permissions of the user to what the token grants, see Can
+func (u *User) RestrictToApiToken(token *ApiToken) {
+	u.apiToken = token
+}
+
+func (u *User) ApiToken() *ApiToken {
+	return u.apiToken
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	Who changed what and when. Every upload, edit and delete, every change of
+	tags, composers and users and every login leaves an event. The diff holds
+	the fields which changed, with their value before and after.
+*/
+type AuditEvent struct {
+	ID         uint32    `gorm:"primary_key;auto_increment" json:"id"`
+	ActorID    uint32    `gorm:"index" json:"actor_id"` // 0 if nobody was logged in, e.g. a failed login
+	ActorEmail string    `gorm:"size:100" json:"actor_email"`
+	IP         string    `gorm:"size:45" json:"ip"`
+	Action     string    `gorm:"size:50;index" json:"action"`      // e.g. sheet.delete or user.role
+	TargetType string    `gorm:"size:50;index" json:"target_type"` // sheet, composer, tag, user, ...
+	TargetID   string    `gorm:"size:255;index" json:"target_id"`
+	DiffJSON   string    `gorm:"column:diff;type:text" json:"-"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+
+	Diff map[string]AuditChange `gorm:"-" json:"diff"`
+}
+
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type AuditFilter struct {
+	ActorID    uint32
+	Action     string
+	TargetType string
+	TargetID   string
+	From       time.Time
+	To         time.Time
+}
+
+// Fields which are never written to the audit log
+var auditIgnoredFields = map[string]bool{
+	"password":   true,
+	"updated_at": true,
+}
+
+func (e *AuditEvent) AfterFind() error {
+	if e.DiffJSON == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(e.DiffJSON), &e.Diff)
+}
+
+/*
+	The JSON fields of a value at this moment, taken before and after a change.
+	Taking it right away matters, later changes to the value must not show up in it.
+*/
+func AuditSnapshot(value interface{}) map[string]interface{} {
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil()) {
+		return nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	snapshot := map[string]interface{}{}
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		// Not an object, e.g. a tag name
+		var plain interface{}
+		json.Unmarshal(b, &plain)
+		return map[string]interface{}{"value": plain}
+	}
+	return snapshot
+}
+
+func AuditDiff(before map[string]interface{}, after map[string]interface{}) map[string]AuditChange {
+	diff := map[string]AuditChange{}
+	for field, value := range before {
+		if !auditIgnoredFields[field] && !reflect.DeepEqual(value, after[field]) {
+			diff[field] = AuditChange{Before: value, After: after[field]}
+		}
+	}
+	for field, value := range after {
+		if _, ok := before[field]; !ok && !auditIgnoredFields[field] {
+			diff[field] = AuditChange{Before: nil, After: value}
+		}
+	}
+	return diff
+}
+
+func RecordAudit(db *gorm.DB, event AuditEvent, before map[string]interface{}, after map[string]interface{}) error {
+	event.Diff = AuditDiff(before, after)
+	if len(event.Diff) > 0 {
+		b, err := json.Marshal(event.Diff)
+		if err != nil {
+			return err
+		}
+		event.DiffJSON = string(b)
+	}
+	event.CreatedAt = time.Now()
+	return db.Create(&event).Error
+}
+
+func FindAuditEvents(db *gorm.DB, filter AuditFilter, pagination Pagination) (*Pagination, error) {
+	query := db.Model(&AuditEvent{})
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+
+	var totalRows int64
+	if err := query.Count(&totalRows).Error; err != nil {
+		return nil, err
+	}
+	pagination.TotalRows = totalRows
+	pagination.TotalPages = int(math.Ceil(float64(totalRows) / float64(pagination.GetLimit())))
+
+	events := []*AuditEvent{}
+	err := query.Order("created_at desc, id desc").
+		Offset(pagination.GetOffset()).
+		Limit(pagination.GetLimit()).
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	pagination.Sort = "created_at desc"
+	pagination.Rows = events
+	return &pagination, nil
+}
+
+// Drop events older than the retention, nothing is dropped for a retention of 0
+func PruneAuditEvents(db *gorm.DB, retention time.Duration) (int64, error) {
+	if retention <= 0 {
+		return 0, nil
+	}
+	result := db.Where("created_at < ?", time.Now().Add(-retention)).Delete(&AuditEvent{})
+	return result.RowsAffected, result.Error
+}
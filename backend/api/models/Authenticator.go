@@ -0,0 +1,133 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+	A way to check a login and password, e.g. against the local bcrypt hashes or a directory.
+	The login tries the authenticators in the order of AUTH_ORDER until one accepts.
+*/
+type Authenticator interface {
+	Name() string
+	Authenticate(db *gorm.DB, login string, password string) (*User, error)
+}
+
+type LocalAuthenticator struct{}
+
+func (LocalAuthenticator) Name() string {
+	return "local"
+}
+
+func (LocalAuthenticator) Authenticate(db *gorm.DB, email string, password string) (*User, error) {
+	user := &User{}
+	err := db.Model(User{}).Where("email = ?", email).Take(user).Error
+	if err != nil {
+		return &User{}, err
+	}
+	if err := VerifyPassword(user.Password, password); err != nil {
+		return &User{}, err
+	}
+	return user, nil
+}
+
+type LdapAuthenticator struct {
+	Directory *auth.LdapDirectory
+	Config    LdapConfig
+}
+
+func NewLdapAuthenticator(ldapConfig LdapConfig) *LdapAuthenticator {
+	return &LdapAuthenticator{
+		Directory: &auth.LdapDirectory{
+			URL:                ldapConfig.Url,
+			StartTLS:           ldapConfig.StartTLS,
+			InsecureSkipVerify: ldapConfig.InsecureSkipVerify,
+			BindDN:             ldapConfig.BindDN,
+			BindPassword:       ldapConfig.BindPassword,
+			BaseDN:             ldapConfig.BaseDN,
+			UserFilter:         ldapConfig.UserFilter,
+			EmailAttribute:     ldapConfig.EmailAttribute,
+			GroupAttribute:     ldapConfig.GroupAttribute,
+		},
+		Config: ldapConfig,
+	}
+}
+
+func (a *LdapAuthenticator) Name() string {
+	return "ldap"
+}
+
+func (a *LdapAuthenticator) Authenticate(db *gorm.DB, username string, password string) (*User, error) {
+	identity, err := a.Directory.Authenticate(username, password)
+	if err != nil {
+		return &User{}, err
+	}
+	return SignInWithLdap(db, identity, a.Config)
+}
+
+func Authenticators(order string, ldapConfig LdapConfig) ([]Authenticator, error) {
+	authenticators := []Authenticator{}
+	for _, name := range strings.Split(order, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "local":
+			authenticators = append(authenticators, LocalAuthenticator{})
+		case "ldap":
+			authenticators = append(authenticators, NewLdapAuthenticator(ldapConfig))
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown authenticator %s in AUTH_ORDER, expected local or ldap", name)
+		}
+	}
+	if len(authenticators) == 0 {
+		return nil, errors.New("AUTH_ORDER doesn't contain any authenticator")
+	}
+	return authenticators, nil
+}
+
+func SignIn(db *gorm.DB, authenticators []Authenticator, login string, password string) (*User, error) {
+	/*
+		Try the authenticators one after the other.
+		The error of the first one is returned if all of them fail, so the local
+		login keeps its error messages. A user who got authenticated but isn't let in
+		(see IsExternalLoginRejected, ErrAccountPending and ErrEmailUnconfirmed) is rejected right away.
+	*/
+	var firstErr error
+	for _, authenticator := range authenticators {
+		user, err := authenticator.Authenticate(db, login, password)
+		if err == nil {
+			if user.Unconfirmed {
+				return &User{}, ErrEmailUnconfirmed
+			}
+			if user.Pending {
+				return &User{}, ErrAccountPending
+			}
+			return user, nil
+		}
+		if IsExternalLoginRejected(err) {
+			return &User{}, err
+		}
+		if !isWrongCredentials(err) {
+			log.Printf("%s login failed: %s", authenticator.Name(), err.Error())
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return &User{}, firstErr
+}
+
+func isWrongCredentials(err error) bool {
+
+	// Users without a local password (e.g. from single sign-on) have no hash to compare against
+	return gorm.IsRecordNotFoundError(err) || errors.Is(err, auth.ErrLdapUnknownUser) ||
+		errors.Is(err, auth.ErrLdapInvalidCredentials) || errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) ||
+		errors.Is(err, bcrypt.ErrHashTooShort)
+}
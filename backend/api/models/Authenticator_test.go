@@ -0,0 +1,113 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fakeAuthenticator struct {
+	name  string
+	user  *User
+	err   error
+	calls *[]string
+}
+
+func (a fakeAuthenticator) Name() string {
+	return a.name
+}
+
+func (a fakeAuthenticator) Authenticate(db *gorm.DB, login string, password string) (*User, error) {
+	*a.calls = append(*a.calls, a.name)
+	if a.err != nil {
+		return &User{}, a.err
+	}
+	return a.user, nil
+}
+
+func TestSignIn(t *testing.T) {
+	alice := &User{ID: 1, Email: "alice@example.com"}
+	failing := errors.New("directory unreachable")
+
+	tests := []struct {
+		name    string
+		results []fakeAuthenticator
+		user    *User
+		err     error
+		calls   []string
+	}{
+		{
+			name:    "first one accepts",
+			results: []fakeAuthenticator{{name: "local", user: alice}, {name: "ldap", user: alice}},
+			user:    alice,
+			calls:   []string{"local"},
+		},
+		{
+			name:    "falls through to the next one",
+			results: []fakeAuthenticator{{name: "local", err: bcrypt.ErrMismatchedHashAndPassword}, {name: "ldap", user: alice}},
+			user:    alice,
+			calls:   []string{"local", "ldap"},
+		},
+		{
+			name:    "error of the first one is kept",
+			results: []fakeAuthenticator{{name: "local", err: gorm.ErrRecordNotFound}, {name: "ldap", err: auth.ErrLdapInvalidCredentials}},
+			err:     gorm.ErrRecordNotFound,
+			calls:   []string{"local", "ldap"},
+		},
+		{
+			name:    "unexpected errors fall through as well",
+			results: []fakeAuthenticator{{name: "ldap", err: failing}, {name: "local", user: alice}},
+			user:    alice,
+			calls:   []string{"ldap", "local"},
+		},
+		{
+			name:    "rejected external login stops",
+			results: []fakeAuthenticator{{name: "ldap", err: ErrExternalNoRole}, {name: "local", user: alice}},
+			err:     ErrExternalNoRole,
+			calls:   []string{"ldap"},
+		},
+		{
+			name:    "pending user is rejected",
+			results: []fakeAuthenticator{{name: "local", user: &User{ID: 2, Pending: true}}, {name: "ldap", user: alice}},
+			err:     ErrAccountPending,
+			calls:   []string{"local"},
+		},
+		{
+			name:    "unconfirmed user is rejected",
+			results: []fakeAuthenticator{{name: "local", user: &User{ID: 3, Unconfirmed: true}}},
+			err:     ErrEmailUnconfirmed,
+			calls:   []string{"local"},
+		},
+	}
+	for _, test := range tests {
+		calls := []string{}
+		authenticators := []Authenticator{}
+		for _, authenticator := range test.results {
+			authenticator.calls = &calls
+			authenticators = append(authenticators, authenticator)
+		}
+
+		user, err := SignIn(nil, authenticators, "alice@example.com", "secret")
+		assert.Equal(t, test.err, err, test.name)
+		if test.err == nil {
+			assert.Equal(t, test.user, user, test.name)
+		}
+		assert.Equal(t, test.calls, calls, test.name)
+	}
+}
+
+func TestIsWrongCredentials(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	assert.True(t, isWrongCredentials(VerifyPassword(string(hash), "wrong")))
+	assert.True(t, isWrongCredentials(VerifyPassword("", "wrong")))
+	assert.True(t, isWrongCredentials(gorm.ErrRecordNotFound))
+	assert.True(t, isWrongCredentials(auth.ErrLdapUnknownUser))
+	assert.True(t, isWrongCredentials(auth.ErrLdapInvalidCredentials))
+	assert.False(t, isWrongCredentials(errors.New("hashedPassword mentioned by another error")))
+}
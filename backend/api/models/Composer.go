@@ -6,10 +6,12 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/SheetAble/SheetAble/backend/api/utils"
 
 	"github.com/jinzhu/gorm"
 	"github.com/kennygrant/sanitize"
@@ -20,8 +22,17 @@ type Composer struct {
 	Name        string    `json:"name"`
 	PortraitURL string    `json:"portrait_url"`
 	Epoch       string    `json:"epoch"`
+	OwnerID     uint32    `gorm:"not null;default:0" json:"owner_id"`                    // The user whose upload created the composer
+	Visibility  string    `gorm:"size:20;not null;default:'everyone'" json:"visibility"` // private, group or everyone, see Visibility.go
+	GroupID     uint32    `gorm:"not null;default:0" json:"group_id"`
 	CreatedAt   time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt   time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	SearchName  string    `gorm:"index" json:"-"` // Normalized name, lets searches narrow down the candidates in the database
+}
+
+func (c *Composer) BeforeSave() error {
+	c.SearchName = utils.NormalizeSearchString(c.Name)
+	return nil
 }
 
 func (c *Composer) Prepare() {
@@ -29,6 +40,9 @@ func (c *Composer) Prepare() {
 	c.SafeName = strings.TrimSpace(c.SafeName)
 	c.PortraitURL = strings.TrimSpace(c.PortraitURL)
 	c.Epoch = strings.TrimSpace(c.Epoch)
+	if c.Visibility == "" {
+		c.Visibility = VisibilityEveryone
+	}
 	c.CreatedAt = time.Now()
 	c.UpdatedAt = time.Now()
 }
@@ -201,20 +215,37 @@ func (c *Composer) GetAllComposer(db *gorm.DB) (*[]Composer, error) {
 	return &composers, err
 }
 
-func SearchComposer(db *gorm.DB, searchValue string) []*Composer {
+func SearchComposer(db *gorm.DB, searchValue string, viewer *User) ([]*Composer, error) {
+
+	// Search for composers whose name matches the search value, best matches first
+	var candidates []*Composer
+	err := db.Scopes(VisibleComposers(viewer), FuzzyCandidates("composers.search_name", searchValue)).Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
 
-	// Search for sheets with containing string
 	var composers []*Composer
-	searchValue = "%" + searchValue + "%"
-	db.Where("safe_name LIKE ?", searchValue).Find(&composers)
-	return composers
+	scores := map[*Composer]float64{}
+	for _, composer := range candidates {
+		score := utils.FuzzyScore(searchValue, composer.Name)
+		if score >= utils.FuzzyMatchThreshold {
+			scores[composer] = score
+			composers = append(composers, composer)
+		}
+	}
+
+	sort.SliceStable(composers, func(i, j int) bool {
+		return scores[composers[i]] > scores[composers[j]]
+	})
+	return composers, nil
 }
 
-func (c *Composer) List(db *gorm.DB, pagination Pagination) (*Pagination, error) {
+func (c *Composer) List(db *gorm.DB, pagination Pagination, viewer *User) (*Pagination, error) {
 
-	// For pagination
+	// For pagination, only the composers the viewer may see are listed and counted
 	var composers []*Composer
-	db.Scopes(paginate(composers, &pagination, db)).Find(&composers)
+	query := db.Scopes(VisibleComposers(viewer))
+	query.Scopes(paginate(composers, &pagination, query)).Find(&composers)
 	pagination.Rows = composers
 
 	return &pagination, nil
@@ -234,3 +265,16 @@ func CheckAndDeleteUnknownComposer(db *gorm.DB) {
 		db = db.Model(&Composer{}).Where("safe_name = ?", "unknown").Take(&Composer{}).Delete(&Composer{})
 	}
 }
+
+func DeleteUnusedUnknownComposer(db *gorm.DB) error {
+
+	// Remove the unknown composer once no sheet is left with it, for callers which already deleted their sheets
+	var count int64
+	if err := db.Model(&Sheet{}).Where("safe_composer = ?", "unknown").Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return db.Where("safe_name = ?", "unknown").Delete(&Composer{}).Error
+}
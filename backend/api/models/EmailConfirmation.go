@@ -0,0 +1,100 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	A link confirming the email address of somebody who registered themselves.
+	Until it is opened the user can't log in and no single sign-on gets linked to them,
+	otherwise anybody could register with somebody else's address and take over
+	the account once its owner logs in through their provider.
+	Only the hash of the token is stored, it works once and only until ExpiresAt.
+*/
+type EmailConfirmation struct {
+	ID        uint32    `gorm:"primary_key;auto_increment" json:"id"`
+	UserID    uint32    `gorm:"not null;index" json:"user_id"`
+	TokenHash string    `gorm:"size:64;unique_index" json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+var (
+	ErrEmailUnconfirmed         = errors.New("please confirm your email address with the link we sent you first")
+	ErrInvalidEmailConfirmation = errors.New("this confirmation link is invalid or has expired, please register again")
+)
+
+func CreateEmailConfirmation(db *gorm.DB, uid uint32, ttl time.Duration) (string, error) {
+	token, err := auth.NewRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	err = db.Create(&EmailConfirmation{
+		UserID:    uid,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedAt: time.Now(),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func ConfirmEmail(db *gorm.DB, token string) (*User, error) {
+	confirmation := EmailConfirmation{}
+	err := db.Where("token_hash = ?", auth.HashToken(token)).Take(&confirmation).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &User{}, ErrInvalidEmailConfirmation
+	}
+	if err != nil {
+		return &User{}, err
+	}
+	if confirmation.ExpiresAt.Before(time.Now()) {
+		return &User{}, ErrInvalidEmailConfirmation
+	}
+
+	// Deleting it first makes sure the link works only once
+	result := db.Where("id = ?", confirmation.ID).Delete(&EmailConfirmation{})
+	if result.Error != nil {
+		return &User{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &User{}, ErrInvalidEmailConfirmation
+	}
+
+	user := &User{}
+	if _, err := user.FindUserByID(db, confirmation.UserID); err != nil {
+		return &User{}, ErrInvalidEmailConfirmation
+	}
+	if err := db.Model(&User{}).Where("id = ?", user.ID).UpdateColumn("unconfirmed", false).Error; err != nil {
+		return &User{}, err
+	}
+	user.Unconfirmed = false
+	return user, nil
+}
+
+// An unconfirmed registration whose link expired gives its email address free again
+func dropExpiredRegistration(db *gorm.DB, email string) error {
+	user := &User{}
+	err := db.Where("LOWER(email) = LOWER(?) AND unconfirmed = ?", strings.TrimSpace(email), true).Take(user).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	err = db.Model(&EmailConfirmation{}).Where("user_id = ? AND expires_at > ?", user.ID, time.Now()).Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = user.DeleteAUser(db, user.ID)
+	return err
+}
@@ -0,0 +1,177 @@
+package models
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	ErrExternalNoEmail         = errors.New("the login provider didn't send a verified email address")
+	ErrExternalNoRole          = errors.New("none of your groups is allowed to use SheetAble")
+	ErrExternalUnknownUser     = errors.New("there is no SheetAble user with your email address")
+	ErrExternalLinkedElsewhere = errors.New("this email address is already linked to another external account")
+	ErrExternalUnconfirmed     = errors.New("the SheetAble account with your email address isn't confirmed yet")
+)
+
+/*
+	Users logging in through single sign-on or a directory. They are found by
+	their id at the provider, linked by email on their first login or created.
+*/
+type externalLogin struct {
+	column        string // Column of User holding the id, e.g. oidc_subject
+	id            string
+	email         string
+	emailVerified bool
+	groups        []string
+	groupRoles    GroupRoles
+	autoCreate    bool
+}
+
+// Comma separated groups of every role, the highest role one of the groups of a user maps to wins
+type GroupRoles struct {
+	Admin       string
+	Editor      string
+	Contributor string
+	Viewer      string
+	Default     string // Role of users in none of the groups, "none" to reject them
+
+	// How a configured group is compared to one of the user, exact match if nil
+	Matches func(configured string, group string) bool
+}
+
+func (g GroupRoles) RoleFor(groups []string) (role uint8, mapped bool, err error) {
+	/*
+		The highest role one of the groups maps to.
+		mapped is false if no group matched and the default role applies.
+	*/
+	groupsOfRole := []struct {
+		role   uint8
+		groups string
+	}{
+		{RoleAdmin, g.Admin},
+		{RoleEditor, g.Editor},
+		{RoleContributor, g.Contributor},
+		{RoleViewer, g.Viewer},
+	}
+	for _, r := range groupsOfRole {
+		for _, configured := range strings.Split(r.groups, ",") {
+			configured = strings.TrimSpace(configured)
+			if configured == "" {
+				continue
+			}
+			for _, group := range groups {
+				if (g.Matches == nil && group == configured) || (g.Matches != nil && g.Matches(configured, group)) {
+					return r.role, true, nil
+				}
+			}
+		}
+	}
+
+	if strings.EqualFold(g.Default, "none") {
+		return 0, false, ErrExternalNoRole
+	}
+	role, err = ParseRole(g.Default)
+	return role, false, err
+}
+
+func (g GroupRoles) configured() bool {
+	return strings.TrimSpace(g.Admin+g.Editor+g.Contributor+g.Viewer) != ""
+}
+
+func signInExternal(db *gorm.DB, login externalLogin) (*User, error) {
+
+	// With group mappings configured the role follows the groups on every login
+	role, _, err := login.groupRoles.RoleFor(login.groups)
+	if err != nil {
+		return &User{}, err
+	}
+
+	user := &User{}
+	err = db.Where(login.column+" = ?", login.id).Take(user).Error
+	if gorm.IsRecordNotFoundError(err) {
+		user, err = linkExternalUser(db, login, role)
+		if err != nil {
+			return &User{}, err
+		}
+	} else if err != nil {
+		return &User{}, err
+	}
+
+	if login.groupRoles.configured() && user.Role != role {
+		// Keep the role if this would remove the last admin, see SetRole
+		if err := user.SetRole(db, role); err != nil && !errors.Is(err, ErrLastAdmin) {
+			return &User{}, err
+		}
+	}
+	if user.Pending {
+		return &User{}, ErrAccountPending
+	}
+	return user, nil
+}
+
+func linkExternalUser(db *gorm.DB, login externalLogin, role uint8) (*User, error) {
+
+	// Without a verified email anybody could claim an existing account
+	if login.email == "" || !login.emailVerified {
+		return &User{}, ErrExternalNoEmail
+	}
+
+	if err := dropExpiredRegistration(db, login.email); err != nil {
+		return &User{}, err
+	}
+
+	user := &User{}
+	err := db.Where("LOWER(email) = LOWER(?)", strings.TrimSpace(login.email)).Take(user).Error
+	if err == nil {
+		// Whoever registered the address may not own it, see EmailConfirmation.go
+		if user.Unconfirmed {
+			return &User{}, ErrExternalUnconfirmed
+		}
+		linked, err := externalID(db, user.ID, login.column)
+		if err != nil {
+			return &User{}, err
+		}
+		if linked != "" && linked != login.id {
+			return &User{}, ErrExternalLinkedElsewhere
+		}
+		return user, db.Model(&User{}).Where("id = ?", user.ID).UpdateColumn(login.column, login.id).Error
+	}
+	if !gorm.IsRecordNotFoundError(err) {
+		return &User{}, err
+	}
+	if !login.autoCreate {
+		return &User{}, ErrExternalUnknownUser
+	}
+
+	// External users can't log in with a password, so it is random
+	password, err := auth.NewRefreshToken()
+	if err != nil {
+		return &User{}, err
+	}
+	user = &User{Email: login.email, Password: password}
+	user.Prepare()
+	user.Role = role
+	if _, err := user.SaveUser(db); err != nil {
+		return &User{}, err
+	}
+	return user, db.Model(&User{}).Where("id = ?", user.ID).UpdateColumn(login.column, login.id).Error
+}
+
+func externalID(db *gorm.DB, uid uint32, column string) (string, error) {
+	var ids []string
+	err := db.Model(&User{}).Where("id = ?", uid).Pluck(column, &ids).Error
+	if err != nil || len(ids) == 0 {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// Whether the login worked at the provider but SheetAble doesn't let the user in
+func IsExternalLoginRejected(err error) bool {
+	return errors.Is(err, ErrExternalNoEmail) || errors.Is(err, ErrExternalNoRole) ||
+		errors.Is(err, ErrExternalUnknownUser) || errors.Is(err, ErrExternalLinkedElsewhere) ||
+		errors.Is(err, ErrExternalUnconfirmed)
+}
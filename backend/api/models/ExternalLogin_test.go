@@ -0,0 +1,52 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupRolesRoleFor(t *testing.T) {
+	roles := GroupRoles{
+		Admin:       "admins",
+		Editor:      "librarians, teachers",
+		Contributor: "singers",
+		Viewer:      "guests",
+		Default:     "viewer",
+	}
+	tests := []struct {
+		name   string
+		groups []string
+		role   uint8
+		mapped bool
+	}{
+		{"single group", []string{"singers"}, RoleContributor, true},
+		{"highest role wins", []string{"guests", "singers", "admins"}, RoleAdmin, true},
+		{"second group of a role", []string{"teachers"}, RoleEditor, true},
+		{"unknown group", []string{"parents"}, RoleViewer, false},
+		{"no groups", nil, RoleViewer, false},
+		{"match is exact", []string{"Singers", "singers "}, RoleViewer, false},
+	}
+	for _, test := range tests {
+		role, mapped, err := roles.RoleFor(test.groups)
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.role, role, test.name)
+		assert.Equal(t, test.mapped, mapped, test.name)
+	}
+
+	roles.Default = "none"
+	_, _, err := roles.RoleFor([]string{"parents"})
+	assert.Equal(t, ErrExternalNoRole, err)
+
+	roles.Default = "superuser"
+	_, _, err = roles.RoleFor(nil)
+	assert.Error(t, err)
+
+	// LDAP groups match by DN or CN
+	roles = GroupRoles{Editor: "librarians", Default: "viewer", Matches: auth.LdapGroupMatches}
+	role, mapped, err := roles.RoleFor([]string{"cn=librarians,ou=groups,dc=example,dc=org"})
+	assert.NoError(t, err)
+	assert.Equal(t, RoleEditor, role)
+	assert.True(t, mapped)
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"math"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// A sheet starred by a user
+type Favorite struct {
+	UserID        uint32    `gorm:"primary_key;auto_increment:false" json:"user_id"`
+	SafeSheetName string    `gorm:"primary_key;size:255;index" json:"safe_sheet_name"`
+	CreatedAt     time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+func StarSheet(db *gorm.DB, uid uint32, sheetName string) error {
+	var sheetModel Sheet
+	if _, err := sheetModel.FindSheetBySafeName(db, sheetName); err != nil {
+		return err
+	}
+	return db.Where(Favorite{UserID: uid, SafeSheetName: sheetName}).
+		Attrs(Favorite{CreatedAt: time.Now()}).
+		FirstOrCreate(&Favorite{}).Error
+}
+
+func UnstarSheet(db *gorm.DB, uid uint32, sheetName string) error {
+	return db.Where("user_id = ? AND safe_sheet_name = ?", uid, sheetName).Delete(&Favorite{}).Error
+}
+
+func FavoriteSheets(db *gorm.DB, user *User, pagination Pagination) (*Pagination, error) {
+
+	// The favorites of a user, the most recently starred first
+	return pageOfUserSheets(db, "favorites", user, "favorites.created_at desc", pagination)
+}
+
+func pageOfUserSheets(db *gorm.DB, table string, user *User, order string, pagination Pagination) (*Pagination, error) {
+	/*
+		One page of the sheets a user has a row for in the given table (favorites, sheet_views).
+		The table needs a user_id and a safe_sheet_name column.
+		Sheets which were hidden from the user since are left out.
+	*/
+	query := db.Model(&Sheet{}).
+		Joins("JOIN "+table+" ON "+table+".safe_sheet_name = sheets.safe_sheet_name").
+		Where(table+".user_id = ?", user.ID).
+		Scopes(VisibleSheets(user))
+
+	var totalRows int64
+	if err := query.Count(&totalRows).Error; err != nil {
+		return nil, err
+	}
+	pagination.TotalRows = totalRows
+	pagination.TotalPages = int(math.Ceil(float64(totalRows) / float64(pagination.GetLimit())))
+
+	sheets := []*Sheet{}
+	err := query.Select("sheets.*").
+		Order(order).
+		Offset(pagination.GetOffset()).
+		Limit(pagination.GetLimit()).
+		Find(&sheets).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := LoadSheetTags(db, sheets); err != nil {
+		return nil, err
+	}
+	pagination.Sort = order
+	pagination.Rows = sheets
+	return &pagination, nil
+}
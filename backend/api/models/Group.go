@@ -0,0 +1,192 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	A group of users, e.g. a choir or the students of a teacher.
+	Sheets and composers with the visibility "group" are only shown to the members of their group.
+*/
+type Group struct {
+	ID        uint32    `gorm:"primary_key;auto_increment" json:"id"`
+	Name      string    `gorm:"size:100;not null;unique" json:"name"`
+	MemberIDs []uint32  `gorm:"-" json:"member_ids"` // Loaded from the group_members table, see LoadMembers
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+type GroupMember struct {
+	GroupID uint32 `gorm:"primary_key;auto_increment:false" json:"group_id"`
+	UserID  uint32 `gorm:"primary_key;auto_increment:false;index" json:"user_id"`
+}
+
+var ErrGroupExists = errors.New("a group with this name already exists")
+
+// GROUPS is a reserved word in MySQL 8, raw SQL couldn't name the table otherwise
+func (Group) TableName() string {
+	return "user_groups"
+}
+
+func (g *Group) Prepare() {
+	g.Name = strings.TrimSpace(g.Name)
+	g.UpdatedAt = time.Now()
+}
+
+func (g *Group) Validate() error {
+	if g.Name == "" {
+		return errors.New("Required Name")
+	}
+	return nil
+}
+
+func (g *Group) SaveGroup(db *gorm.DB) (*Group, error) {
+	if err := g.ensureNameFree(db); err != nil {
+		return &Group{}, err
+	}
+	g.CreatedAt = time.Now()
+	if err := db.Create(&g).Error; err != nil {
+		return &Group{}, err
+	}
+	g.MemberIDs = []uint32{}
+	return g, nil
+}
+
+func (g *Group) UpdateGroup(db *gorm.DB) (*Group, error) {
+	if err := g.ensureNameFree(db); err != nil {
+		return &Group{}, err
+	}
+	err := db.Model(&Group{}).Where("id = ?", g.ID).UpdateColumns(map[string]interface{}{
+		"name":       g.Name,
+		"updated_at": g.UpdatedAt,
+	}).Error
+	if err != nil {
+		return &Group{}, err
+	}
+	return g, nil
+}
+
+func (g *Group) ensureNameFree(db *gorm.DB) error {
+	var count int64
+	err := db.Model(&Group{}).Where("LOWER(name) = LOWER(?) AND id <> ?", g.Name, g.ID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrGroupExists
+	}
+	return nil
+}
+
+func (g *Group) DeleteGroup(db *gorm.DB) error {
+	/*
+		Sheets and composers of the group become private instead of visible to everyone,
+		deleting a group must never show its material to more people.
+	*/
+	tx := db.Begin()
+	for _, model := range []interface{}{&Sheet{}, &Composer{}} {
+		err := tx.Model(model).Where("group_id = ?", g.ID).UpdateColumns(map[string]interface{}{
+			"visibility": VisibilityPrivate,
+			"group_id":   0,
+		}).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Where("group_id = ?", g.ID).Delete(&GroupMember{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("id = ?", g.ID).Delete(&Group{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func FindGroupByID(db *gorm.DB, id uint32) (*Group, error) {
+	group := &Group{}
+	if err := db.Where("id = ?", id).Take(group).Error; err != nil {
+		return &Group{}, err
+	}
+	if err := LoadGroupMembers(db, []*Group{group}); err != nil {
+		return &Group{}, err
+	}
+	return group, nil
+}
+
+func FindAllGroups(db *gorm.DB) ([]*Group, error) {
+	groups := []*Group{}
+	if err := db.Order("name").Find(&groups).Error; err != nil {
+		return groups, err
+	}
+	return groups, LoadGroupMembers(db, groups)
+}
+
+func FindGroupsOfUser(db *gorm.DB, uid uint32) ([]*Group, error) {
+	groups := []*Group{}
+	err := db.Joins("JOIN group_members ON group_members.group_id = user_groups.id").
+		Where("group_members.user_id = ?", uid).
+		Order("user_groups.name").
+		Find(&groups).Error
+	if err != nil {
+		return groups, err
+	}
+	return groups, LoadGroupMembers(db, groups)
+}
+
+func LoadGroupMembers(db *gorm.DB, groups []*Group) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	ids := make([]uint32, len(groups))
+	byID := map[uint32]*Group{}
+	for i, group := range groups {
+		ids[i] = group.ID
+		group.MemberIDs = []uint32{}
+		byID[group.ID] = group
+	}
+
+	var members []GroupMember
+	if err := db.Where("group_id IN (?)", ids).Order("user_id").Find(&members).Error; err != nil {
+		return err
+	}
+	for _, member := range members {
+		byID[member.GroupID].MemberIDs = append(byID[member.GroupID].MemberIDs, member.UserID)
+	}
+	return nil
+}
+
+func (g *Group) AddMember(db *gorm.DB, uid uint32) error {
+	var userModel User
+	if _, err := userModel.FindUserByID(db, uid); err != nil {
+		return err
+	}
+	err := db.Where(GroupMember{GroupID: g.ID, UserID: uid}).FirstOrCreate(&GroupMember{}).Error
+	if err != nil {
+		return err
+	}
+	return LoadGroupMembers(db, []*Group{g})
+}
+
+func (g *Group) RemoveMember(db *gorm.DB, uid uint32) error {
+	err := db.Where("group_id = ? AND user_id = ?", g.ID, uid).Delete(&GroupMember{}).Error
+	if err != nil {
+		return err
+	}
+	return LoadGroupMembers(db, []*Group{g})
+}
+
+func (g *Group) HasMember(uid uint32) bool {
+	for _, id := range g.MemberIDs {
+		if id == uid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	"github.com/badoux/checkmail"
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	An admin invites someone by email, the invitee picks their own password
+	through the signed link. The link carries the invitation id and a nonce,
+	resending the invitation changes the nonce so older links stop working.
+*/
+type Invitation struct {
+	ID         uint32     `gorm:"primary_key;auto_increment" json:"id"`
+	Email      string     `gorm:"size:100;not null;index" json:"email"`
+	Role       uint8      `json:"role"`
+	InvitedBy  uint32     `gorm:"not null" json:"invited_by"`
+	Nonce      string     `gorm:"size:36;not null" json:"-"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	SentAt     time.Time  `json:"sent_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+	UserID     *uint32    `json:"user_id"` // The user created when the invitation got accepted
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+var (
+	ErrInvalidInvitation    = errors.New("this invitation is invalid, was revoked or has expired")
+	ErrInvitationUserExists = errors.New("there already is a user with this email")
+)
+
+func CreateInvitation(db *gorm.DB, email string, role uint8, invitedBy uint32, ttl time.Duration, apiSecret string) (*Invitation, string, error) {
+	email = html.EscapeString(strings.TrimSpace(email))
+	if err := checkmail.ValidateFormat(email); err != nil {
+		return &Invitation{}, "", errors.New("Invalid Email")
+	}
+	if _, ok := roleNames[role]; !ok {
+		return &Invitation{}, "", errors.New("unknown role")
+	}
+	if err := ensureEmailFree(db, email); err != nil {
+		return &Invitation{}, "", err
+	}
+
+	// Inviting the same email again replaces the invitation sent before
+	err := db.Where("email = ? AND accepted_at IS NULL", email).Delete(&Invitation{}).Error
+	if err != nil {
+		return &Invitation{}, "", err
+	}
+
+	invitation := &Invitation{
+		Email:     email,
+		Role:      role,
+		InvitedBy: invitedBy,
+		Nonce:     uuid.NewString(),
+		ExpiresAt: time.Now().Add(ttl),
+		SentAt:    time.Now(),
+		CreatedAt: time.Now(),
+	}
+	if err := db.Create(invitation).Error; err != nil {
+		return &Invitation{}, "", err
+	}
+	token, err := auth.CreateInvitationToken(invitation.ID, invitation.Nonce, invitation.ExpiresAt, apiSecret)
+	if err != nil {
+		return &Invitation{}, "", err
+	}
+	return invitation, token, nil
+}
+
+// Invitations nobody accepted yet, expired ones included so they can be resent
+func FindPendingInvitations(db *gorm.DB) ([]*Invitation, error) {
+	invitations := []*Invitation{}
+	err := db.Where("accepted_at IS NULL").Order("created_at desc").Find(&invitations).Error
+	return invitations, err
+}
+
+func FindPendingInvitation(db *gorm.DB, id uint32) (*Invitation, error) {
+	invitation := &Invitation{}
+	err := db.Where("id = ? AND accepted_at IS NULL", id).Take(invitation).Error
+	if err != nil {
+		return &Invitation{}, err
+	}
+	return invitation, nil
+}
+
+func (i *Invitation) Resend(db *gorm.DB, ttl time.Duration, apiSecret string) (string, error) {
+
+	// A new link with a new expiry, the old link stops working
+	if err := ensureEmailFree(db, i.Email); err != nil {
+		return "", err
+	}
+	i.Nonce = uuid.NewString()
+	i.ExpiresAt = time.Now().Add(ttl)
+	i.SentAt = time.Now()
+	err := db.Model(&Invitation{}).Where("id = ?", i.ID).UpdateColumns(map[string]interface{}{
+		"nonce":      i.Nonce,
+		"expires_at": i.ExpiresAt,
+		"sent_at":    i.SentAt,
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return auth.CreateInvitationToken(i.ID, i.Nonce, i.ExpiresAt, apiSecret)
+}
+
+func RevokeInvitation(db *gorm.DB, id uint32) error {
+	result := db.Where("id = ? AND accepted_at IS NULL", id).Delete(&Invitation{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func AcceptInvitation(db *gorm.DB, token string, password string, apiSecret string) (*User, error) {
+	/*
+		Create the user of an invitation with the password they chose.
+		Returns ErrInvalidInvitation for unknown, revoked, expired, resent or used links.
+	*/
+	id, nonce, err := auth.ExtractInvitationToken(token, apiSecret)
+	if err != nil {
+		return &User{}, ErrInvalidInvitation
+	}
+	invitation, err := FindPendingInvitation(db, id)
+	if gorm.IsRecordNotFoundError(err) {
+		return &User{}, ErrInvalidInvitation
+	}
+	if err != nil {
+		return &User{}, err
+	}
+	if invitation.Nonce != nonce || invitation.ExpiresAt.Before(time.Now()) {
+		return &User{}, ErrInvalidInvitation
+	}
+	if err := ensureEmailFree(db, invitation.Email); err != nil {
+		return &User{}, err
+	}
+
+	user := &User{Email: invitation.Email, Password: password}
+	user.Prepare()
+	user.Role = invitation.Role
+	if err := user.Validate(""); err != nil {
+		return &User{}, err
+	}
+
+	tx := db.Begin()
+
+	// Only one request can use the link, the other one finds it accepted
+	now := time.Now()
+	result := tx.Model(&Invitation{}).Where("id = ? AND accepted_at IS NULL", invitation.ID).UpdateColumn("accepted_at", now)
+	if result.Error != nil {
+		tx.Rollback()
+		return &User{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return &User{}, ErrInvalidInvitation
+	}
+	if _, err := user.SaveUser(tx); err != nil {
+		tx.Rollback()
+		return &User{}, err
+	}
+	if err := tx.Model(&Invitation{}).Where("id = ?", invitation.ID).UpdateColumn("user_id", user.ID).Error; err != nil {
+		tx.Rollback()
+		return &User{}, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return &User{}, err
+	}
+	return user, nil
+}
+
+func ensureEmailFree(db *gorm.DB, email string) error {
+	if err := dropExpiredRegistration(db, email); err != nil {
+		return err
+	}
+	var count int64
+	if err := db.Model(&User{}).Where("email = ?", email).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrInvitationUserExists
+	}
+	return nil
+}
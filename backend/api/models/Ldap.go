@@ -0,0 +1,32 @@
+package models
+
+import (
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/jinzhu/gorm"
+)
+
+func LdapGroupRoles(ldapConfig LdapConfig) GroupRoles {
+	return GroupRoles{
+		Admin:       ldapConfig.AdminGroups,
+		Editor:      ldapConfig.EditorGroups,
+		Contributor: ldapConfig.ContributorGroups,
+		Viewer:      ldapConfig.ViewerGroups,
+		Default:     ldapConfig.DefaultRole,
+		Matches:     auth.LdapGroupMatches,
+	}
+}
+
+// Find, link or create the user the directory authenticated, see signInExternal
+func SignInWithLdap(db *gorm.DB, identity *auth.LdapIdentity, ldapConfig LdapConfig) (*User, error) {
+	return signInExternal(db, externalLogin{
+		column: "ldap_dn",
+		id:     identity.DN,
+		email:  identity.Email,
+		// The directory is run by the admins, its addresses count as verified
+		emailVerified: true,
+		groups:        identity.Groups,
+		groupRoles:    LdapGroupRoles(ldapConfig),
+		autoCreate:    ldapConfig.AutoCreate,
+	})
+}
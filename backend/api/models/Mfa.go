@@ -0,0 +1,241 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	recoveryCodeCount    = 10
+	mfaChallengeTTL      = 5 * time.Minute
+	mfaChallengeAttempts = 5
+)
+
+var (
+	ErrInvalidMfaCode      = errors.New("invalid two-factor code")
+	ErrMfaAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMfaNotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrMfaEnrollmentNeeded = errors.New("start the two-factor enrollment first")
+	ErrInvalidMfaChallenge = errors.New("the login expired, please log in again")
+)
+
+// A one-time code to log in when the authenticator app is gone, only its hash is kept
+type RecoveryCode struct {
+	ID       uint32     `gorm:"primary_key;auto_increment" json:"id"`
+	UserID   uint32     `gorm:"not null;index" json:"user_id"`
+	CodeHash string     `gorm:"size:64;not null" json:"-"`
+	UsedAt   *time.Time `json:"used_at"`
+}
+
+/*
+	The second step of a login. Created once the password was right,
+	the access token is only handed out after a valid code was sent for it.
+*/
+type MfaChallenge struct {
+	ID        string    `gorm:"primary_key;size:36" json:"id"`
+	UserID    uint32    `gorm:"not null;index" json:"user_id"`
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (u *User) StartTotpEnrollment(db *gorm.DB) (string, error) {
+
+	// A new secret which only becomes active once a code of it was verified
+	if u.TotpEnabled {
+		return "", ErrMfaAlreadyEnabled
+	}
+	secret, err := auth.NewTotpSecret()
+	if err != nil {
+		return "", err
+	}
+	u.TotpSecret = secret
+	return secret, db.Model(&User{}).Where("id = ?", u.ID).UpdateColumn("totp_secret", secret).Error
+}
+
+func (u *User) EnableTotp(db *gorm.DB, code string) ([]string, error) {
+	if u.TotpEnabled {
+		return nil, ErrMfaAlreadyEnabled
+	}
+	if u.TotpSecret == "" {
+		return nil, ErrMfaEnrollmentNeeded
+	}
+	step, ok := auth.ValidateTotp(u.TotpSecret, code, time.Now(), u.TotpLastStep)
+	if !ok {
+		return nil, ErrInvalidMfaCode
+	}
+
+	err := db.Model(&User{}).Where("id = ?", u.ID).UpdateColumns(map[string]interface{}{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	u.TotpEnabled = true
+	u.TotpLastStep = step
+	return NewRecoveryCodes(db, u.ID)
+}
+
+func (u *User) DisableTotp(db *gorm.DB) error {
+	err := db.Model(&User{}).Where("id = ?", u.ID).UpdateColumns(map[string]interface{}{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"totp_last_step": 0,
+	}).Error
+	if err != nil {
+		return err
+	}
+	u.TotpEnabled = false
+	u.TotpSecret = ""
+	u.TotpLastStep = 0
+	return db.Where("user_id = ?", u.ID).Delete(&RecoveryCode{}).Error
+}
+
+func (u *User) VerifyMfaCode(db *gorm.DB, code string) error {
+	/*
+		Accept a code of the authenticator app or an unused recovery code.
+		Either can only be used once.
+	*/
+	if !u.TotpEnabled {
+		return ErrMfaNotEnabled
+	}
+
+	if step, ok := auth.ValidateTotp(u.TotpSecret, code, time.Now(), u.TotpLastStep); ok && u.TotpSecret != "" {
+		// Only count the code if no other request used this or a later step in the meantime
+		result := db.Model(&User{}).Where("id = ? AND totp_last_step < ?", u.ID, step).UpdateColumn("totp_last_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidMfaCode
+		}
+		u.TotpLastStep = step
+		return nil
+	}
+
+	hash := auth.HashToken(normalizeRecoveryCode(code))
+	result := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", u.ID, hash).
+		UpdateColumn("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMfaCode
+	}
+	return nil
+}
+
+func NewRecoveryCodes(db *gorm.DB, uid uint32) ([]string, error) {
+
+	// Replace all recovery codes of the user, the old ones stop working
+	if err := db.Where("user_id = ?", uid).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := []string{}
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := auth.NewRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		err = db.Create(&RecoveryCode{UserID: uid, CodeHash: auth.HashToken(code)}).Error
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func RemainingRecoveryCodes(db *gorm.DB, uid uint32) (int64, error) {
+	var count int64
+	err := db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", uid).Count(&count).Error
+	return count, err
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	if len(code) == 10 && !strings.Contains(code, "-") {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
+
+func CreateMfaChallenge(db *gorm.DB, uid uint32) (*MfaChallenge, error) {
+
+	// Challenges nobody answered in time are of no use anymore
+	db.Where("expires_at < ?", time.Now()).Delete(&MfaChallenge{})
+
+	challenge := &MfaChallenge{
+		ID:        uuid.NewString(),
+		UserID:    uid,
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	}
+	if err := db.Create(challenge).Error; err != nil {
+		return &MfaChallenge{}, err
+	}
+	return challenge, nil
+}
+
+// The user a login challenge belongs to, to count failures against the account
+func FindMfaChallengeUser(db *gorm.DB, challengeID string) (*User, error) {
+	challenge := &MfaChallenge{}
+	err := db.Where("id = ?", challengeID).Take(challenge).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &User{}, ErrInvalidMfaChallenge
+	}
+	if err != nil {
+		return &User{}, err
+	}
+	var userModel User
+	user, err := userModel.FindUserByID(db, challenge.UserID)
+	if err != nil {
+		return &User{}, ErrInvalidMfaChallenge
+	}
+	return user, nil
+}
+
+func CompleteMfaChallenge(db *gorm.DB, challengeID string, code string) (*User, error) {
+	/*
+		Check the code for a challenge of the login.
+		A challenge only allows a few attempts, so codes can't be guessed.
+	*/
+	challenge := &MfaChallenge{}
+	err := db.Where("id = ?", challengeID).Take(challenge).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &User{}, ErrInvalidMfaChallenge
+	}
+	if err != nil {
+		return &User{}, err
+	}
+	if challenge.ExpiresAt.Before(time.Now()) || challenge.Attempts >= mfaChallengeAttempts {
+		db.Delete(challenge)
+		return &User{}, ErrInvalidMfaChallenge
+	}
+	result := db.Model(&MfaChallenge{}).
+		Where("id = ? AND attempts < ?", challenge.ID, mfaChallengeAttempts).
+		UpdateColumn("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return &User{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &User{}, ErrInvalidMfaChallenge
+	}
+
+	var userModel User
+	user, err := userModel.FindUserByID(db, challenge.UserID)
+	if err != nil {
+		return &User{}, ErrInvalidMfaChallenge
+	}
+	if err := user.VerifyMfaCode(db, code); err != nil {
+		return &User{}, err
+	}
+
+	db.Delete(challenge)
+	return user, nil
+}
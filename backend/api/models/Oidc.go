@@ -0,0 +1,48 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/jinzhu/gorm"
+)
+
+func OidcGroupRoles(oidcConfig OidcConfig) GroupRoles {
+	return GroupRoles{
+		Admin:       oidcConfig.AdminGroups,
+		Editor:      oidcConfig.EditorGroups,
+		Contributor: oidcConfig.ContributorGroups,
+		Viewer:      oidcConfig.ViewerGroups,
+		Default:     oidcConfig.DefaultRole,
+
+		// Keycloak prefixes groups with a slash depending on the mapper
+		Matches: func(configured string, group string) bool {
+			return strings.TrimPrefix(group, "/") == strings.TrimPrefix(configured, "/")
+		},
+	}
+}
+
+// Find, link or create the user who logged in through the identity provider, see signInExternal
+func SignInWithOidc(db *gorm.DB, identity *auth.OidcIdentity, oidcConfig OidcConfig) (*User, error) {
+	return signInExternal(db, externalLogin{
+		column: "oidc_subject",
+		id:     identity.Subject,
+		email:  identity.Email,
+		emailVerified: oidcEmailVerified(identity, oidcConfig),
+		groups:        identity.Groups,
+		groupRoles:    OidcGroupRoles(oidcConfig),
+		autoCreate:    oidcConfig.AutoCreate,
+	})
+}
+
+func oidcEmailVerified(identity *auth.OidcIdentity, oidcConfig OidcConfig) bool {
+	/*
+		Some providers, e.g. Azure AD, don't send email_verified at all and let users
+		set their own address. Their addresses only count with OIDC_TRUST_UNVERIFIED_EMAIL.
+	*/
+	if identity.EmailVerified == nil {
+		return oidcConfig.TrustUnverifiedEmail
+	}
+	return *identity.EmailVerified
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	A link to set a new password, sent by email.
+	Only the hash of the token is stored, it works once and only until ExpiresAt.
+	Requesting a new link makes the older ones of the user stop working.
+*/
+type PasswordReset struct {
+	ID        uint32     `gorm:"primary_key;auto_increment" json:"id"`
+	UserID    uint32     `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"size:64;unique_index" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+var ErrInvalidPasswordReset = errors.New("This password reset link is invalid or has expired.")
+
+func RequestPasswordReset(db *gorm.DB, email string, ttl time.Duration) (string, error) {
+	user := User{}
+	_, err := user.FindUserByEmail(db, email)
+	if err != nil {
+		// Callers must not tell whether the email exists, see RequestPasswordReset in users_controller.go
+		return "", err
+	}
+
+	token, err := auth.NewRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	// Only the newest link works, expired ones of everybody are of no use anymore
+	err = db.Where("user_id = ? OR expires_at < ?", user.ID, time.Now()).Delete(&PasswordReset{}).Error
+	if err != nil {
+		return "", err
+	}
+	err = db.Create(&PasswordReset{
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedAt: time.Now(),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func ResetPassword(db *gorm.DB, token string, updatedPassword string) (*User, error, int) {
+	reset := PasswordReset{}
+	err := db.Where("token_hash = ?", auth.HashToken(token)).Take(&reset).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &User{}, ErrInvalidPasswordReset, http.StatusNotFound
+	}
+	if err != nil {
+		return &User{}, err, http.StatusInternalServerError
+	}
+	if reset.UsedAt != nil || reset.ExpiresAt.Before(time.Now()) {
+		return &User{}, ErrInvalidPasswordReset, http.StatusForbidden
+	}
+
+	// Mark it used first, so two requests with the same link can't both get through
+	result := db.Model(&PasswordReset{}).Where("id = ? AND used_at IS NULL", reset.ID).UpdateColumn("used_at", time.Now())
+	if result.Error != nil {
+		return &User{}, result.Error, http.StatusInternalServerError
+	}
+	if result.RowsAffected == 0 {
+		return &User{}, ErrInvalidPasswordReset, http.StatusForbidden
+	}
+
+	user := User{}
+	if _, err := user.FindUserByID(db, reset.UserID); err != nil {
+		return &User{}, ErrInvalidPasswordReset, http.StatusNotFound
+	}
+
+	user.Password = updatedPassword
+	if err := user.BeforeSave(); err != nil { /* This will hash the password */
+		return &User{}, err, http.StatusInternalServerError
+	}
+	err = db.Model(&User{}).Where("id = ?", user.ID).UpdateColumns(
+		map[string]interface{}{
+			"password":   user.Password,
+			"updated_at": time.Now(),
+		},
+	).Error
+	if err != nil {
+		return &User{}, err, http.StatusInternalServerError
+	}
+
+	// Whoever knew the old password shouldn't stay logged in or keep their API tokens
+	if err := RevokeUserSessions(db, user.ID, ""); err != nil {
+		return &User{}, err, http.StatusInternalServerError
+	}
+	if err := RevokeUserApiTokens(db, user.ID); err != nil {
+		return &User{}, err, http.StatusInternalServerError
+	}
+	return &user, nil, http.StatusOK
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/jinzhu/gorm"
+)
+
+func ProxyGroupRoles(proxyConfig ProxyAuthConfig) GroupRoles {
+	return GroupRoles{
+		Admin:       proxyConfig.AdminGroups,
+		Editor:      proxyConfig.EditorGroups,
+		Contributor: proxyConfig.ContributorGroups,
+		Viewer:      proxyConfig.ViewerGroups,
+		Default:     proxyConfig.DefaultRole,
+	}
+}
+
+// Find, link or create the user the reverse proxy authenticated, see signInExternal
+func SignInWithProxy(db *gorm.DB, identity *auth.ProxyIdentity, proxyConfig ProxyAuthConfig) (*User, error) {
+	return signInExternal(db, externalLogin{
+		column: "proxy_user",
+		id:     identity.User,
+		email:  identity.Email,
+		// Only trusted proxies get here, they are run by the admins
+		emailVerified: true,
+		groups:        identity.Groups,
+		groupRoles:    ProxyGroupRoles(proxyConfig),
+		autoCreate:    proxyConfig.AutoCreate,
+	})
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	Visitors may sign up themselves if REGISTRATION allows it.
+	Either way they have to confirm their email address first, see EmailConfirmation.go.
+	With "approval" their user is pending and can't log in until an admin approves it,
+	with "open" they can log in right after the confirmation. "closed" turns the registration off.
+*/
+const (
+	RegistrationClosed   = "closed"
+	RegistrationApproval = "approval"
+	RegistrationOpen     = "open"
+)
+
+var (
+	ErrAccountPending     = errors.New("your account is waiting for the approval of an admin")
+	ErrRegistrationClosed = errors.New("registration is not enabled")
+)
+
+// The registration mode of the config, unknown values count as closed
+func RegistrationMode(mode string) string {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case RegistrationApproval:
+		return RegistrationApproval
+	case RegistrationOpen:
+		return RegistrationOpen
+	default:
+		return RegistrationClosed
+	}
+}
+
+func Register(db *gorm.DB, email string, password string, role uint8, mode string) (*User, error) {
+	if mode == RegistrationClosed {
+		return &User{}, ErrRegistrationClosed
+	}
+
+	if err := dropExpiredRegistration(db, email); err != nil {
+		return &User{}, err
+	}
+
+	user := &User{Email: email, Password: password}
+	user.Prepare()
+	user.Role = role
+	user.Pending = mode == RegistrationApproval
+	user.Unconfirmed = true
+	if err := user.Validate(""); err != nil {
+		return &User{}, err
+	}
+	return user.SaveUser(db)
+}
+
+func FindPendingUsers(db *gorm.DB) ([]*User, error) {
+	users := []*User{}
+	// Admins only get to see them once the email address is confirmed
+	err := db.Where("pending = ? AND unconfirmed = ?", true, false).Order("created_at").Find(&users).Error
+	return users, err
+}
+
+func FindPendingUser(db *gorm.DB, uid uint32) (*User, error) {
+	user := &User{}
+	err := db.Where("id = ? AND pending = ?", uid, true).Take(user).Error
+	if err != nil {
+		return &User{}, err
+	}
+	return user, nil
+}
+
+func (u *User) Approve(db *gorm.DB, role uint8) error {
+	if _, ok := roleNames[role]; !ok {
+		return errors.New("unknown role")
+	}
+	err := db.Model(&User{}).Where("id = ?", u.ID).UpdateColumns(map[string]interface{}{
+		"pending": false,
+		"role":    role,
+	}).Error
+	if err != nil {
+		return err
+	}
+	u.Pending = false
+	u.Role = role
+	return nil
+}
+
+// A rejected registration is removed, so the email can register or be invited again
+func (u *User) Reject(db *gorm.DB) error {
+	_, err := u.DeleteAUser(db, u.ID)
+	return err
+}
+
+// Who gets told about new registrations
+func FindAdminEmails(db *gorm.DB) ([]string, error) {
+	var emails []string
+	err := db.Model(&User{}).Where("role = ? AND pending = ?", RoleAdmin, false).Pluck("email", &emails).Error
+	return emails, err
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	Roles are stored in User.Role. Admins keep 0 and former "normal" users 1,
+	so existing installations map onto admin and editor without a migration.
+*/
+const (
+	RoleAdmin       uint8 = 0
+	RoleEditor      uint8 = 1
+	RoleContributor uint8 = 2
+	RoleViewer      uint8 = 3
+)
+
+type Permission string
+
+const (
+	PermissionViewSheets    Permission = "sheets:view"    // Browse, search and download sheets
+	PermissionUploadSheets  Permission = "sheets:upload"  // Upload sheets and edit them
+	PermissionManageLibrary Permission = "library:manage" // Edit and delete any sheet, composer or tag
+	PermissionManageUsers   Permission = "users:manage"   // Create, edit and delete users
+)
+
+var roleNames = map[uint8]string{
+	RoleAdmin:       "admin",
+	RoleEditor:      "editor",
+	RoleContributor: "contributor",
+	RoleViewer:      "viewer",
+}
+
+var rolePermissions = map[uint8][]Permission{
+	RoleAdmin:       {PermissionViewSheets, PermissionUploadSheets, PermissionManageLibrary, PermissionManageUsers},
+	RoleEditor:      {PermissionViewSheets, PermissionUploadSheets, PermissionManageLibrary},
+	RoleContributor: {PermissionViewSheets, PermissionUploadSheets},
+	RoleViewer:      {PermissionViewSheets},
+}
+
+var ErrLastAdmin = errors.New("there has to be at least one admin left")
+
+func RoleName(role uint8) string {
+	return roleNames[role]
+}
+
+func ParseRole(name string) (uint8, error) {
+	for role, roleName := range roleNames {
+		if strings.EqualFold(strings.TrimSpace(name), roleName) {
+			return role, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %s, expected one of admin, editor, contributor or viewer", name)
+}
+
+func (u *User) Can(permission Permission) bool {
+	if u.apiToken != nil && !u.apiToken.Grants(permission) {
+		return false
+	}
+	for _, p := range rolePermissions[u.Role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+func (u *User) SetRole(db *gorm.DB, role uint8) error {
+	if _, ok := roleNames[role]; !ok {
+		return fmt.Errorf("unknown role %d", role)
+	}
+	if u.IsAdmin() && role != RoleAdmin {
+		if err := ensureOtherAdmin(db, u.ID); err != nil {
+			return err
+		}
+	}
+
+	u.Role = role
+	return db.Model(&User{}).Where("id = ?", u.ID).UpdateColumn("role", role).Error
+}
+
+func ensureOtherAdmin(db *gorm.DB, uid uint32) error {
+
+	// Demoting or deleting the last admin would lock everybody out of the user management
+	var admins int64
+	err := db.Model(&User{}).Where("role = ? AND id <> ?", RoleAdmin, uid).Count(&admins).Error
+	if err != nil {
+		return err
+	}
+	if admins == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+func EnsureAdmin(db *gorm.DB) error {
+	/*
+		Admin rights used to be bound to the user with the id ADMIN_UID.
+		Make sure that user is an admin as long as no one else is.
+	*/
+	var admins int64
+	err := db.Model(&User{}).Where("role = ?", RoleAdmin).Count(&admins).Error
+	if err != nil || admins > 0 {
+		return err
+	}
+	return db.Model(&User{}).Where("id = ?", ADMIN_UID).UpdateColumn("role", RoleAdmin).Error
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	A saved search is a named query owned by a user.
+	Its sheets are evaluated on every request, which makes it a smart collection
+	that updates itself whenever sheets are uploaded, tagged or deleted.
+*/
+type SavedSearch struct {
+	ID        uint32             `gorm:"primary_key;auto_increment" json:"id"`
+	Name      string             `gorm:"size:100;not null" json:"name"`
+	UserID    uint32             `gorm:"not null;index" json:"user_id"`
+	Shared    bool               `json:"shared"` // Visible to all users if true
+	Query     string             `gorm:"type:text" json:"-"`
+	Filters   SavedSearchFilters `gorm:"-" json:"filters"`
+	CreatedAt time.Time          `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time          `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+type SavedSearchFilters struct {
+	Text      string   `json:"text"`
+	Composers []string `json:"composers"`
+	Epochs    []string `json:"epochs"`
+	Tags      []string `json:"tags"`
+	TagMode   string   `json:"tag_mode"`
+}
+
+func (s *SavedSearch) Prepare() {
+	s.Name = strings.TrimSpace(s.Name)
+	s.Filters.Text = strings.TrimSpace(s.Filters.Text)
+	if s.Filters.TagMode == "" {
+		s.Filters.TagMode = "all"
+	}
+	s.UpdatedAt = time.Now()
+}
+
+func (s *SavedSearch) Validate() error {
+	if s.Name == "" {
+		return errors.New("Required Name")
+	}
+	if s.Filters.TagMode != "all" && s.Filters.TagMode != "any" {
+		return errors.New("tag_mode has to be either 'all' or 'any'")
+	}
+	return nil
+}
+
+// Filters are stored as JSON so the table works the same on every database driver
+func (s *SavedSearch) BeforeSave() error {
+	query, err := json.Marshal(s.Filters)
+	if err != nil {
+		return err
+	}
+	s.Query = string(query)
+	return nil
+}
+
+func (s *SavedSearch) AfterFind() error {
+	if s.Query == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(s.Query), &s.Filters)
+}
+
+func (s *SavedSearch) SheetQuery() SheetQuery {
+	return SheetQuery{
+		Text:        s.Filters.Text,
+		Composers:   s.Filters.Composers,
+		Epochs:      s.Filters.Epochs,
+		Tags:        s.Filters.Tags,
+		MatchAnyTag: s.Filters.TagMode == "any",
+	}
+}
+
+func (s *SavedSearch) SaveSavedSearch(db *gorm.DB) (*SavedSearch, error) {
+	s.CreatedAt = time.Now()
+	err := db.Create(&s).Error
+	if err != nil {
+		return &SavedSearch{}, err
+	}
+	return s, nil
+}
+
+func (s *SavedSearch) UpdateSavedSearch(db *gorm.DB) (*SavedSearch, error) {
+	err := db.Save(&s).Error
+	if err != nil {
+		return &SavedSearch{}, err
+	}
+	return s, nil
+}
+
+func (s *SavedSearch) DeleteSavedSearch(db *gorm.DB) error {
+	return db.Delete(&s).Error
+}
+
+func (s *SavedSearch) FindSavedSearchByID(db *gorm.DB, id uint32) (*SavedSearch, error) {
+	err := db.Model(&SavedSearch{}).Where("id = ?", id).Take(&s).Error
+	if err != nil {
+		return &SavedSearch{}, err
+	}
+	return s, nil
+}
+
+func FindSavedSearchesForUser(db *gorm.DB, uid uint32) ([]*SavedSearch, error) {
+
+	// Own saved searches and the ones other users have shared
+	var searches []*SavedSearch
+	err := db.Where("user_id = ? OR shared = ?", uid, true).Order("name asc").Find(&searches).Error
+	return searches, err
+}
+
+// Users are allowed to see their own and shared saved searches
+func (s *SavedSearch) VisibleTo(uid uint32) bool {
+	return s.Shared || s.UserID == uid
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/SheetAble/SheetAble/backend/api/auth"
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	A session is created on every login and lives as long as its refresh token.
+	The short-lived access tokens carry the session id, revoking the session
+	invalidates them as well.
+	Refresh tokens rotate on every use, presenting an already used one
+	revokes the whole session because the token must have been stolen.
+*/
+type Session struct {
+	ID                string     `gorm:"primary_key;size:36" json:"id"`
+	UserID            uint32     `gorm:"not null;index" json:"user_id"`
+	RefreshTokenHash  string     `gorm:"size:64;unique_index" json:"-"`
+	PreviousTokenHash string     `gorm:"size:64;index" json:"-"`
+	UserAgent         string     `json:"user_agent"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+	RevokedAt         *time.Time `json:"revoked_at"`
+	LastUsedAt        time.Time  `json:"last_used_at"`
+	CreatedAt         time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+var ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+func CreateSession(db *gorm.DB, uid uint32, userAgent string, ttl time.Duration) (*Session, string, error) {
+	refreshToken, err := auth.NewRefreshToken()
+	if err != nil {
+		return &Session{}, "", err
+	}
+
+	// Sessions nobody refreshed in time are of no use anymore
+	db.Where("user_id = ? AND expires_at < ?", uid, time.Now()).Delete(&Session{})
+
+	session := &Session{
+		ID:               uuid.NewString(),
+		UserID:           uid,
+		RefreshTokenHash: auth.HashToken(refreshToken),
+		UserAgent:        userAgent,
+		ExpiresAt:        time.Now().Add(ttl),
+		LastUsedAt:       time.Now(),
+		CreatedAt:        time.Now(),
+	}
+	if err := db.Create(session).Error; err != nil {
+		return &Session{}, "", err
+	}
+	return session, refreshToken, nil
+}
+
+func RotateSession(db *gorm.DB, refreshToken string, ttl time.Duration) (*Session, string, error) {
+	/*
+		Trade a refresh token for a new one, extending the session.
+		Returns ErrInvalidRefreshToken for unknown, expired, revoked or reused tokens.
+	*/
+	hash := auth.HashToken(refreshToken)
+
+	session := &Session{}
+	err := db.Where("refresh_token_hash = ?", hash).Take(session).Error
+	if gorm.IsRecordNotFoundError(err) {
+		// An old token showing up again means it got copied, end the session for everyone
+		if db.Where("previous_token_hash = ?", hash).Take(session).Error == nil {
+			session.Revoke(db)
+		}
+		return &Session{}, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return &Session{}, "", err
+	}
+	if session.RevokedAt != nil || session.ExpiresAt.Before(time.Now()) {
+		return &Session{}, "", ErrInvalidRefreshToken
+	}
+
+	newToken, err := auth.NewRefreshToken()
+	if err != nil {
+		return &Session{}, "", err
+	}
+	session.PreviousTokenHash = hash
+	session.RefreshTokenHash = auth.HashToken(newToken)
+	session.ExpiresAt = time.Now().Add(ttl)
+	session.LastUsedAt = time.Now()
+
+	// Only rotate if nobody else rotated the same token in the meantime
+	result := db.Model(&Session{}).
+		Where("id = ? AND refresh_token_hash = ?", session.ID, hash).
+		UpdateColumns(map[string]interface{}{
+			"previous_token_hash": session.PreviousTokenHash,
+			"refresh_token_hash":  session.RefreshTokenHash,
+			"expires_at":          session.ExpiresAt,
+			"last_used_at":        session.LastUsedAt,
+		})
+	if result.Error != nil {
+		return &Session{}, "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &Session{}, "", ErrInvalidRefreshToken
+	}
+	return session, newToken, nil
+}
+
+func (s *Session) Revoke(db *gorm.DB) error {
+	now := time.Now()
+	s.RevokedAt = &now
+	return db.Model(&Session{}).Where("id = ?", s.ID).UpdateColumn("revoked_at", now).Error
+}
+
+func RevokeSession(db *gorm.DB, sessionID string) error {
+	session := Session{ID: sessionID}
+	return session.Revoke(db)
+}
+
+func RevokeUserSessions(db *gorm.DB, uid uint32, exceptSessionID string) error {
+
+	// Log the user out everywhere, except for the session doing the change if given
+	return db.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL AND id <> ?", uid, exceptSessionID).
+		UpdateColumn("revoked_at", time.Now()).Error
+}
+
+func SessionActive(db *gorm.DB, sessionID string, uid uint32) (bool, error) {
+	var count int64
+	err := db.Model(&Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", sessionID, uid, time.Now()).
+		Count(&count).Error
+	return count > 0, err
+}
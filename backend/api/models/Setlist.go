@@ -0,0 +1,337 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	A setlist is an ordered, named collection of sheets, e.g. the program of a concert.
+	Each entry references a sheet and can narrow it down to a page range.
+*/
+type Setlist struct {
+	ID        uint32          `gorm:"primary_key;auto_increment" json:"id"`
+	Name      string          `gorm:"size:100;not null" json:"name"`
+	Date      time.Time       `json:"date"`
+	Venue     string          `json:"venue"`
+	Notes     string          `gorm:"type:text" json:"notes"`
+	OwnerID   uint32          `gorm:"not null;index" json:"owner_id"`
+	Entries   []*SetlistEntry `gorm:"-" json:"entries"`
+	CreatedAt time.Time       `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time       `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+type SetlistEntry struct {
+	ID            uint32 `gorm:"primary_key;auto_increment" json:"id"`
+	SetlistID     uint32 `gorm:"not null;index" json:"setlist_id"`
+	Position      int    `gorm:"not null" json:"position"`
+	SafeSheetName string `gorm:"not null;index" json:"safe_sheet_name"`
+	PageFrom      int    `json:"page_from"` // 0 means from the first page
+	PageTo        int    `json:"page_to"`   // 0 means up to the last page
+	Note          string `json:"note"`
+	Sheet         *Sheet `gorm:"-" json:"sheet,omitempty"`
+}
+
+func (s *Setlist) Prepare() {
+	s.Name = strings.TrimSpace(s.Name)
+	s.Venue = strings.TrimSpace(s.Venue)
+	s.Notes = strings.TrimSpace(s.Notes)
+	s.UpdatedAt = time.Now()
+}
+
+func (s *Setlist) Validate() error {
+	if s.Name == "" {
+		return errors.New("Required Name")
+	}
+	return nil
+}
+
+func (e *SetlistEntry) Validate() error {
+	if e.PageFrom < 0 || e.PageTo < 0 {
+		return errors.New("page numbers can't be negative")
+	}
+	if e.PageTo != 0 && e.PageFrom > e.PageTo {
+		return errors.New("page_from has to be before page_to")
+	}
+	return nil
+}
+
+func (s *Setlist) SaveSetlist(db *gorm.DB) (*Setlist, error) {
+	s.CreatedAt = time.Now()
+	err := db.Create(&s).Error
+	if err != nil {
+		return &Setlist{}, err
+	}
+	return s, nil
+}
+
+func (s *Setlist) UpdateSetlist(db *gorm.DB) (*Setlist, error) {
+	err := db.Save(&s).Error
+	if err != nil {
+		return &Setlist{}, err
+	}
+	return s, nil
+}
+
+func (s *Setlist) DeleteSetlist(db *gorm.DB) error {
+	tx := db.Begin()
+	if err := tx.Where("setlist_id = ?", s.ID).Delete(&SetlistEntry{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Delete(&s).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s *Setlist) FindSetlistByID(db *gorm.DB, id uint32) (*Setlist, error) {
+
+	// Get a setlist together with its entries and their sheets
+	err := db.Model(&Setlist{}).Where("id = ?", id).Take(&s).Error
+	if err != nil {
+		return &Setlist{}, err
+	}
+	err = s.LoadEntries(db)
+	if err != nil {
+		return &Setlist{}, err
+	}
+	return s, nil
+}
+
+func FindSetlistsByOwner(db *gorm.DB, uid uint32) ([]*Setlist, error) {
+	var setlists []*Setlist
+	err := db.Where("owner_id = ?", uid).Order("date desc").Find(&setlists).Error
+	return setlists, err
+}
+
+func (s *Setlist) LoadEntries(db *gorm.DB) error {
+	var entries []*SetlistEntry
+	err := db.Where("setlist_id = ?", s.ID).Order("position asc").Find(&entries).Error
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.SafeSheetName
+	}
+	var sheets []*Sheet
+	if len(names) > 0 {
+		err = db.Where("safe_sheet_name IN (?)", names).Find(&sheets).Error
+		if err != nil {
+			return err
+		}
+	}
+	bySheet := map[string]*Sheet{}
+	for _, sheet := range sheets {
+		bySheet[sheet.SafeSheetName] = sheet
+	}
+	for _, entry := range entries {
+		entry.Sheet = bySheet[entry.SafeSheetName]
+	}
+
+	s.Entries = entries
+	return nil
+}
+
+func (s *Setlist) AddEntry(db *gorm.DB, entry *SetlistEntry, position int) (*SetlistEntry, error) {
+	/*
+		Insert an entry at the given position (1 based).
+		A position of 0 or past the end appends the entry.
+	*/
+	var sheetModel Sheet
+	if _, err := sheetModel.FindSheetBySafeName(db, entry.SafeSheetName); err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return &SetlistEntry{}, errors.New("Sheet not found")
+		}
+		return &SetlistEntry{}, err
+	}
+
+	tx := db.Begin()
+	if err := s.LoadEntries(tx); err != nil {
+		tx.Rollback()
+		return &SetlistEntry{}, err
+	}
+
+	entry.ID = 0
+	entry.SetlistID = s.ID
+	entry.Position = len(s.Entries) + 1
+	if err := tx.Create(entry).Error; err != nil {
+		tx.Rollback()
+		return &SetlistEntry{}, err
+	}
+
+	s.Entries = append(s.Entries, entry)
+	if position > 0 && position < entry.Position {
+		if err := s.moveEntry(tx, entry.ID, position); err != nil {
+			tx.Rollback()
+			return &SetlistEntry{}, err
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return &SetlistEntry{}, err
+	}
+	return entry, nil
+}
+
+func (s *Setlist) UpdateEntry(db *gorm.DB, entryID uint32, pageFrom int, pageTo int, note string) (*SetlistEntry, error) {
+	entry := s.findEntry(entryID)
+	if entry == nil {
+		return &SetlistEntry{}, errors.New("Entry not found")
+	}
+
+	entry.PageFrom = pageFrom
+	entry.PageTo = pageTo
+	entry.Note = strings.TrimSpace(note)
+	if err := entry.Validate(); err != nil {
+		return &SetlistEntry{}, err
+	}
+	err := db.Model(entry).UpdateColumns(map[string]interface{}{
+		"page_from": entry.PageFrom,
+		"page_to":   entry.PageTo,
+		"note":      entry.Note,
+	}).Error
+	if err != nil {
+		return &SetlistEntry{}, err
+	}
+	return entry, nil
+}
+
+func (s *Setlist) DeleteEntry(db *gorm.DB, entryID uint32) error {
+	entry := s.findEntry(entryID)
+	if entry == nil {
+		return errors.New("Entry not found")
+	}
+
+	tx := db.Begin()
+	if err := tx.Delete(entry).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	remaining := []*SetlistEntry{}
+	for _, e := range s.Entries {
+		if e.ID != entryID {
+			remaining = append(remaining, e)
+		}
+	}
+	s.Entries = remaining
+	if err := s.savePositions(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s *Setlist) MoveEntry(db *gorm.DB, entryID uint32, position int) error {
+	tx := db.Begin()
+	if err := s.moveEntry(tx, entryID, position); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s *Setlist) Reorder(db *gorm.DB, entryIDs []uint32) error {
+	/*
+		Bring the entries into the given order.
+		The list has to contain every entry of the setlist exactly once.
+	*/
+	if len(entryIDs) != len(s.Entries) {
+		return errors.New("the new order has to contain every entry of the setlist")
+	}
+
+	ordered := make([]*SetlistEntry, 0, len(entryIDs))
+	seen := map[uint32]bool{}
+	for _, id := range entryIDs {
+		entry := s.findEntry(id)
+		if entry == nil || seen[id] {
+			return errors.New("the new order has to contain every entry of the setlist")
+		}
+		seen[id] = true
+		ordered = append(ordered, entry)
+	}
+	s.Entries = ordered
+
+	tx := db.Begin()
+	if err := s.savePositions(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s *Setlist) moveEntry(db *gorm.DB, entryID uint32, position int) error {
+	index := -1
+	for i, entry := range s.Entries {
+		if entry.ID == entryID {
+			index = i
+		}
+	}
+	if index == -1 {
+		return errors.New("Entry not found")
+	}
+	if position < 1 || position > len(s.Entries) {
+		return errors.New("position out of range")
+	}
+
+	entry := s.Entries[index]
+	entries := append(s.Entries[:index:index], s.Entries[index+1:]...)
+	entries = append(entries[:position-1], append([]*SetlistEntry{entry}, entries[position-1:]...)...)
+	s.Entries = entries
+	return s.savePositions(db)
+}
+
+func (s *Setlist) savePositions(db *gorm.DB) error {
+
+	// Number the entries 1..n in their current order
+	for i, entry := range s.Entries {
+		if entry.Position == i+1 {
+			continue
+		}
+		entry.Position = i + 1
+		if err := db.Model(entry).Update("position", entry.Position).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Setlist) findEntry(entryID uint32) *SetlistEntry {
+	for _, entry := range s.Entries {
+		if entry.ID == entryID {
+			return entry
+		}
+	}
+	return nil
+}
+
+func DeleteSetlistEntriesOfSheet(db *gorm.DB, sheetName string) error {
+	/*
+		Remove a deleted sheet from every setlist and close the gaps it leaves.
+	*/
+	var setlistIDs []uint32
+	err := db.Model(&SetlistEntry{}).Where("safe_sheet_name = ?", sheetName).Pluck("DISTINCT setlist_id", &setlistIDs).Error
+	if err != nil {
+		return err
+	}
+	err = db.Where("safe_sheet_name = ?", sheetName).Delete(&SetlistEntry{}).Error
+	if err != nil {
+		return err
+	}
+
+	for _, id := range setlistIDs {
+		setlist := Setlist{ID: id}
+		if err := setlist.LoadEntries(db); err != nil {
+			return err
+		}
+		if err := setlist.savePositions(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,12 +5,12 @@ import (
 	"log"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
 	. "github.com/SheetAble/SheetAble/backend/api/config"
 	"github.com/SheetAble/SheetAble/backend/api/utils"
-	"github.com/lib/pq"
 
 	"github.com/jinzhu/gorm"
 )
@@ -21,12 +21,21 @@ type Sheet struct {
 	SafeComposer    string `json:"safe_composer"`
 	Composer        string `json:"composer"`
 	ReleaseDate     time.Time
-	PdfUrl          string         `json:"pdf_url"`
-	UploaderID      uint32         `gorm:"not null" json:"uploader_id"`
-	CreatedAt       time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt       time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
-	Tags            pq.StringArray `gorm:"type:text[]" json:"tags"`
-	InformationText string         `json:"information_text"`
+	PdfUrl          string    `json:"pdf_url"`
+	UploaderID      uint32    `gorm:"not null" json:"uploader_id"`
+	CreatedAt       time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt       time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	Tags            []string  `gorm:"-" json:"tags"` // Loaded from the sheet_tags table, see LoadSheetTags
+	InformationText string    `json:"information_text"`
+	Category        string    `json:"category"`
+	Visibility      string    `gorm:"size:20;not null;default:'everyone'" json:"visibility"` // private, group or everyone, see Visibility.go
+	GroupID         uint32    `gorm:"not null;default:0" json:"group_id"`
+	SearchName      string    `gorm:"index" json:"-"` // Normalized sheet name, lets searches narrow down the candidates in the database
+}
+
+func (s *Sheet) BeforeSave() error {
+	s.SearchName = utils.NormalizeSearchString(s.SheetName)
+	return nil
 }
 
 func (s *Sheet) Prepare() {
@@ -37,7 +46,10 @@ func (s *Sheet) Prepare() {
 	s.CreatedAt = time.Now()
 	s.UpdatedAt = time.Now()
 	s.PdfUrl = "sheet/pdf/" + s.SafeComposer + "/" + s.SafeSheetName
-	s.Tags = pq.StringArray{}
+	s.Tags = []string{}
+	if s.Visibility == "" {
+		s.Visibility = VisibilityEveryone
+	}
 }
 
 func (s *Sheet) SaveSheet(db *gorm.DB) (*Sheet, error) {
@@ -74,6 +86,8 @@ func (s *Sheet) DeleteSheet(db *gorm.DB, sheetName string) (int64, error) {
 		CheckAndDeleteUnknownComposer(db)
 	}
 
+	db.Where("safe_sheet_name = ?", sheetName).Delete(&SheetTag{})
+
 	db = db.Model(&Sheet{}).Where("safe_sheet_name = ?", sheetName).Take(&Sheet{}).Delete(&Sheet{})
 
 	if db.Error != nil {
@@ -85,6 +99,18 @@ func (s *Sheet) DeleteSheet(db *gorm.DB, sheetName string) (int64, error) {
 	return db.RowsAffected, nil
 }
 
+/*
+	Remove a sheet from setlists, favorites and views.
+	DeleteSheet leaves them alone since updating a sheet deletes and uploads it again,
+	call this only when the sheet is really gone.
+*/
+func DeleteSheetReferences(db *gorm.DB, sheetName string) error {
+	if err := DeleteSetlistEntriesOfSheet(db, sheetName); err != nil {
+		return err
+	}
+	return deleteUserDataOfSheet(db, sheetName)
+}
+
 func (s *Sheet) GetAllSheets(db *gorm.DB) (*[]Sheet, error) {
 	/*
 		This method will return max 20 sheets, to find more or specific one you need to specify it.
@@ -98,6 +124,12 @@ func (s *Sheet) GetAllSheets(db *gorm.DB) (*[]Sheet, error) {
 	if err != nil {
 		return &[]Sheet{}, err
 	}
+
+	sheetPointers := make([]*Sheet, len(sheets))
+	for i := range sheets {
+		sheetPointers[i] = &sheets[i]
+	}
+	err = LoadSheetTags(db, sheetPointers)
 	return &sheets, err
 }
 
@@ -107,6 +139,10 @@ func (s *Sheet) FindSheetBySafeName(db *gorm.DB, sheetName string) (*Sheet, erro
 	var err error
 	err = db.Model(&Sheet{}).Where("safe_sheet_name = ?", sheetName).Take(&s).Error
 
+	if err != nil {
+		return &Sheet{}, err
+	}
+	err = LoadSheetTags(db, []*Sheet{s})
 	if err != nil {
 		return &Sheet{}, err
 	}
@@ -114,29 +150,130 @@ func (s *Sheet) FindSheetBySafeName(db *gorm.DB, sheetName string) (*Sheet, erro
 
 }
 
-func (s *Sheet) List(db *gorm.DB, pagination Pagination, composer string) (*Pagination, error) {
-
-	// For pagination
+func (s *Sheet) List(db *gorm.DB, pagination Pagination, composer string, viewer *User) (*Pagination, error) {
 
+	// For pagination, only the sheets the viewer may see are listed and counted
 	var sheets []*Sheet
+	query := db.Scopes(VisibleSheets(viewer))
 	if composer != "" {
-		db.Scopes(ComposerEqual(composer), paginate(sheets, &pagination, db)).Find(&sheets)
-	} else {
-		db.Scopes(paginate(sheets, &pagination, db)).Find(&sheets)
+		query = query.Scopes(ComposerEqual(composer))
 	}
+	query.Scopes(paginate(sheets, &pagination, query)).Find(&sheets)
 
+	if err := LoadSheetTags(db, sheets); err != nil {
+		return nil, err
+	}
 	pagination.Rows = sheets
 
 	return &pagination, nil
 }
 
-func SearchSheet(db *gorm.DB, searchValue string) []*Sheet {
+func SearchSheet(db *gorm.DB, searchValue string, viewer *User) ([]*Sheet, error) {
+	/*
+		Search for sheets whose name matches the search value.
+		Matching ignores accents and casing and tolerates small typos,
+		the best matches are returned first.
+	*/
+	var candidates []*Sheet
+	err := db.Scopes(VisibleSheets(viewer), FuzzyCandidates("sheets.search_name", searchValue)).Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var sheets []*Sheet
+	scores := map[*Sheet]float64{}
+	for _, sheet := range candidates {
+		score := utils.FuzzyScore(searchValue, sheet.SheetName)
+		if score >= utils.FuzzyMatchThreshold {
+			scores[sheet] = score
+			sheets = append(sheets, sheet)
+		}
+	}
+
+	sort.SliceStable(sheets, func(i, j int) bool {
+		return scores[sheets[i]] > scores[sheets[j]]
+	})
+	if err := LoadSheetTags(db, sheets); err != nil {
+		return nil, err
+	}
+	return sheets, nil
+}
+
+func SuggestSearchTerm(db *gorm.DB, searchValue string, viewer *User) (string, error) {
+	/*
+		Find the sheet or composer name closest to the search value.
+		Used as a "did you mean" hint when a search returns nothing.
+	*/
+	var names []string
+	err := db.Model(&Sheet{}).Scopes(VisibleSheets(viewer), FuzzyCandidates("sheets.search_name", searchValue)).Pluck("sheet_name", &names).Error
+	if err != nil {
+		return "", err
+	}
+
+	var composerNames []string
+	err = db.Model(&Composer{}).Scopes(VisibleComposers(viewer), FuzzyCandidates("composers.search_name", searchValue)).Pluck("name", &composerNames).Error
+	if err != nil {
+		return "", err
+	}
+	names = append(names, composerNames...)
+
+	suggestion := ""
+	bestScore := utils.FuzzySuggestThreshold
+	for _, name := range names {
+		score := utils.FuzzyScore(searchValue, name)
+		if score > bestScore {
+			bestScore = score
+			suggestion = name
+		}
+	}
+	return suggestion, nil
+}
+
+func FuzzyCandidates(column string, searchValue string) func(db *gorm.DB) *gorm.DB {
+
+	// Scope to the rows whose normalized name can fuzzy match the search value, see utils.FuzzyFragments
+	return func(db *gorm.DB) *gorm.DB {
+		fragments := utils.FuzzyFragments(searchValue)
+		if len(fragments) == 0 {
+			return db.Where("1 = 0")
+		}
+		conditions := make([]string, len(fragments))
+		args := make([]interface{}, len(fragments))
+		for i, fragment := range fragments {
+			conditions[i] = column + " LIKE ?"
+			args[i] = "%" + fragment + "%"
+		}
+		return db.Where(strings.Join(conditions, " OR "), args...)
+	}
+}
+
+func FillSearchNames(db *gorm.DB) error {
 
-	// Search for sheets with containing string
+	// Normalize the names of rows stored before the search name columns existed
 	var sheets []*Sheet
-	searchValue = "%" + searchValue + "%"
-	db.Where("sheet_name LIKE ?", searchValue).Find(&sheets)
-	return sheets
+	if err := db.Where("search_name = '' OR search_name IS NULL").Find(&sheets).Error; err != nil {
+		return err
+	}
+	for _, sheet := range sheets {
+		err := db.Model(&Sheet{}).Where("safe_sheet_name = ?", sheet.SafeSheetName).
+			UpdateColumn("search_name", utils.NormalizeSearchString(sheet.SheetName)).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	var composers []*Composer
+	if err := db.Where("search_name = '' OR search_name IS NULL").Find(&composers).Error; err != nil {
+		return err
+	}
+	for _, composer := range composers {
+		err := db.Model(&Composer{}).Where("safe_name = ?", composer.SafeName).
+			UpdateColumn("search_name", utils.NormalizeSearchString(composer.Name)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func ComposerEqual(composer string) func(db *gorm.DB) *gorm.DB {
@@ -147,12 +284,23 @@ func ComposerEqual(composer string) func(db *gorm.DB) *gorm.DB {
 	}
 }
 
-func (s *Sheet) AppendTag(db *gorm.DB, appendTag string) {
+func (s *Sheet) AppendTag(db *gorm.DB, appendTag string) error {
+
+	// Append a new tag to a sheet, the tag gets created if it doesn't exist yet
+	tag, err := FindOrCreateTag(db, appendTag)
+	if err != nil {
+		return err
+	}
 
-	// Append a new tag to a sheet
-	newArray := append(s.Tags, appendTag)
+	err = db.Where(SheetTag{SafeSheetName: s.SafeSheetName, TagID: tag.ID}).FirstOrCreate(&SheetTag{}).Error
+	if err != nil {
+		return err
+	}
 
-	db.Model(&s).Update(Sheet{Tags: newArray})
+	if !utils.CheckSliceContains(s.Tags, tag.Name) {
+		s.Tags = append(s.Tags, tag.Name)
+	}
+	return nil
 }
 
 func (s *Sheet) DelteTag(db *gorm.DB, value string) bool {
@@ -164,9 +312,13 @@ func (s *Sheet) DelteTag(db *gorm.DB, value string) bool {
 		return false
 	}
 
-	newArray := pq.StringArray(utils.RemoveElementOfSlice(s.Tags, index))
+	tag := Tag{}
+	if _, err := tag.FindTagByName(db, value); err != nil {
+		return false
+	}
+	db.Where("safe_sheet_name = ? AND tag_id = ?", s.SafeSheetName, tag.ID).Delete(&SheetTag{})
 
-	db.Model(&s).Update(Sheet{Tags: newArray})
+	s.Tags = utils.RemoveElementOfSlice(s.Tags, index)
 
 	return true
 }
@@ -178,18 +330,54 @@ func (S *Sheet) UpdateSheetInformationText(db *gorm.DB, value string, sheet *She
 	return sheet
 }
 
-func FindSheetByTag(db *gorm.DB, tag string) []*Sheet {
+func FindSheetByTag(db *gorm.DB, tag string, includeDescendants bool, viewer *User) []*Sheet {
 
-	var allSheets []*Sheet
+	// All sheets having the given tag, or any tag nested below it if includeDescendants is set
 	var affectedSheets []*Sheet
 
-	db.Find(&allSheets)
-
-	for _, sheet := range allSheets {
-		if utils.CheckSliceContains(sheet.Tags, tag) {
-			affectedSheets = append(affectedSheets, sheet)
+	tagIDs := []uint32{}
+	if includeDescendants {
+		tagIDs, _ = TagWithDescendantIDs(db, tag)
+	} else {
+		var tagModel Tag
+		if found, err := tagModel.FindTagByName(db, tag); err == nil {
+			tagIDs = append(tagIDs, found.ID)
 		}
 	}
+	if len(tagIDs) == 0 {
+		return affectedSheets
+	}
+
+	var sheetNames []string
+	db.Model(&SheetTag{}).Where("tag_id IN (?)", tagIDs).Pluck("DISTINCT safe_sheet_name", &sheetNames)
+	if len(sheetNames) == 0 {
+		return affectedSheets
+	}
+
+	db.Scopes(VisibleSheets(viewer)).Where("safe_sheet_name IN (?)", sheetNames).Find(&affectedSheets)
 
+	LoadSheetTags(db, affectedSheets)
 	return affectedSheets
 }
+
+func (s *Sheet) CanBeModifiedBy(user *User) bool {
+
+	// Only the uploader or users allowed to manage the whole library may change or delete a sheet
+	return s.UploaderID == user.ID || user.Can(PermissionManageLibrary)
+}
+
+func (s *Sheet) TransferOwnership(db *gorm.DB, uid uint32) (*Sheet, error) {
+	var userModel User
+	if _, err := userModel.FindUserByID(db, uid); err != nil {
+		return &Sheet{}, err
+	}
+
+	err := db.Model(s).UpdateColumns(map[string]interface{}{
+		"uploader_id": uid,
+		"updated_at":  time.Now(),
+	}).Error
+	if err != nil {
+		return &Sheet{}, err
+	}
+	return s, nil
+}
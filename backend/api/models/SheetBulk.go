@@ -0,0 +1,223 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	. "github.com/SheetAble/SheetAble/backend/api/config"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	BulkAddTags     = "add_tags"
+	BulkRemoveTags  = "remove_tags"
+	BulkSetComposer = "set_composer"
+	BulkSetCategory = "set_category"
+	BulkDelete      = "delete"
+	BulkExport      = "export"
+)
+
+type BulkAction struct {
+	Action   string
+	Sheets   []string // Safe sheet names
+	Tags     []string
+	Composer string // Safe name of the composer for set_composer
+	Category string
+}
+
+type BulkResult struct {
+	Sheet   string `json:"sheet"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+
+	// Audit snapshots of the sheet around the action, After is nil once it got deleted
+	Before map[string]interface{} `json:"-"`
+	After  map[string]interface{} `json:"-"`
+}
+
+// File system changes which can only be done once the transaction went through
+type bulkFileOperation func() error
+
+func (a *BulkAction) Run(db *gorm.DB) ([]BulkResult, []*Sheet, bool) {
+	/*
+		Apply the action to every sheet inside one transaction.
+		If a single sheet fails, nothing is changed at all. The results tell which sheets failed.
+		Returns the per-sheet results, the affected sheets and whether everything succeeded.
+	*/
+	results := make([]BulkResult, len(a.Sheets))
+	var sheets []*Sheet
+	var fileOperations []bulkFileOperation
+	success := true
+	leftUnknown := false // A sheet moved away from the unknown composer, which might be unused now
+
+	tx := db.Begin()
+
+	var composer *Composer
+	if a.Action == BulkSetComposer {
+		var composerModel Composer
+		found, err := composerModel.FindComposerBySafeName(tx, a.Composer)
+		if err != nil {
+			tx.Rollback()
+			for i, sheetName := range a.Sheets {
+				results[i] = BulkResult{Sheet: sheetName, Error: "composer not found"}
+			}
+			return results, nil, false
+		}
+		composer = found
+	}
+
+	for i, sheetName := range a.Sheets {
+		results[i] = BulkResult{Sheet: sheetName, Success: true}
+
+		var sheetModel Sheet
+		sheet, err := sheetModel.FindSheetBySafeName(tx, sheetName)
+		if err == nil {
+			results[i].Before = AuditSnapshot(sheet)
+			if sheet.SafeComposer == "unknown" && (a.Action == BulkDelete || (composer != nil && composer.SafeName != "unknown")) {
+				leftUnknown = true
+			}
+
+			var operation bulkFileOperation
+			operation, err = a.apply(tx, sheet, composer)
+			if operation != nil {
+				fileOperations = append(fileOperations, operation)
+			}
+		} else if gorm.IsRecordNotFoundError(err) {
+			err = errors.New("Sheet not found")
+		}
+
+		if err != nil {
+			results[i].Success = false
+			results[i].Error = err.Error()
+			success = false
+			continue
+		}
+		if a.Action != BulkDelete {
+			results[i].After = AuditSnapshot(sheet)
+		}
+		sheets = append(sheets, sheet)
+	}
+
+	if !success {
+		tx.Rollback()
+		for i := range results {
+			if results[i].Success {
+				results[i].Success = false
+				results[i].Error = "rolled back because another sheet failed"
+			}
+		}
+		return results, nil, false
+	}
+	if err := tx.Commit().Error; err != nil {
+		for i := range results {
+			results[i].Success = false
+			results[i].Error = err.Error()
+		}
+		return results, nil, false
+	}
+
+	for _, operation := range fileOperations {
+		if err := operation(); err != nil {
+			log.Printf("bulk %s: %s\n", a.Action, err.Error())
+		}
+	}
+	if leftUnknown {
+		if err := DeleteUnusedUnknownComposer(db); err != nil {
+			log.Printf("bulk %s: %s\n", a.Action, err.Error())
+		}
+	}
+	return results, sheets, true
+}
+
+func (a *BulkAction) apply(db *gorm.DB, sheet *Sheet, composer *Composer) (bulkFileOperation, error) {
+	switch a.Action {
+	case BulkAddTags:
+		for _, tag := range a.Tags {
+			if err := sheet.AppendTag(db, tag); err != nil {
+				return nil, err
+			}
+		}
+	case BulkRemoveTags:
+		for _, tag := range a.Tags {
+			sheet.DelteTag(db, NormalizeTagPath(tag))
+		}
+	case BulkSetCategory:
+		return nil, db.Model(sheet).UpdateColumns(map[string]interface{}{
+			"category":   strings.TrimSpace(a.Category),
+			"updated_at": time.Now(),
+		}).Error
+	case BulkSetComposer:
+		return sheet.setComposer(db, composer)
+	case BulkDelete:
+		return sheet.deleteRows(db)
+	case BulkExport:
+		// Read only, the caller packs the files of the returned sheets
+	default:
+		return nil, fmt.Errorf("unknown action %s", a.Action)
+	}
+	return nil, nil
+}
+
+func (s *Sheet) setComposer(db *gorm.DB, composer *Composer) (bulkFileOperation, error) {
+	if s.SafeComposer == composer.SafeName {
+		return nil, nil
+	}
+
+	uploadPath := path.Join(Config().ConfigPath, "sheets/uploaded-sheets")
+	oldPath := path.Join(uploadPath, s.SafeComposer, s.SafeSheetName+".pdf")
+	newPath := path.Join(uploadPath, composer.SafeName, s.SafeSheetName+".pdf")
+	if _, err := os.Stat(newPath); err == nil {
+		return nil, errors.New("the composer already has a sheet with this name")
+	}
+
+	err := db.Model(s).UpdateColumns(map[string]interface{}{
+		"composer":      composer.Name,
+		"safe_composer": composer.SafeName,
+		"pdf_url":       "sheet/pdf/" + composer.SafeName + "/" + s.SafeSheetName,
+		"updated_at":    time.Now(),
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		err := os.MkdirAll(path.Dir(newPath), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		return os.Rename(oldPath, newPath)
+	}, nil
+}
+
+func (s *Sheet) deleteRows(db *gorm.DB) (bulkFileOperation, error) {
+	err := db.Where("safe_sheet_name = ?", s.SafeSheetName).Delete(&SheetTag{}).Error
+	if err != nil {
+		return nil, err
+	}
+	err = DeleteSheetReferences(db, s.SafeSheetName)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Where("safe_sheet_name = ?", s.SafeSheetName).Delete(&Sheet{}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{
+		path.Join(Config().ConfigPath, "sheets/uploaded-sheets", s.SafeComposer, s.SafeSheetName+".pdf"),
+		path.Join(Config().ConfigPath, "sheets/thumbnails", s.SafeSheetName+".png"),
+	}
+	return func() error {
+		for _, p := range paths {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
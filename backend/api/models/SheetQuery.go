@@ -0,0 +1,212 @@
+package models
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/jinzhu/gorm"
+)
+
+type SheetQuery struct {
+	Text           string
+	Composers      []string
+	Epochs         []string
+	Tags           []string
+	MatchAnyTag    bool
+	ReleasedAfter  time.Time
+	ReleasedBefore time.Time
+	UploaderID     uint32
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	HasAttachments *bool // Material next to the PDF, for now the information text
+	Viewer         *User // Only the sheets this user may see are found
+}
+
+type SheetFacets struct {
+	Composers map[string]int64 `json:"composers"`
+	Epochs    map[string]int64 `json:"epochs"`
+	Tags      map[string]int64 `json:"tags"`
+	Uploaders map[string]int64 `json:"uploaders"`
+}
+
+type SheetQueryResult struct {
+	Pagination
+	Facets SheetFacets `json:"facets"`
+}
+
+// Facet dimensions, a sheet has to match every dimension except the counted one
+const (
+	facetComposer = iota
+	facetEpoch
+	facetTag
+	facetUploader
+	facetCount
+)
+
+func (q *SheetQuery) Run(db *gorm.DB, pagination Pagination) (*SheetQueryResult, error) {
+	/*
+		Filter the sheets by the query and return one page of them.
+		Next to the rows the result contains facet counts for every dimension,
+		each counted as if the filter of that very dimension wasn't set.
+		That way the UI can show how many hits selecting another chip would give.
+		Filtering, paging and counting all happen in the database.
+	*/
+
+	// Callers which didn't validate the form get the first page
+	if pagination.Page < 1 {
+		pagination.Page = 1
+	}
+	if pagination.Limit < 1 {
+		pagination.Limit = 10
+	}
+
+	result := &SheetQueryResult{
+		Facets: SheetFacets{
+			Composers: map[string]int64{},
+			Epochs:    map[string]int64{},
+			Tags:      map[string]int64{},
+			Uploaders: map[string]int64{},
+		},
+	}
+
+	var totalRows int64
+	err := db.Model(&Sheet{}).Scopes(q.filterScope(facetCount)).Count(&totalRows).Error
+	if err != nil {
+		return nil, err
+	}
+	var sheets []*Sheet
+	err = db.Scopes(q.filterScope(facetCount)).
+		Order(pagination.GetSort()).Offset(pagination.GetOffset()).Limit(pagination.GetLimit()).
+		Find(&sheets).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := LoadSheetTags(db, sheets); err != nil {
+		return nil, err
+	}
+	result.Pagination = pagination
+	result.TotalRows = totalRows
+	result.TotalPages = int(math.Ceil(float64(totalRows) / float64(pagination.GetLimit())))
+	result.Rows = sheets
+
+	facets := []struct {
+		dimension int
+		column    string
+		joins     string
+		counts    map[string]int64
+	}{
+		{facetComposer, "sheets.safe_composer", "", result.Facets.Composers},
+		{facetEpoch, "COALESCE(composers.epoch, '')", "LEFT JOIN composers ON composers.safe_name = sheets.safe_composer", result.Facets.Epochs},
+		{facetTag, "tags.name", "JOIN sheet_tags ON sheet_tags.safe_sheet_name = sheets.safe_sheet_name JOIN tags ON tags.id = sheet_tags.tag_id", result.Facets.Tags},
+		{facetUploader, "sheets.uploader_id", "", result.Facets.Uploaders},
+	}
+	for _, facet := range facets {
+		query := db.Table("sheets").Scopes(q.filterScope(facet.dimension))
+		if facet.joins != "" {
+			query = query.Joins(facet.joins)
+		}
+		err := countGroups(query, facet.column, facet.counts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func countGroups(query *gorm.DB, column string, counts map[string]int64) error {
+
+	// Number of distinct sheets per value of the column
+	rows, err := query.Select(column + ", COUNT(DISTINCT sheets.safe_sheet_name)").Group(column).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value string
+		var count int64
+		if err := rows.Scan(&value, &count); err != nil {
+			return err
+		}
+		counts[value] = count
+	}
+	return rows.Err()
+}
+
+func (q *SheetQuery) filterScope(skip int) func(db *gorm.DB) *gorm.DB {
+	/*
+		Scope of the sheets matching the query, ignoring the filter of the skipped dimension.
+		Pass facetCount to apply all of them.
+	*/
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Scopes(VisibleSheets(q.Viewer), q.dateScope, q.textScope)
+
+		if q.HasAttachments != nil && *q.HasAttachments {
+			db = db.Where("sheets.information_text <> ''")
+		}
+		if q.HasAttachments != nil && !*q.HasAttachments {
+			db = db.Where("(sheets.information_text = '' OR sheets.information_text IS NULL)")
+		}
+
+		if skip != facetComposer && len(q.Composers) > 0 {
+			db = db.Where("sheets.safe_composer IN (?)", q.Composers)
+		}
+		if skip != facetEpoch && len(q.Epochs) > 0 {
+			// Sheets whose composer doesn't exist have no epoch
+			condition := "sheets.safe_composer IN (SELECT safe_name FROM composers WHERE epoch IN (?))"
+			if utils.CheckSliceContains(q.Epochs, "") {
+				condition = "(" + condition + " OR sheets.safe_composer NOT IN (SELECT safe_name FROM composers))"
+			}
+			db = db.Where(condition, q.Epochs)
+		}
+		if skip != facetTag && len(q.Tags) > 0 {
+			tags := utils.RemoveDuplicates(q.Tags)
+			if q.MatchAnyTag {
+				db = db.Where("sheets.safe_sheet_name IN (SELECT sheet_tags.safe_sheet_name FROM sheet_tags JOIN tags ON tags.id = sheet_tags.tag_id WHERE tags.name IN (?))", tags)
+			} else {
+				db = db.Where("sheets.safe_sheet_name IN (SELECT sheet_tags.safe_sheet_name FROM sheet_tags JOIN tags ON tags.id = sheet_tags.tag_id WHERE tags.name IN (?) GROUP BY sheet_tags.safe_sheet_name HAVING COUNT(DISTINCT tags.id) = ?)", tags, len(tags))
+			}
+		}
+		if skip != facetUploader && q.UploaderID != 0 {
+			db = db.Where("sheets.uploader_id = ?", q.UploaderID)
+		}
+		return db
+	}
+}
+
+func (q *SheetQuery) textScope(db *gorm.DB) *gorm.DB {
+
+	// Every word of the text has to appear in the sheet name, accents and casing are ignored
+	if q.Text == "" {
+		return db
+	}
+	words := strings.Fields(utils.NormalizeSearchString(q.Text))
+	if len(words) == 0 {
+		return db.Where("1 = 0")
+	}
+	for _, word := range words {
+		db = db.Where("sheets.search_name LIKE ?", "%"+word+"%")
+	}
+	return db
+}
+
+func (q *SheetQuery) dateScope(db *gorm.DB) *gorm.DB {
+
+	// Date ranges can be filtered by the database directly
+	if !q.ReleasedAfter.IsZero() {
+		db = db.Where("sheets.release_date >= ?", q.ReleasedAfter)
+	}
+	if !q.ReleasedBefore.IsZero() {
+		db = db.Where("sheets.release_date <= ?", q.ReleasedBefore)
+	}
+	if !q.CreatedAfter.IsZero() {
+		db = db.Where("sheets.created_at >= ?", q.CreatedAfter)
+	}
+	if !q.CreatedBefore.IsZero() {
+		db = db.Where("sheets.created_at <= ?", q.CreatedBefore)
+	}
+	return db
+}
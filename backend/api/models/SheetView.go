@@ -0,0 +1,64 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// When a user last opened a sheet and how often
+type SheetView struct {
+	UserID        uint32    `gorm:"primary_key;auto_increment:false" json:"user_id"`
+	SafeSheetName string    `gorm:"primary_key;size:255;index" json:"safe_sheet_name"`
+	ViewedAt      time.Time `gorm:"index" json:"viewed_at"`
+	ViewCount     int       `json:"view_count"`
+}
+
+func RecordSheetView(db *gorm.DB, uid uint32, sheetName string) error {
+	now := time.Now()
+	increment := func() (int64, error) {
+		result := db.Model(&SheetView{}).
+			Where("user_id = ? AND safe_sheet_name = ?", uid, sheetName).
+			UpdateColumns(map[string]interface{}{
+				"viewed_at":  now,
+				"view_count": gorm.Expr("view_count + 1"),
+			})
+		return result.RowsAffected, result.Error
+	}
+	updated, err := increment()
+	if err != nil || updated > 0 {
+		return err
+	}
+
+	// First view, only create the row for sheets that actually exist
+	var sheetModel Sheet
+	if _, err := sheetModel.FindSheetBySafeName(db, sheetName); err != nil {
+		return err
+	}
+
+	// If a parallel request created the row first the insert fails and its view gets counted
+	if err := db.Create(&SheetView{UserID: uid, SafeSheetName: sheetName, ViewedAt: now, ViewCount: 1}).Error; err == nil {
+		return nil
+	}
+	updated, err = increment()
+	if err == nil && updated == 0 {
+		return errors.New("unable to count the view of " + sheetName)
+	}
+	return err
+}
+
+func RecentSheets(db *gorm.DB, user *User, pagination Pagination) (*Pagination, error) {
+
+	// The sheets a user opened, the most recent first
+	return pageOfUserSheets(db, "sheet_views", user, "sheet_views.viewed_at desc", pagination)
+}
+
+func deleteUserDataOfSheet(db *gorm.DB, sheetName string) error {
+
+	// Favorites and views would otherwise point to a sheet which doesn't exist anymore
+	if err := db.Where("safe_sheet_name = ?", sheetName).Delete(&Favorite{}).Error; err != nil {
+		return err
+	}
+	return db.Where("safe_sheet_name = ?", sheetName).Delete(&SheetView{}).Error
+}
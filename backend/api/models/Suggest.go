@@ -0,0 +1,318 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/SheetAble/SheetAble/backend/api/utils"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	In-memory prefix index used for search-as-you-type.
+	Every word of a name is indexed, so "chop" finds "Frédéric Chopin".
+	The index is marked stale by gorm callbacks whenever sheets, composers, tags
+	or group memberships change and lazily rebuilt on the next lookup.
+	It also keeps who may see what, so lookups don't need the database.
+*/
+
+type SheetSuggestion struct {
+	SafeSheetName string `json:"safe_sheet_name"`
+	SheetName     string `json:"sheet_name"`
+	SafeComposer  string `json:"safe_composer"`
+}
+
+type ComposerSuggestion struct {
+	SafeName string `json:"safe_name"`
+	Name     string `json:"name"`
+}
+
+type Suggestions struct {
+	Sheets    []SheetSuggestion    `json:"sheets,omitempty"`
+	Composers []ComposerSuggestion `json:"composers,omitempty"`
+	Tags      []string             `json:"tags,omitempty"`
+}
+
+type suggestEntry struct {
+	key  string // normalized name starting at one of its words
+	name string // normalized full name, used for ranking
+	item int    // index into the item list of the type
+}
+
+// Who may see a sheet or composer, see Visibility.go
+type suggestAccess struct {
+	owner      uint32
+	visibility string
+	groupID    uint32
+}
+
+type suggestIndex struct {
+	sync.RWMutex
+	stale      bool
+	generation int // bumped on every invalidation, so changes during a rebuild aren't lost
+
+	sheets    []SheetSuggestion
+	composers []ComposerSuggestion
+	tags      []string
+
+	sheetAccess    []suggestAccess
+	composerAccess map[string]suggestAccess // by safe name, sheets of hidden composers are hidden too
+	tagSheets      [][]int                  // items of the sheets each tag is used on
+	userGroups     map[uint32]map[uint32]bool
+
+	sheetEntries    []suggestEntry
+	composerEntries []suggestEntry
+	tagEntries      []suggestEntry
+}
+
+var suggestIdx = &suggestIndex{stale: true}
+
+func RegisterSuggestIndexCallbacks(db *gorm.DB) {
+
+	// Mark the index stale whenever a sheet, composer, tag or group membership is written
+	invalidate := func(scope *gorm.Scope) {
+		switch scope.TableName() {
+		case "sheets", "composers", "tags", "sheet_tags", "group_members":
+			InvalidateSuggestIndex()
+		}
+	}
+	db.Callback().Create().After("gorm:create").Register("suggest:invalidate", invalidate)
+	db.Callback().Update().After("gorm:update").Register("suggest:invalidate", invalidate)
+	db.Callback().Delete().After("gorm:delete").Register("suggest:invalidate", invalidate)
+}
+
+func InvalidateSuggestIndex() {
+	suggestIdx.Lock()
+	suggestIdx.stale = true
+	suggestIdx.generation++
+	suggestIdx.Unlock()
+}
+
+func Suggest(db *gorm.DB, query string, types []string, limit int, viewer *User) (*Suggestions, error) {
+	/*
+		Return the top prefix matches of each requested type.
+		Types can be "sheet", "composer" and "tag".
+		The index holds everything, sheets and composers hidden from the viewer are skipped
+		and so are tags which aren't used on one of the sheets they may see.
+	*/
+	if err := suggestIdx.ensureFresh(db); err != nil {
+		return nil, err
+	}
+
+	q := utils.NormalizeSearchString(query)
+	suggestions := &Suggestions{}
+	if q == "" || viewer == nil {
+		return suggestions, nil
+	}
+
+	suggestIdx.RLock()
+	defer suggestIdx.RUnlock()
+
+	// Users who see everything need no filter
+	var sheetAllowed, composerAllowed, tagAllowed func(i int) bool
+	if !viewer.SeesAllSheets() {
+		sheetAllowed = func(i int) bool {
+			return suggestIdx.sheetVisible(i, viewer)
+		}
+		composerAllowed = func(i int) bool {
+			return suggestIdx.visible(suggestIdx.composerAccess[suggestIdx.composers[i].SafeName], viewer)
+		}
+		tagAllowed = func(i int) bool {
+			for _, sheet := range suggestIdx.tagSheets[i] {
+				if suggestIdx.sheetVisible(sheet, viewer) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	for _, t := range types {
+		switch t {
+		case "sheet":
+			for _, i := range lookup(suggestIdx.sheetEntries, q, limit, sheetAllowed) {
+				suggestions.Sheets = append(suggestions.Sheets, suggestIdx.sheets[i])
+			}
+		case "composer":
+			for _, i := range lookup(suggestIdx.composerEntries, q, limit, composerAllowed) {
+				suggestions.Composers = append(suggestions.Composers, suggestIdx.composers[i])
+			}
+		case "tag":
+			for _, i := range lookup(suggestIdx.tagEntries, q, limit, tagAllowed) {
+				suggestions.Tags = append(suggestions.Tags, suggestIdx.tags[i])
+			}
+		}
+	}
+	return suggestions, nil
+}
+
+func (idx *suggestIndex) visible(access suggestAccess, viewer *User) bool {
+
+	// The same rules as visibleCondition, callers hold the lock
+	return access.visibility == VisibilityEveryone || access.owner == viewer.ID ||
+		(access.visibility == VisibilityGroup && idx.userGroups[viewer.ID][access.groupID])
+}
+
+func (idx *suggestIndex) sheetVisible(item int, viewer *User) bool {
+
+	// Like VisibleSheets, the uploader sees the sheet even if its composer is hidden
+	access := idx.sheetAccess[item]
+	if access.owner == viewer.ID {
+		return true
+	}
+	if !idx.visible(access, viewer) {
+		return false
+	}
+	composer, ok := idx.composerAccess[idx.sheets[item].SafeComposer]
+	return !ok || idx.visible(composer, viewer)
+}
+
+func (idx *suggestIndex) ensureFresh(db *gorm.DB) error {
+	idx.RLock()
+	stale := idx.stale
+	generation := idx.generation
+	idx.RUnlock()
+	if !stale {
+		return nil
+	}
+
+	var sheets []Sheet
+	if err := db.Select("safe_sheet_name, sheet_name, safe_composer, uploader_id, visibility, group_id").Find(&sheets).Error; err != nil {
+		return err
+	}
+	var composers []Composer
+	if err := db.Select("safe_name, name, owner_id, visibility, group_id").Find(&composers).Error; err != nil {
+		return err
+	}
+	var tags []Tag
+	if err := db.Select("id, name").Find(&tags).Error; err != nil {
+		return err
+	}
+	var sheetTags []SheetTag
+	if err := db.Find(&sheetTags).Error; err != nil {
+		return err
+	}
+	var members []GroupMember
+	if err := db.Find(&members).Error; err != nil {
+		return err
+	}
+
+	idx.Lock()
+	defer idx.Unlock()
+
+	idx.sheets = make([]SheetSuggestion, 0, len(sheets))
+	idx.sheetAccess = make([]suggestAccess, 0, len(sheets))
+	idx.sheetEntries = nil
+	sheetItems := map[string]int{}
+	for _, sheet := range sheets {
+		idx.sheets = append(idx.sheets, SheetSuggestion{
+			SafeSheetName: sheet.SafeSheetName,
+			SheetName:     sheet.SheetName,
+			SafeComposer:  sheet.SafeComposer,
+		})
+		idx.sheetAccess = append(idx.sheetAccess, suggestAccess{sheet.UploaderID, sheet.Visibility, sheet.GroupID})
+		sheetItems[sheet.SafeSheetName] = len(idx.sheets) - 1
+		idx.sheetEntries = appendEntries(idx.sheetEntries, sheet.SheetName, len(idx.sheets)-1)
+	}
+
+	idx.composers = make([]ComposerSuggestion, 0, len(composers))
+	idx.composerAccess = make(map[string]suggestAccess, len(composers))
+	idx.composerEntries = nil
+	for _, composer := range composers {
+		idx.composers = append(idx.composers, ComposerSuggestion{
+			SafeName: composer.SafeName,
+			Name:     composer.Name,
+		})
+		idx.composerAccess[composer.SafeName] = suggestAccess{composer.OwnerID, composer.Visibility, composer.GroupID}
+		idx.composerEntries = appendEntries(idx.composerEntries, composer.Name, len(idx.composers)-1)
+	}
+
+	idx.tags = make([]string, len(tags))
+	idx.tagSheets = make([][]int, len(tags))
+	idx.tagEntries = nil
+	tagItems := map[uint32]int{}
+	for i, tag := range tags {
+		idx.tags[i] = tag.Name
+		tagItems[tag.ID] = i
+		idx.tagEntries = appendEntries(idx.tagEntries, tag.Name, i)
+	}
+	for _, sheetTag := range sheetTags {
+		tag, tagOk := tagItems[sheetTag.TagID]
+		sheet, sheetOk := sheetItems[sheetTag.SafeSheetName]
+		if tagOk && sheetOk {
+			idx.tagSheets[tag] = append(idx.tagSheets[tag], sheet)
+		}
+	}
+
+	idx.userGroups = map[uint32]map[uint32]bool{}
+	for _, member := range members {
+		if idx.userGroups[member.UserID] == nil {
+			idx.userGroups[member.UserID] = map[uint32]bool{}
+		}
+		idx.userGroups[member.UserID][member.GroupID] = true
+	}
+
+	for _, entries := range [][]suggestEntry{idx.sheetEntries, idx.composerEntries, idx.tagEntries} {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].key < entries[j].key
+		})
+	}
+
+	idx.stale = idx.generation != generation
+	return nil
+}
+
+func appendEntries(entries []suggestEntry, name string, item int) []suggestEntry {
+
+	// Add one entry per word of the name
+	normalized := utils.NormalizeSearchString(name)
+	words := strings.Fields(normalized)
+	for i := range words {
+		entries = append(entries, suggestEntry{
+			key:  strings.Join(words[i:], " "),
+			name: normalized,
+			item: item,
+		})
+	}
+	return entries
+}
+
+func lookup(entries []suggestEntry, prefix string, limit int, allowed func(item int) bool) []int {
+	/*
+		Binary search the first entry with the prefix and collect all matches.
+		Names starting with the prefix rank before names which only contain
+		a word starting with it, shorter names before longer ones.
+		Items allowed returns false for are skipped, a nil allowed keeps all.
+	*/
+	start := sort.Search(len(entries), func(i int) bool {
+		return entries[i].key >= prefix
+	})
+
+	var matches []suggestEntry
+	seen := map[int]bool{}
+	for i := start; i < len(entries) && strings.HasPrefix(entries[i].key, prefix); i++ {
+		if !seen[entries[i].item] && (allowed == nil || allowed(entries[i].item)) {
+			seen[entries[i].item] = true
+			matches = append(matches, entries[i])
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		iStart := strings.HasPrefix(matches[i].name, prefix)
+		jStart := strings.HasPrefix(matches[j].name, prefix)
+		if iStart != jStart {
+			return iStart
+		}
+		return len(matches[i].name) < len(matches[j].name)
+	})
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	items := make([]int, len(matches))
+	for i, match := range matches {
+		items[i] = match.item
+	}
+	return items
+}
@@ -0,0 +1,330 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+/*
+	Tags can be nested by separating the levels with a slash, e.g. "Instrument/Strings/Violin".
+	The name always holds the full path, ParentID points to the tag one level above.
+*/
+type Tag struct {
+	ID          uint32    `gorm:"primary_key;auto_increment" json:"id"`
+	Name        string    `gorm:"size:255;not null;unique_index" json:"name"`
+	ParentID    *uint32   `gorm:"index" json:"parent_id"`
+	Color       string    `gorm:"size:20" json:"color"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+var (
+	ErrNestedTags     = errors.New("tag has nested tags, move or delete them first")
+	ErrTagExists      = errors.New("already exists, merge the tags instead")
+	ErrTagBelowItself = errors.New("a tag can't be moved below itself")
+)
+
+// Join table between sheets and tags
+type SheetTag struct {
+	SafeSheetName string `gorm:"primary_key;size:255" json:"safe_sheet_name"`
+	TagID         uint32 `gorm:"primary_key;auto_increment:false;index" json:"tag_id"`
+}
+
+func (t *Tag) Prepare() {
+	t.Name = NormalizeTagPath(t.Name)
+	t.Color = strings.TrimSpace(t.Color)
+	t.Description = strings.TrimSpace(t.Description)
+	t.CreatedAt = time.Now()
+	t.UpdatedAt = time.Now()
+}
+
+func (t *Tag) FindTagByName(db *gorm.DB, name string) (*Tag, error) {
+	err := db.Model(&Tag{}).Where("name = ?", NormalizeTagPath(name)).Take(&t).Error
+	if err != nil {
+		return &Tag{}, err
+	}
+	return t, nil
+}
+
+func FindOrCreateTag(db *gorm.DB, name string) (*Tag, error) {
+
+	// Missing parents of nested tags are created as well
+	name = NormalizeTagPath(name)
+	if name == "" {
+		return &Tag{}, errors.New("Required tag name")
+	}
+
+	tag := &Tag{}
+	_, err := tag.FindTagByName(db, name)
+	if err == nil {
+		return tag, nil
+	}
+	if !gorm.IsRecordNotFoundError(err) {
+		return &Tag{}, err
+	}
+
+	tag = &Tag{Name: name}
+	if parentPath := ParentTagPath(name); parentPath != "" {
+		parent, err := FindOrCreateTag(db, parentPath)
+		if err != nil {
+			return &Tag{}, err
+		}
+		tag.ParentID = &parent.ID
+	}
+	tag.Prepare()
+	err = db.Create(tag).Error
+	if err != nil {
+		return &Tag{}, err
+	}
+	return tag, nil
+}
+
+func LoadSheetTags(db *gorm.DB, sheets []*Sheet) error {
+	/*
+		Fill the Tags of all given sheets with a single query.
+		Sheets loaded straight from the database don't carry their tags.
+	*/
+	if len(sheets) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(sheets))
+	bySheet := map[string]*Sheet{}
+	for i, sheet := range sheets {
+		names[i] = sheet.SafeSheetName
+		bySheet[sheet.SafeSheetName] = sheet
+		sheet.Tags = []string{}
+	}
+
+	rows, err := db.Table("sheet_tags").
+		Select("sheet_tags.safe_sheet_name, tags.name").
+		Joins("JOIN tags ON tags.id = sheet_tags.tag_id").
+		Where("sheet_tags.safe_sheet_name IN (?)", names).
+		Order("tags.name asc").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sheetName, tagName string
+		if err := rows.Scan(&sheetName, &tagName); err != nil {
+			return err
+		}
+		if sheet, ok := bySheet[sheetName]; ok {
+			sheet.Tags = append(sheet.Tags, tagName)
+		}
+	}
+	return rows.Err()
+}
+
+func MigrateSheetTags(db *gorm.DB) error {
+	/*
+		Tags used to be stored as a text[] column on the sheets table.
+		Move them into the tags and sheet_tags tables and get rid of the old column.
+	*/
+	if !db.Dialect().HasColumn("sheets", "tags") {
+		return nil
+	}
+
+	type legacySheet struct {
+		SafeSheetName string
+		Tags          pq.StringArray
+	}
+	var legacySheets []legacySheet
+	err := db.Table("sheets").Select("safe_sheet_name, tags").Where("tags IS NOT NULL").Scan(&legacySheets).Error
+	if err != nil {
+		return err
+	}
+	if len(legacySheets) == 0 {
+		// Nothing left to move, the column is only dropped where the database supports it
+		db.Model(&Sheet{}).DropColumn("tags")
+		return nil
+	}
+
+	tx := db.Begin()
+	for _, sheet := range legacySheets {
+		for _, name := range sheet.Tags {
+			tag, err := FindOrCreateTag(tx, name)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			err = tx.Where(SheetTag{SafeSheetName: sheet.SafeSheetName, TagID: tag.ID}).FirstOrCreate(&SheetTag{}).Error
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	// Older SQLite versions can't drop columns, emptying it keeps the migration from running twice
+	if err := db.Model(&Sheet{}).DropColumn("tags").Error; err != nil {
+		log.Printf("unable to drop legacy tags column, clearing it instead: %s\n", err.Error())
+		return db.Exec("UPDATE sheets SET tags = NULL").Error
+	}
+	return nil
+}
+
+type TagCount struct {
+	Tag
+	Count int64 `json:"count"`
+}
+
+func ListTagsWithCount(db *gorm.DB, viewer *User) ([]TagCount, error) {
+
+	/*
+		All tags together with the number of sheets using them the viewer may see, most used first.
+		Users who don't see all sheets only get the tags of their visible sheets,
+		a tag used only on hidden sheets would otherwise give away that they exist.
+	*/
+	visible := db.Model(&Sheet{}).Scopes(VisibleSheets(viewer)).Select("sheets.safe_sheet_name").QueryExpr()
+	query := db.Table("tags").
+		Select("tags.*, COUNT(sheet_tags.tag_id) AS count").
+		Joins("LEFT JOIN sheet_tags ON sheet_tags.tag_id = tags.id AND sheet_tags.safe_sheet_name IN (?)", visible).
+		Group("tags.id")
+	if !viewer.SeesAllSheets() {
+		query = query.Having("COUNT(sheet_tags.tag_id) > 0")
+	}
+	var tags []TagCount
+	err := query.Order("count desc, tags.name asc").Scan(&tags).Error
+	return tags, err
+}
+
+func (t *Tag) UpdateTag(db *gorm.DB, newName string, color *string, description *string) (*Tag, error) {
+	/*
+		Rename a tag and/or change its color and description, nil leaves them unchanged.
+		Renaming onto a name that is already taken is refused, merge the tags instead.
+		Renaming a nested tag moves it (and all its children) to the new path.
+	*/
+	tx := db.Begin()
+
+	newName = NormalizeTagPath(newName)
+	if newName != "" && newName != t.Name {
+		if err := t.move(tx, newName); err != nil {
+			tx.Rollback()
+			return &Tag{}, err
+		}
+	}
+	if color != nil {
+		t.Color = strings.TrimSpace(*color)
+	}
+	if description != nil {
+		t.Description = strings.TrimSpace(*description)
+	}
+	t.UpdatedAt = time.Now()
+
+	err := tx.Save(t).Error
+	if err != nil {
+		tx.Rollback()
+		return &Tag{}, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return &Tag{}, err
+	}
+	return t, nil
+}
+
+func MergeTags(db *gorm.DB, sources []string, target string) (*Tag, error) {
+	/*
+		Move every sheet of the source tags over to the target tag and delete the sources.
+		The target tag gets created if it doesn't exist yet.
+	*/
+	tx := db.Begin()
+
+	targetTag, err := FindOrCreateTag(tx, target)
+	if err != nil {
+		tx.Rollback()
+		return &Tag{}, err
+	}
+
+	for _, source := range sources {
+		sourceTag := Tag{}
+		_, err := sourceTag.FindTagByName(tx, source)
+		if err != nil {
+			tx.Rollback()
+			if gorm.IsRecordNotFoundError(err) {
+				return &Tag{}, fmt.Errorf("tag %s not found", source)
+			}
+			return &Tag{}, err
+		}
+		if sourceTag.ID == targetTag.ID {
+			continue
+		}
+		if hasChildren, err := sourceTag.HasChildren(tx); err != nil || hasChildren {
+			tx.Rollback()
+			if err != nil {
+				return &Tag{}, err
+			}
+			return &Tag{}, fmt.Errorf("unable to merge %s: %w", source, ErrNestedTags)
+		}
+
+		var sheetNames []string
+		err = tx.Model(&SheetTag{}).Where("tag_id = ?", sourceTag.ID).Pluck("safe_sheet_name", &sheetNames).Error
+		if err != nil {
+			tx.Rollback()
+			return &Tag{}, err
+		}
+		for _, sheetName := range sheetNames {
+			err = tx.Where(SheetTag{SafeSheetName: sheetName, TagID: targetTag.ID}).FirstOrCreate(&SheetTag{}).Error
+			if err != nil {
+				tx.Rollback()
+				return &Tag{}, err
+			}
+		}
+
+		if err := sourceTag.deleteWithSheetTags(tx); err != nil {
+			tx.Rollback()
+			return &Tag{}, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return &Tag{}, err
+	}
+	return targetTag, nil
+}
+
+func (t *Tag) DeleteTag(db *gorm.DB) (int64, error) {
+
+	// Remove the tag from every sheet at once, returns the number of affected sheets
+	hasChildren, err := t.HasChildren(db)
+	if err != nil {
+		return 0, err
+	}
+	if hasChildren {
+		return 0, ErrNestedTags
+	}
+
+	var affected int64
+	err = db.Model(&SheetTag{}).Where("tag_id = ?", t.ID).Count(&affected).Error
+	if err != nil {
+		return 0, err
+	}
+
+	tx := db.Begin()
+	if err := t.deleteWithSheetTags(tx); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return affected, tx.Commit().Error
+}
+
+func (t *Tag) deleteWithSheetTags(db *gorm.DB) error {
+	err := db.Where("tag_id = ?", t.ID).Delete(&SheetTag{}).Error
+	if err != nil {
+		return err
+	}
+	return db.Delete(t).Error
+}
@@ -0,0 +1,231 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const TagPathSeparator = "/"
+
+type TagNode struct {
+	TagCount
+	Label    string     `json:"label"` // Last level of the name, "Violin" for "Instrument/Strings/Violin"
+	Children []*TagNode `json:"children"`
+}
+
+func NormalizeTagPath(name string) string {
+
+	// "Instrument / Strings/ Violin " -> "Instrument/Strings/Violin"
+	var levels []string
+	for _, level := range strings.Split(name, TagPathSeparator) {
+		level = strings.TrimSpace(level)
+		if level != "" {
+			levels = append(levels, level)
+		}
+	}
+	return strings.Join(levels, TagPathSeparator)
+}
+
+func ParentTagPath(name string) string {
+	index := strings.LastIndex(name, TagPathSeparator)
+	if index == -1 {
+		return ""
+	}
+	return name[:index]
+}
+
+func TagLabel(name string) string {
+	return name[strings.LastIndex(name, TagPathSeparator)+1:]
+}
+
+func (t *Tag) HasChildren(db *gorm.DB) (bool, error) {
+	var count int64
+	err := db.Model(&Tag{}).Where("parent_id = ?", t.ID).Count(&count).Error
+	return count > 0, err
+}
+
+func (t *Tag) Descendants(db *gorm.DB) ([]*Tag, error) {
+
+	// All tags below this one, walking the tree level by level
+	var descendants []*Tag
+	parentIDs := []uint32{t.ID}
+	for len(parentIDs) > 0 {
+		var children []*Tag
+		err := db.Where("parent_id IN (?)", parentIDs).Find(&children).Error
+		if err != nil {
+			return nil, err
+		}
+		parentIDs = nil
+		for _, child := range children {
+			descendants = append(descendants, child)
+			parentIDs = append(parentIDs, child.ID)
+		}
+	}
+	return descendants, nil
+}
+
+func MoveTag(db *gorm.DB, t *Tag, newParent string) (*Tag, error) {
+	/*
+		Re-parent a tag together with all its children.
+		An empty parent moves the tag to the top level.
+	*/
+	newParent = NormalizeTagPath(newParent)
+	newName := TagLabel(t.Name)
+	if newParent != "" {
+		newName = newParent + TagPathSeparator + newName
+	}
+	if newName == t.Name {
+		return t, nil
+	}
+
+	tx := db.Begin()
+	if err := t.move(tx, newName); err != nil {
+		tx.Rollback()
+		return &Tag{}, err
+	}
+	t.UpdatedAt = time.Now()
+	if err := tx.Save(t).Error; err != nil {
+		tx.Rollback()
+		return &Tag{}, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return &Tag{}, err
+	}
+	return t, nil
+}
+
+func (t *Tag) move(db *gorm.DB, newName string) error {
+	/*
+		Give the tag a new path and rewrite the paths of all its children.
+		The caller saves the tag itself, children are saved right away.
+	*/
+	oldName := t.Name
+	if strings.HasPrefix(newName+TagPathSeparator, oldName+TagPathSeparator) {
+		return ErrTagBelowItself
+	}
+
+	descendants, err := t.Descendants(db)
+	if err != nil {
+		return err
+	}
+
+	// Make sure no tag already sits at one of the new paths
+	newNames := []string{newName}
+	for _, descendant := range descendants {
+		newNames = append(newNames, newName+strings.TrimPrefix(descendant.Name, oldName))
+	}
+	var taken []string
+	err = db.Model(&Tag{}).Where("name IN (?)", newNames).Pluck("name", &taken).Error
+	if err != nil {
+		return err
+	}
+	if len(taken) > 0 {
+		return fmt.Errorf("tag %s %w", taken[0], ErrTagExists)
+	}
+
+	t.ParentID = nil
+	if parentPath := ParentTagPath(newName); parentPath != "" {
+		parent, err := FindOrCreateTag(db, parentPath)
+		if err != nil {
+			return err
+		}
+		t.ParentID = &parent.ID
+	}
+	t.Name = newName
+
+	for i, descendant := range descendants {
+		err = db.Model(descendant).Update("name", newNames[i+1]).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TagWithDescendantIDs(db *gorm.DB, name string) ([]uint32, error) {
+	tag := Tag{}
+	_, err := tag.FindTagByName(db, name)
+	if err != nil {
+		return nil, err
+	}
+	descendants, err := tag.Descendants(db)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []uint32{tag.ID}
+	for _, descendant := range descendants {
+		ids = append(ids, descendant.ID)
+	}
+	return ids, nil
+}
+
+func BuildTagTree(db *gorm.DB, viewer *User) ([]*TagNode, error) {
+
+	// All tags with their usage count, nested below their parents
+	tags, err := ListTagsWithCount(db, viewer)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := map[uint32]*TagNode{}
+	for _, tag := range tags {
+		nodes[tag.ID] = &TagNode{
+			TagCount: tag,
+			Label:    TagLabel(tag.Name),
+			Children: []*TagNode{},
+		}
+	}
+
+	roots := []*TagNode{}
+	for _, tag := range tags {
+		node := nodes[tag.ID]
+		if tag.ParentID != nil {
+			if parent, ok := nodes[*tag.ParentID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+
+	sortTagNodes(roots)
+	return roots, nil
+}
+
+func sortTagNodes(nodes []*TagNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Label < nodes[j].Label
+	})
+	for _, node := range nodes {
+		sortTagNodes(node.Children)
+	}
+}
+
+func LinkTagParents(db *gorm.DB) error {
+	/*
+		Tags created with a slash in their name before nesting was supported
+		have no parent yet. Link them, creating missing parents on the way.
+	*/
+	var orphans []*Tag
+	err := db.Where("parent_id IS NULL AND name LIKE ?", "%"+TagPathSeparator+"%").Find(&orphans).Error
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range orphans {
+		parent, err := FindOrCreateTag(db, ParentTagPath(tag.Name))
+		if err != nil {
+			return err
+		}
+		err = db.Model(tag).Update("parent_id", parent.ID).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	Failed attempts per IP or account. Once a key reaches MaxFailures of its rule
+	it gets locked, every further failure doubles the lockout up to MaxLockout.
+	Failures older than the window are forgotten.
+*/
+type Throttle struct {
+	Key           string    `gorm:"column:throttle_key;primary_key;size:255" json:"key"` // e.g. login:ip:127.0.0.1
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+// Logged every time a key gets locked, so admins see what is going on
+type LockoutEvent struct {
+	ID          uint32    `gorm:"primary_key;auto_increment" json:"id"`
+	Scope       string    `gorm:"size:50;index" json:"scope"` // login, password_reset or register
+	Key         string    `gorm:"column:throttle_key;size:255;index" json:"key"`
+	IP          string    `gorm:"size:45" json:"ip"`
+	Account     string    `gorm:"size:100" json:"account"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+	CreatedAt   time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+type ThrottleRule struct {
+	Scope       string
+	MaxFailures int
+	Window      time.Duration
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+}
+
+var (
+	LoginIPRule      = ThrottleRule{Scope: "login", MaxFailures: 20, Window: 15 * time.Minute, BaseLockout: time.Minute, MaxLockout: time.Hour}
+	LoginAccountRule = ThrottleRule{Scope: "login", MaxFailures: 5, Window: 15 * time.Minute, BaseLockout: time.Minute, MaxLockout: time.Hour}
+	ResetIPRule      = ThrottleRule{Scope: "password_reset", MaxFailures: 10, Window: time.Hour, BaseLockout: 5 * time.Minute, MaxLockout: 24 * time.Hour}
+	ResetAccountRule = ThrottleRule{Scope: "password_reset", MaxFailures: 3, Window: time.Hour, BaseLockout: 5 * time.Minute, MaxLockout: 24 * time.Hour}
+	RegisterIPRule   = ThrottleRule{Scope: "register", MaxFailures: 10, Window: time.Hour, BaseLockout: 5 * time.Minute, MaxLockout: 24 * time.Hour}
+)
+
+func ThrottleKey(scope string, kind string, value string) string {
+	return scope + ":" + kind + ":" + strings.ToLower(strings.TrimSpace(value))
+}
+
+func ThrottleRetryAfter(db *gorm.DB, keys ...string) (time.Duration, error) {
+
+	// How long the longest lock of the keys still lasts, 0 if none is locked
+	var throttles []Throttle
+	err := db.Where("throttle_key IN (?) AND locked_until > ?", keys, time.Now()).Find(&throttles).Error
+	if err != nil {
+		return 0, err
+	}
+	var retryAfter time.Duration
+	for _, throttle := range throttles {
+		if wait := time.Until(throttle.LockedUntil); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	return retryAfter, nil
+}
+
+func RegisterThrottleFailure(db *gorm.DB, rule ThrottleRule, key string, ip string, account string) error {
+	/*
+		The failure is counted in the database with failures + 1, so parallel
+		attempts can't overwrite each other's count and slip past the lockout.
+	*/
+	now := time.Now()
+	err := db.Model(&Throttle{}).
+		Where("throttle_key = ? AND last_failure_at < ? AND locked_until < ?", key, now.Add(-rule.Window), now).
+		UpdateColumn("failures", 0).Error
+	if err != nil {
+		return err
+	}
+	if err := countThrottleFailure(db, key, now); err != nil {
+		return err
+	}
+
+	throttle := Throttle{}
+	if err := db.Where("throttle_key = ?", key).Take(&throttle).Error; err != nil {
+		return err
+	}
+	if throttle.Failures < rule.MaxFailures {
+		return nil
+	}
+
+	// 1x, 2x, 4x, ... the base lockout for every failure above the limit
+	lockout := time.Duration(float64(rule.BaseLockout) * math.Pow(2, float64(throttle.Failures-rule.MaxFailures)))
+	if lockout > rule.MaxLockout || lockout <= 0 {
+		lockout = rule.MaxLockout
+	}
+	lockedUntil := now.Add(lockout)
+	if err := db.Model(&Throttle{}).Where("throttle_key = ?", key).UpdateColumn("locked_until", lockedUntil).Error; err != nil {
+		return err
+	}
+	return db.Create(&LockoutEvent{
+		Scope:       rule.Scope,
+		Key:         key,
+		IP:          ip,
+		Account:     account,
+		Failures:    throttle.Failures,
+		LockedUntil: lockedUntil,
+		CreatedAt:   now,
+	}).Error
+}
+
+func countThrottleFailure(db *gorm.DB, key string, now time.Time) error {
+	increment := func() (int64, error) {
+		result := db.Model(&Throttle{}).Where("throttle_key = ?", key).UpdateColumns(map[string]interface{}{
+			"failures":        gorm.Expr("failures + 1"),
+			"last_failure_at": now,
+		})
+		return result.RowsAffected, result.Error
+	}
+	updated, err := increment()
+	if err != nil || updated > 0 {
+		return err
+	}
+
+	// First failure of the key, if a parallel request created it first the insert fails and its row gets counted
+	if err := db.Create(&Throttle{Key: key, Failures: 1, LastFailureAt: now}).Error; err == nil {
+		return nil
+	}
+	updated, err = increment()
+	if err == nil && updated == 0 {
+		return errors.New("unable to count the failure of " + key)
+	}
+	return err
+}
+
+func ResetThrottle(db *gorm.DB, keys ...string) error {
+	return db.Where("throttle_key IN (?)", keys).Delete(&Throttle{}).Error
+}
+
+func FindLockoutEvents(db *gorm.DB, scope string, pagination Pagination) (*Pagination, error) {
+	query := db.Model(&LockoutEvent{})
+	if scope != "" {
+		query = query.Where("scope = ?", scope)
+	}
+
+	var totalRows int64
+	if err := query.Count(&totalRows).Error; err != nil {
+		return nil, err
+	}
+	pagination.TotalRows = totalRows
+	pagination.TotalPages = int(math.Ceil(float64(totalRows) / float64(pagination.GetLimit())))
+
+	events := []*LockoutEvent{}
+	err := query.Order("created_at desc").
+		Offset(pagination.GetOffset()).
+		Limit(pagination.GetLimit()).
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	pagination.Sort = "created_at desc"
+	pagination.Rows = events
+	return &pagination, nil
+}
+
+func FindLockedThrottles(db *gorm.DB) ([]*Throttle, error) {
+	throttles := []*Throttle{}
+	err := db.Where("locked_until > ?", time.Now()).Order("locked_until desc").Find(&throttles).Error
+	return throttles, err
+}
@@ -2,14 +2,11 @@ package models
 
 import (
 	"errors"
-	"fmt"
 	"html"
 	"log"
-	"net/http"
 	"strings"
 	"time"
 
-	"github.com/SheetAble/SheetAble/backend/api/utils"
 	"github.com/badoux/checkmail"
 	"github.com/jinzhu/gorm"
 	"golang.org/x/crypto/bcrypt"
@@ -18,14 +15,22 @@ import (
 const ()
 
 type User struct {
-	ID                  uint32    `gorm:"primary_key;auto_increment" json:"id"` // If ID == 1: user = admin
-	Email               string    `gorm:"size:100;not null;unique" json:"email"`
-	Role                uint8     `json:"role"` // 0=admin 1=normal,
-	Password            string    `gorm:"size:100;not null;" json:"password"`
-	PasswordReset       string    `gorm:"size:10;unique" json:"password_reset"` /* Random 8 char string for resetting the password (prob not the best implementation of a password reset so it could be redone)*/
-	PasswordResetExpire time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"password_reset_expire"`
-	CreatedAt           time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt           time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	ID           uint32    `gorm:"primary_key;auto_increment" json:"id"`
+	Email        string    `gorm:"size:100;not null;unique" json:"email"`
+	Role         uint8     `json:"role"` // 0=admin 1=editor 2=contributor 3=viewer, see Role.go
+	Password     string    `gorm:"size:100;not null;" json:"password"`
+	OidcSubject  string    `gorm:"size:255;index" json:"-"`                   // Set once the user logged in through single sign-on
+	LdapDN       string    `gorm:"size:255;index" json:"-"`                   // Set once the user logged in through LDAP
+	ProxyUser    string    `gorm:"size:255;index" json:"-"`                   // Set once the user logged in through a reverse proxy
+	Pending      bool      `json:"pending"`                                   // Registered but not approved by an admin yet, see Registration.go
+	Unconfirmed  bool      `gorm:"not null;default:false" json:"unconfirmed"` // Registered but the email address isn't confirmed yet, see EmailConfirmation.go
+	TotpEnabled  bool      `json:"totp_enabled"`
+	TotpSecret   string    `gorm:"size:64" json:"-"`
+	TotpLastStep int64     `json:"-"` // Time step of the last accepted code, see auth.ValidateTotp
+	CreatedAt    time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+
+	apiToken *ApiToken // Set when the request was authenticated with a personal API token
 }
 
 func Hash(password string) ([]byte, error) {
@@ -48,9 +53,11 @@ func (u *User) BeforeSave() error {
 func (u *User) Prepare() {
 	u.ID = 0
 	u.Email = html.EscapeString(strings.TrimSpace(u.Email))
-	u.Role = 1
-	u.PasswordReset = utils.CreateRandString(40)
-	u.PasswordResetExpire = time.Now()
+	u.Role = RoleEditor
+	u.Pending = false     // Only set by the registration
+	u.Unconfirmed = false // Only set by the registration
+	u.TotpEnabled = false // Only turned on through the enrollment, see Mfa.go
+	u.TotpSecret = ""
 	u.CreatedAt = time.Now()
 	u.UpdatedAt = time.Now()
 }
@@ -80,6 +87,14 @@ func (u *User) Validate(action string) error {
 			return errors.New("Invalid Email")
 		}
 		return nil
+	case "username-login":
+		if u.Password == "" {
+			return errors.New("Required Password")
+		}
+		if u.Email == "" {
+			return errors.New("Required Email")
+		}
+		return nil
 
 	default:
 		if u.Password == "" {
@@ -139,18 +154,6 @@ func (u *User) FindUserByEmail(db *gorm.DB, email string) (*User, error) {
 	return u, err
 }
 
-func (u *User) FindUserByPasswordResetId(db *gorm.DB, passwordResetId string) (*User, error) {
-	var err error
-	err = db.Model(User{}).Where("password_reset = ?", passwordResetId).Take(&u).Error
-	if err != nil {
-		return &User{}, err
-	}
-	if gorm.IsRecordNotFoundError(err) {
-		return &User{}, errors.New("User Not Found")
-	}
-	return u, err
-}
-
 func (u *User) UpdateAUser(db *gorm.DB, uid uint32) (*User, error) {
 	// To hash the password
 	err := u.BeforeSave()
@@ -177,59 +180,25 @@ func (u *User) UpdateAUser(db *gorm.DB, uid uint32) (*User, error) {
 
 func (u *User) DeleteAUser(db *gorm.DB, uid uint32) (int64, error) {
 
-	db = db.Model(&User{}).Where("id = ?", uid).Take(&User{}).Delete(&User{})
-
-	if db.Error != nil {
-		return 0, db.Error
-	}
-	return db.RowsAffected, nil
-}
-
-func RequestPasswordReset(db *gorm.DB, email string) (string, error) {
-	user := User{}
-	_, err := user.FindUserByEmail(db, email)
-	if gorm.IsRecordNotFoundError(err) {
-		return "", errors.New("Email doesn't exist in the server.")
-	}
-
-	passwordReset := utils.CreateRandString(40)
-
-	db = db.Model(&User{}).Where("email = ?", email).Take(&User{}).UpdateColumns(
-		map[string]interface{}{
-			"password_reset":        passwordReset,
-			"password_reset_expire": time.Now().Add(time.Hour * time.Duration(1)), // Set new expire date to 1h
-		},
-	)
-
-	return passwordReset, nil
-}
-
-func ResetPassword(db *gorm.DB, passwordResetId string, updatedPassword string) (*User, error, int) {
 	user := User{}
-	_, err := user.FindUserByPasswordResetId(db, passwordResetId)
-	if gorm.IsRecordNotFoundError(err) {
-		return &User{}, errors.New("This passwordResetId is invalid."), http.StatusNotFound
-	}
-	if user.PasswordResetExpire.Before(time.Now()) {
-		return &User{}, errors.New("PasswordResetId has already expired."), http.StatusForbidden
+	if _, err := user.FindUserByID(db, uid); err == nil && user.IsAdmin() {
+		if err := ensureOtherAdmin(db, uid); err != nil {
+			return 0, err
+		}
 	}
 
-	user.Password = updatedPassword
+	db.Where("user_id = ?", uid).Delete(&Favorite{})
+	db.Where("user_id = ?", uid).Delete(&SheetView{})
+	db.Where("user_id = ?", uid).Delete(&ApiToken{})
+	db.Where("user_id = ?", uid).Delete(&RecoveryCode{})
+	db.Where("user_id = ?", uid).Delete(&PasswordReset{})
+	db.Where("user_id = ?", uid).Delete(&EmailConfirmation{})
+	db.Where("user_id = ?", uid).Delete(&GroupMember{})
 
-	user.BeforeSave() /* This will hash the password */
+	db = db.Model(&User{}).Where("id = ?", uid).Take(&User{}).Delete(&User{})
 
-	db = db.Model(&User{}).Where("password_reset = ?", passwordResetId).Take(&User{}).UpdateColumns(
-		map[string]interface{}{
-			"password":              user.Password,
-			"updated_at":            time.Now(),
-			"password_reset_expire": time.Now(), /* So it cannot be used a 2nd time */
-		},
-	)
 	if db.Error != nil {
-		return &User{}, db.Error, 0
+		return 0, db.Error
 	}
-
-	fmt.Println("Update user passsword")
-
-	return &user, nil, 0
+	return db.RowsAffected, nil
 }
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	Who gets to see a sheet or a composer. The uploader of a sheet and the user whose upload
+	created a composer always see it, users who may manage the library see everything.
+	A sheet of a hidden composer is hidden as well, except from its uploader.
+*/
+const (
+	VisibilityPrivate  = "private"  // Only the owner
+	VisibilityGroup    = "group"    // The owner and the members of GroupID
+	VisibilityEveryone = "everyone" // Every logged in user
+)
+
+var (
+	ErrUnknownVisibility = errors.New("unknown visibility, expected private, group or everyone")
+	ErrVisibilityGroup   = errors.New("the visibility group needs the group_id of an existing group")
+	ErrNotGroupMember    = errors.New("you can only share with groups you are a member of")
+)
+
+// An empty visibility means everyone, like for everything uploaded before there were groups
+func ParseVisibility(visibility string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(visibility)) {
+	case "", VisibilityEveryone:
+		return VisibilityEveryone, nil
+	case VisibilityGroup:
+		return VisibilityGroup, nil
+	case VisibilityPrivate:
+		return VisibilityPrivate, nil
+	default:
+		return "", ErrUnknownVisibility
+	}
+}
+
+func ValidateVisibility(db *gorm.DB, actor *User, visibility string, groupID uint32) (string, uint32, error) {
+	/*
+		Check a visibility somebody wants to set, the group id only counts for "group".
+		Users who can't manage the library may only share with their own groups.
+	*/
+	visibility, err := ParseVisibility(visibility)
+	if err != nil {
+		return "", 0, err
+	}
+	if visibility != VisibilityGroup {
+		return visibility, 0, nil
+	}
+
+	group, err := FindGroupByID(db, groupID)
+	if gorm.IsRecordNotFoundError(err) {
+		return "", 0, ErrVisibilityGroup
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	if !actor.SeesAllSheets() && !group.HasMember(actor.ID) {
+		return "", 0, ErrNotGroupMember
+	}
+	return visibility, group.ID, nil
+}
+
+func (u *User) SeesAllSheets() bool {
+	return u != nil && u.Can(PermissionManageLibrary)
+}
+
+func visibleCondition(table string, ownerColumn string) string {
+	return table + ".visibility = '" + VisibilityEveryone + "' OR " + table + "." + ownerColumn + " = ? OR (" +
+		table + ".visibility = '" + VisibilityGroup + "' AND " + table + ".group_id IN (SELECT group_id FROM group_members WHERE user_id = ?))"
+}
+
+// Scope of the sheets the user may see, nobody sees anything without a user
+func VisibleSheets(viewer *User) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if viewer == nil {
+			return db.Where("1 = 0")
+		}
+		if viewer.SeesAllSheets() {
+			return db
+		}
+		return db.Where(
+			"sheets.uploader_id = ? OR (("+visibleCondition("sheets", "uploader_id")+") AND "+
+				"sheets.safe_composer NOT IN (SELECT safe_name FROM composers WHERE NOT ("+visibleCondition("composers", "owner_id")+")))",
+			viewer.ID, viewer.ID, viewer.ID, viewer.ID, viewer.ID,
+		)
+	}
+}
+
+// Scope of the composers the user may see, nobody sees anything without a user
+func VisibleComposers(viewer *User) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if viewer == nil {
+			return db.Where("1 = 0")
+		}
+		if viewer.SeesAllSheets() {
+			return db
+		}
+		return db.Where(visibleCondition("composers", "owner_id"), viewer.ID, viewer.ID)
+	}
+}
+
+func (s *Sheet) VisibleTo(db *gorm.DB, viewer *User) (bool, error) {
+	var count int64
+	err := db.Model(&Sheet{}).Scopes(VisibleSheets(viewer)).Where("safe_sheet_name = ?", s.SafeSheetName).Count(&count).Error
+	return count > 0, err
+}
+
+func (c *Composer) VisibleTo(db *gorm.DB, viewer *User) (bool, error) {
+	var count int64
+	err := db.Model(&Composer{}).Scopes(VisibleComposers(viewer)).Where("safe_name = ?", c.SafeName).Count(&count).Error
+	return count > 0, err
+}
+
+// The safe names of the visible sheets, nil if the user sees all of them
+func VisibleSheetNames(db *gorm.DB, viewer *User) (map[string]bool, error) {
+	if viewer.SeesAllSheets() {
+		return nil, nil
+	}
+	var names []string
+	if err := db.Model(&Sheet{}).Scopes(VisibleSheets(viewer)).Pluck("safe_sheet_name", &names).Error; err != nil {
+		return nil, err
+	}
+	visible := make(map[string]bool, len(names))
+	for _, name := range names {
+		visible[name] = true
+	}
+	return visible, nil
+}
+
+
+func (s *Sheet) SetVisibility(db *gorm.DB, visibility string, groupID uint32) error {
+	err := db.Model(&Sheet{}).Where("safe_sheet_name = ?", s.SafeSheetName).UpdateColumns(map[string]interface{}{
+		"visibility": visibility,
+		"group_id":   groupID,
+	}).Error
+	if err != nil {
+		return err
+	}
+	s.Visibility = visibility
+	s.GroupID = groupID
+	return nil
+}
+
+func (c *Composer) SetVisibility(db *gorm.DB, visibility string, groupID uint32) error {
+	err := db.Model(&Composer{}).Where("safe_name = ?", c.SafeName).UpdateColumns(map[string]interface{}{
+		"visibility": visibility,
+		"group_id":   groupID,
+	}).Error
+	if err != nil {
+		return err
+	}
+	c.Visibility = visibility
+	c.GroupID = groupID
+	return nil
+}
@@ -9,7 +9,7 @@ import (
 )
 
 func Load(db *gorm.DB, email string, password string) {
-	err := db.AutoMigrate(&models.User{}, &models.Sheet{}, &models.Composer{}).Error
+	err := db.AutoMigrate(&models.User{}, &models.Sheet{}, &models.Composer{}, &models.SavedSearch{}, &models.Tag{}, &models.SheetTag{}, &models.Setlist{}, &models.SetlistEntry{}, &models.Favorite{}, &models.SheetView{}, &models.Session{}, &models.ApiToken{}, &models.RecoveryCode{}, &models.MfaChallenge{}, &models.Throttle{}, &models.LockoutEvent{}, &models.PasswordReset{}, &models.Invitation{}, &models.AuditEvent{}, &models.Group{}, &models.GroupMember{}, &models.EmailConfirmation{}).Error
 	if err != nil {
 		log.Fatalf("cannot migrate table: %v", err)
 	}
@@ -17,6 +17,7 @@ func Load(db *gorm.DB, email string, password string) {
 	err = db.Model(&models.User{}).Create(&models.User{
 		Email:    email,
 		Password: password,
+		Role:     models.RoleAdmin,
 	}).Error
 
 	if err != nil {
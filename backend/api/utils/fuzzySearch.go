@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	. "github.com/fiam/gounidecode/unidecode"
+)
+
+const (
+	// Minimum score a candidate needs to be treated as a search hit
+	FuzzyMatchThreshold = 0.7
+	// Lower bar used when looking for a "did you mean" suggestion
+	FuzzySuggestThreshold = 0.45
+)
+
+func NormalizeSearchString(s string) string {
+	/*
+		Fold a string into a form which is comparable regardless of accents,
+		scripts and casing. "Étude N. 1" and "etude n 1" both become "etude n 1".
+	*/
+	folded := strings.ToLower(Unidecode(s))
+
+	var b strings.Builder
+	lastSpace := true
+	for _, r := range folded {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			lastSpace = false
+			continue
+		}
+		if !lastSpace {
+			b.WriteRune(' ')
+			lastSpace = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func Levenshtein(a string, b string) int {
+
+	// Edit distance between two strings (insertions, deletions and substitutions)
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 {
+		return len(rb)
+	}
+	if len(rb) == 0 {
+		return len(ra)
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func LevenshteinSimilarity(a string, b string) float64 {
+
+	// Levenshtein distance scaled to 0..1 where 1 means identical
+	maxLen := len([]rune(a))
+	if l := len([]rune(b)); l > maxLen {
+		maxLen = l
+	}
+	if maxLen == 0 {
+		return 1
+	}
+	return 1 - float64(Levenshtein(a, b))/float64(maxLen)
+}
+
+func Trigrams(s string) map[string]bool {
+
+	// Set of all 3 char sequences of a padded string
+	grams := map[string]bool{}
+	runes := []rune("  " + s + " ")
+	for i := 0; i+3 <= len(runes); i++ {
+		grams[string(runes[i:i+3])] = true
+	}
+	return grams
+}
+
+func TrigramSimilarity(a string, b string) float64 {
+
+	// Jaccard similarity of the trigram sets of both strings
+	ga, gb := Trigrams(a), Trigrams(b)
+	if len(ga) == 0 && len(gb) == 0 {
+		return 1
+	}
+	shared := 0
+	for g := range ga {
+		if gb[g] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ga)+len(gb)-shared)
+}
+
+func FuzzyScore(query string, candidate string) float64 {
+	/*
+		Score how well a candidate matches a search query, between 0 and 1.
+		Both values are normalized first. A candidate containing the query scores 1,
+		otherwise the best of the trigram similarity of the whole strings and
+		the average per word edit distance similarity is used.
+	*/
+	q := NormalizeSearchString(query)
+	c := NormalizeSearchString(candidate)
+	if q == "" {
+		return 0
+	}
+	if strings.Contains(c, q) {
+		return 1
+	}
+
+	score := TrigramSimilarity(q, c)
+
+	candidateWords := strings.Fields(c)
+	if len(candidateWords) == 0 {
+		return score
+	}
+	total := 0.0
+	queryWords := strings.Fields(q)
+	for _, qw := range queryWords {
+		best := 0.0
+		for _, cw := range candidateWords {
+			sim := LevenshteinSimilarity(qw, cw)
+			if strings.HasPrefix(cw, qw) {
+				sim = 1
+			}
+			if sim > best {
+				best = sim
+			}
+		}
+		total += best
+	}
+	if wordScore := total / float64(len(queryWords)); wordScore > score {
+		score = wordScore
+	}
+	return score
+}
+
+func FuzzyFragments(query string) []string {
+	/*
+		Pieces of the normalized query a candidate has to contain at least one of
+		to be able to reach the match threshold of FuzzyScore.
+		Words of up to two letters are kept as they are, longer ones are split into
+		their letter pairs, a small typo only breaks two of those.
+		Lets the database narrow down the candidates before they get scored.
+	*/
+	seen := map[string]bool{}
+	var fragments []string
+	for _, word := range strings.Fields(NormalizeSearchString(query)) {
+		runes := []rune(word)
+		if len(runes) <= 2 {
+			if !seen[word] {
+				seen[word] = true
+				fragments = append(fragments, word)
+			}
+			continue
+		}
+		for i := 0; i+2 <= len(runes); i++ {
+			pair := string(runes[i : i+2])
+			if !seen[pair] {
+				seen[pair] = true
+				fragments = append(fragments, pair)
+			}
+		}
+	}
+	return fragments
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
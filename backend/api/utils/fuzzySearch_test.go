@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeSearchString(t *testing.T) {
+	assert.Equal(t, "etude n 1", NormalizeSearchString("Étude N. 1"))
+	assert.Equal(t, "frederic chopin", NormalizeSearchString("  Frédéric   CHOPIN "))
+	assert.Equal(t, "", NormalizeSearchString("..."))
+}
+
+func TestLevenshtein(t *testing.T) {
+	assert.Equal(t, 0, Levenshtein("bach", "bach"))
+	assert.Equal(t, 1, Levenshtein("beethovn", "beethoven"))
+	assert.Equal(t, 3, Levenshtein("kitten", "sitting"))
+	assert.Equal(t, 5, Levenshtein("", "liszt"))
+}
+
+func TestFuzzyScore(t *testing.T) {
+	// Accent insensitive substring match
+	assert.Equal(t, 1.0, FuzzyScore("Etude", "Étude N. 1"))
+	// Typo tolerance
+	assert.GreaterOrEqual(t, FuzzyScore("Beethovn", "Ludwig van Beethoven"), FuzzyMatchThreshold)
+	// Unrelated names don't match
+	assert.Less(t, FuzzyScore("Mozart", "Für Elise"), FuzzyMatchThreshold)
+	assert.Equal(t, 0.0, FuzzyScore("", "Für Elise"))
+}
+
+func TestFuzzyFragments(t *testing.T) {
+	assert.Equal(t, []string{"op", "be", "ee", "et", "th", "ho", "ov", "vn"}, FuzzyFragments("Op. Beethovn"))
+	assert.Equal(t, []string{"et", "tu", "ud", "de"}, FuzzyFragments("Étude étude"))
+	assert.Empty(t, FuzzyFragments("..."))
+
+	// Every hit of FuzzyScore contains one of the fragments
+	for _, candidate := range []string{"Ludwig van Beethoven", "Für Elise", "Étude N. 1"} {
+		for _, query := range []string{"Beethovn", "elise", "etude", "fur"} {
+			if FuzzyScore(query, candidate) < FuzzyMatchThreshold {
+				continue
+			}
+			found := false
+			for _, fragment := range FuzzyFragments(query) {
+				found = found || strings.Contains(NormalizeSearchString(candidate), fragment)
+			}
+			assert.True(t, found, "%s in %s", query, candidate)
+		}
+	}
+}
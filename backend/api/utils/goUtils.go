@@ -1,10 +1,5 @@
 package utils
 
-import (
-	"math/rand"
-	"time"
-)
-
 func RemoveElementOfSlice(slice []string, index int) []string {
 
 	// Remove element of string slice by index
@@ -31,17 +26,16 @@ func CheckSliceContains(slice []string, e string) bool {
 	return false
 }
 
-func CreateRandString(n int) string {
-	/*
-		Create rand n char string
-	*/
-	rand.Seed(time.Now().UnixNano())
-
-	var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+func RemoveDuplicates(slice []string) []string {
 
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+	// Keep the first occurrence of every value, in order
+	seen := map[string]bool{}
+	var unique []string
+	for _, v := range slice {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
 	}
-	return string(b)
+	return unique
 }
@@ -19,5 +19,9 @@ func ExtractToken(c *gin.Context) string {
 			token = strings.Split(bearerToken, " ")[1]
 		}
 	}
+	if token == "" {
+		// Personal API tokens can also be sent on their own, which is handy for curl in scripts
+		token = strings.TrimSpace(c.GetHeader("X-Api-Token"))
+	}
 	return token
 }
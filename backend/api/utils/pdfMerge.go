@@ -0,0 +1,414 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/fiam/gounidecode/unidecode"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// One document (or a page range of it) inside a merged PDF
+type PdfPart struct {
+	Path      string
+	PageFrom  int      // 0 means from the first page
+	PageTo    int      // 0 means up to the last page
+	Bookmark  string   // Title of the outline entry pointing to the part
+	Separator []string // Lines of a separator page put in front of the part, no page if empty
+}
+
+func init() {
+
+	// pdfcpu would otherwise create a config.yml in the home directory of the server
+	pdfcpu.ConfigPath = "disable"
+}
+
+func MergePdfs(parts []PdfPart, w io.Writer) error {
+	/*
+		Concatenate the given PDFs in order and write the result to w.
+		Every part gets one bookmark in the outline of the merged document.
+		The parts are read one after the other and their pages are written out
+		right away, so only a single part is in memory at any time, no matter
+		how long the list is.
+	*/
+	if len(parts) == 0 {
+		return fmt.Errorf("nothing to merge")
+	}
+
+	pw := newPdfWriter(w)
+	for _, part := range parts {
+		firstPage := 0
+		if len(part.Separator) > 0 {
+			firstPage = pw.addSeparatorPage(part.Separator)
+		}
+
+		page, err := pw.addPdfPart(part)
+		if err != nil {
+			return fmt.Errorf("%s: %v", part.Bookmark, err)
+		}
+		if firstPage == 0 {
+			firstPage = page
+		}
+		pw.addBookmark(part.Bookmark, firstPage)
+	}
+	return pw.close()
+}
+
+type pdfBookmark struct {
+	title string
+	page  int
+}
+
+/*
+	Writes a PDF object by object. Objects are numbered by reserve and
+	can be written in any order, only their offsets are kept for the xref table.
+	Write errors stick to the bufio.Writer and are returned by close.
+*/
+type pdfWriter struct {
+	w         *bufio.Writer
+	offset    int64
+	offsets   []int64 // Offset of object n at n-1
+	pagesNr   int
+	pages     []int
+	bookmarks []pdfBookmark
+}
+
+func newPdfWriter(w io.Writer) *pdfWriter {
+	pw := &pdfWriter{w: bufio.NewWriter(w)}
+	pw.write("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+
+	// The page tree is only written at the end, but every page needs to point to it
+	pw.pagesNr = pw.reserve()
+	return pw
+}
+
+func (pw *pdfWriter) write(s string) {
+	n, _ := pw.w.WriteString(s)
+	pw.offset += int64(n)
+}
+
+func (pw *pdfWriter) reserve() int {
+	pw.offsets = append(pw.offsets, 0)
+	return len(pw.offsets)
+}
+
+func (pw *pdfWriter) writeObject(nr int, body string) {
+	pw.offsets[nr-1] = pw.offset
+	pw.write(fmt.Sprintf("%d 0 obj\n%s\nendobj\n", nr, body))
+}
+
+func (pw *pdfWriter) writeStream(nr int, d pdfcpu.Dict, content []byte) {
+	d["Length"] = pdfcpu.Integer(len(content))
+	pw.offsets[nr-1] = pw.offset
+	pw.write(fmt.Sprintf("%d 0 obj\n%s\nstream\n", nr, d.PDFString()))
+	n, _ := pw.w.Write(content)
+	pw.offset += int64(n)
+	pw.write("\nendstream\nendobj\n")
+}
+
+func (pw *pdfWriter) addBookmark(title string, page int) {
+	pw.bookmarks = append(pw.bookmarks, pdfBookmark{title: title, page: page})
+}
+
+func (pw *pdfWriter) addSeparatorPage(lines []string) int {
+	/*
+		A single A4 page showing the given lines, the first one as a heading.
+		The standard fonts only cover latin characters, so the text is transliterated.
+	*/
+	var content bytes.Buffer
+	y := 620
+	for i, line := range lines {
+		size := 16
+		if i == 0 {
+			size = 28
+		}
+		fmt.Fprintf(&content, "BT /F1 %d Tf 72 %d Td (%s) Tj ET\n", size, y, escapePdfString(line))
+		y -= size + 16
+	}
+
+	fontNr, contentNr, pageNr := pw.reserve(), pw.reserve(), pw.reserve()
+	pw.writeObject(fontNr, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold >>")
+	pw.writeStream(contentNr, pdfcpu.Dict{}, content.Bytes())
+	pw.writeObject(pageNr, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 %d 0 R >> >> /Contents %d 0 R >>",
+		pw.pagesNr, fontNr, contentNr))
+	pw.pages = append(pw.pages, pageNr)
+	return pageNr
+}
+
+func (pw *pdfWriter) addPdfPart(part PdfPart) (int, error) {
+
+	// Copy the pages of the part together with everything they use, returns the number of the first page
+	f, err := os.Open(part.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	ctx, err := api.ReadContext(f, pdfConfiguration())
+	if err != nil {
+		return 0, err
+	}
+	if err := api.ValidateContext(ctx); err != nil {
+		return 0, err
+	}
+	if err := ctx.EnsurePageCount(); err != nil {
+		return 0, err
+	}
+
+	from, to := part.PageFrom, part.PageTo
+	if from == 0 {
+		from = 1
+	}
+	if to == 0 || to > ctx.PageCount {
+		to = ctx.PageCount
+	}
+	if from > to {
+		return 0, fmt.Errorf("page range %d-%d is outside of the %d pages", part.PageFrom, part.PageTo, ctx.PageCount)
+	}
+
+	copier := &pdfObjectCopier{ctx: ctx, pw: pw, numbers: map[int]int{}}
+
+	// Number the pages first, so links between them survive
+	type copiedPage struct {
+		dict  pdfcpu.Dict
+		attrs *pdfcpu.InheritedPageAttrs
+		nr    int
+	}
+	var pages []copiedPage
+	for i := from; i <= to; i++ {
+		d, ir, attrs, err := ctx.PageDict(i, false)
+		if err != nil {
+			return 0, err
+		}
+		if d == nil || ir == nil {
+			return 0, fmt.Errorf("page %d is missing", i)
+		}
+		nr := pw.reserve()
+		copier.numbers[ir.ObjectNumber.Value()] = nr
+		pages = append(pages, copiedPage{dict: d, attrs: attrs, nr: nr})
+	}
+
+	for _, page := range pages {
+		d := pdfcpu.Dict{}
+		for key, value := range page.dict {
+			d[key] = value
+		}
+
+		// Attributes inherited from the old page tree have to move into the page itself
+		if _, found := d["Resources"]; !found && page.attrs.Resources != nil {
+			d["Resources"] = page.attrs.Resources
+		}
+		if _, found := d["MediaBox"]; !found && page.attrs.MediaBox != nil {
+			d["MediaBox"] = page.attrs.MediaBox.Array()
+		}
+		if _, found := d["CropBox"]; !found && page.attrs.CropBox != nil {
+			d["CropBox"] = page.attrs.CropBox.Array()
+		}
+		if _, found := d["Rotate"]; !found && page.attrs.Rotate != 0 {
+			d["Rotate"] = pdfcpu.Integer(page.attrs.Rotate)
+		}
+		delete(d, "Parent")
+
+		copied, err := copier.copy(d)
+		if err != nil {
+			return 0, err
+		}
+		copiedDict := copied.(pdfcpu.Dict)
+		copiedDict["Parent"] = *pdfcpu.NewIndirectRef(pw.pagesNr, 0)
+		pw.writeObject(page.nr, copiedDict.PDFString())
+		pw.pages = append(pw.pages, page.nr)
+
+		if err := copier.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return pages[0].nr, nil
+}
+
+func (pw *pdfWriter) close() error {
+
+	// Page tree, outline, catalog and the xref table pointing to all objects
+	kids := make([]string, len(pw.pages))
+	for i, page := range pw.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", page)
+	}
+	pw.writeObject(pw.pagesNr, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pw.pages)))
+
+	outline := ""
+	if len(pw.bookmarks) > 0 {
+		outlinesNr := pw.reserve()
+		items := make([]int, len(pw.bookmarks))
+		for i := range items {
+			items[i] = pw.reserve()
+		}
+		for i, bookmark := range pw.bookmarks {
+			item := fmt.Sprintf("<< /Title %s /Parent %d 0 R /Dest [%d 0 R /Fit]", pdfTextString(bookmark.title), outlinesNr, bookmark.page)
+			if i > 0 {
+				item += fmt.Sprintf(" /Prev %d 0 R", items[i-1])
+			}
+			if i < len(items)-1 {
+				item += fmt.Sprintf(" /Next %d 0 R", items[i+1])
+			}
+			pw.writeObject(items[i], item+" >>")
+		}
+		pw.writeObject(outlinesNr, fmt.Sprintf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>", items[0], items[len(items)-1], len(items)))
+		outline = fmt.Sprintf(" /Outlines %d 0 R /PageMode /UseOutlines", outlinesNr)
+	}
+
+	catalogNr := pw.reserve()
+	pw.writeObject(catalogNr, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R%s >>", pw.pagesNr, outline))
+
+	xref := pw.offset
+	pw.write(fmt.Sprintf("xref\n0 %d\n0000000000 65535 f \n", len(pw.offsets)+1))
+	for _, offset := range pw.offsets {
+		pw.write(fmt.Sprintf("%010d 00000 n \n", offset))
+	}
+	pw.write(fmt.Sprintf("trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(pw.offsets)+1, catalogNr, xref))
+	return pw.w.Flush()
+}
+
+/*
+	Copies the objects of one document into the writer under new numbers.
+	References are numbered when they are found and the objects are written by flush.
+	Other pages and page trees of the document aren't copied, references to them become null.
+*/
+type pdfObjectCopier struct {
+	ctx     *pdfcpu.Context
+	pw      *pdfWriter
+	numbers map[int]int // Old object number to new one
+	pending []int       // Old numbers of objects which still have to be written
+}
+
+func (oc *pdfObjectCopier) copy(o pdfcpu.Object) (pdfcpu.Object, error) {
+	switch v := o.(type) {
+	case pdfcpu.IndirectRef:
+		return oc.ref(v)
+	case pdfcpu.Dict:
+		d := pdfcpu.Dict{}
+		for key, value := range v {
+			copied, err := oc.copy(value)
+			if err != nil {
+				return nil, err
+			}
+			if copied != nil {
+				d[key] = copied
+			}
+		}
+		return d, nil
+	case pdfcpu.Array:
+		a := make(pdfcpu.Array, len(v))
+		for i, value := range v {
+			copied, err := oc.copy(value)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = copied
+		}
+		return a, nil
+	default:
+		return o, nil
+	}
+}
+
+func (oc *pdfObjectCopier) ref(ir pdfcpu.IndirectRef) (pdfcpu.Object, error) {
+	old := ir.ObjectNumber.Value()
+	if nr, ok := oc.numbers[old]; ok {
+		return *pdfcpu.NewIndirectRef(nr, 0), nil
+	}
+
+	o, err := oc.ctx.Dereference(ir)
+	if err != nil || o == nil {
+		return nil, err
+	}
+	if d, ok := o.(pdfcpu.Dict); ok && d.Type() != nil && (*d.Type() == "Page" || *d.Type() == "Pages") {
+		return nil, nil
+	}
+
+	nr := oc.pw.reserve()
+	oc.numbers[old] = nr
+	oc.pending = append(oc.pending, old)
+	return *pdfcpu.NewIndirectRef(nr, 0), nil
+}
+
+func (oc *pdfObjectCopier) flush() error {
+	for len(oc.pending) > 0 {
+		old := oc.pending[0]
+		oc.pending = oc.pending[1:]
+
+		o, err := oc.ctx.Dereference(*pdfcpu.NewIndirectRef(old, 0))
+		if err != nil {
+			return err
+		}
+		nr := oc.numbers[old]
+
+		sd, ok := o.(pdfcpu.StreamDict)
+		if !ok {
+			copied, err := oc.copy(o)
+			if err != nil {
+				return err
+			}
+			if copied == nil {
+				oc.pw.writeObject(nr, "null")
+			} else {
+				oc.pw.writeObject(nr, copied.PDFString())
+			}
+			continue
+		}
+
+		// The stream is copied as it is encoded, its length is written directly
+		if sd.Raw == nil && sd.Content != nil {
+			if err := sd.Encode(); err != nil {
+				return err
+			}
+		}
+		d := pdfcpu.Dict{}
+		for key, value := range sd.Dict {
+			if key != "Length" {
+				d[key] = value
+			}
+		}
+		copied, err := oc.copy(d)
+		if err != nil {
+			return err
+		}
+		oc.pw.writeStream(nr, copied.(pdfcpu.Dict), sd.Raw)
+	}
+	return nil
+}
+
+func pdfConfiguration() *pdfcpu.Configuration {
+	conf := pdfcpu.NewDefaultConfiguration()
+	conf.ValidationMode = pdfcpu.ValidationRelaxed
+	return conf
+}
+
+// Text like bookmark titles as UTF-16, so accents survive
+func pdfTextString(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", unit)
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+func escapePdfString(s string) string {
+	var b strings.Builder
+	for _, r := range unidecode.Unidecode(s) {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
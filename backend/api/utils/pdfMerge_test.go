@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergePdfs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testing-merge")
+	defer os.RemoveAll(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	single := path.Join(dir, "single.pdf")
+	if err := ioutil.WriteFile(single, singlePage(t, "Single page"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	triple := path.Join(dir, "triple.pdf")
+	writeMerged(t, triple, []PdfPart{
+		{Path: single, Bookmark: "1"},
+		{Path: single, Bookmark: "2"},
+		{Path: single, Bookmark: "3"},
+	})
+	assert.Equal(t, 3, pageCount(t, triple))
+
+	merged := path.Join(dir, "merged.pdf")
+	writeMerged(t, merged, []PdfPart{
+		{Path: triple, PageFrom: 2, Bookmark: "Clair de lune", Separator: []string{"Clair de lune", "Claude Debussy"}},
+		{Path: single, Bookmark: "Gnossienne (No. 1)"},
+	})
+	assert.Equal(t, 4, pageCount(t, merged))
+
+	ctx, err := api.ReadContextFile(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outlines, err := ctx.DereferenceDict(catalog["Outlines"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, pdfcpu.Integer(2), outlines["Count"])
+}
+
+func TestMergePdfsInvalidRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testing-merge")
+	defer os.RemoveAll(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	single := path.Join(dir, "single.pdf")
+	if err := ioutil.WriteFile(single, singlePage(t, "Single page"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = MergePdfs([]PdfPart{{Path: single, PageFrom: 3}}, ioutil.Discard)
+	assert.Error(t, err)
+}
+
+func TestEscapePdfString(t *testing.T) {
+	assert.Equal(t, `Dvorak \(Op. 95\)`, escapePdfString("Dvořák (Op. 95)"))
+}
+
+func writeMerged(t *testing.T, file string, parts []PdfPart) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := MergePdfs(parts, f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A document with one page, written the same way as separator pages
+func singlePage(t *testing.T, lines ...string) []byte {
+	var b bytes.Buffer
+	pw := newPdfWriter(&b)
+	pw.addSeparatorPage(lines)
+	if err := pw.close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func pageCount(t *testing.T, file string) int {
+	count, err := api.PageCountFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
@@ -1,32 +1,189 @@
 package utils
 
 import (
+	"bytes"
 	"crypto/tls"
-	"fmt"
+	"html/template"
+	"log"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/SheetAble/SheetAble/backend/api/config"
 	gomail "gopkg.in/mail.v2"
 )
 
-func SendPasswordResetEmail(resetPasswordId string, emailAdress string) {
+var passwordResetTemplate = template.Must(template.New("password_reset").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; color: #333;">
+	<h2>Reset your SheetAble password</h2>
+	<p>Somebody asked to reset the password of the account {{.Email}}.</p>
+	<p>
+		<a href="{{.Link}}" style="display: inline-block; padding: 10px 16px; background: #5b6cff; color: #fff; text-decoration: none; border-radius: 4px;">Choose a new password</a>
+	</p>
+	<p>Or open this link: <a href="{{.Link}}">{{.Link}}</a></p>
+	<p>The link works once and expires in {{.Expires}}. If you didn't ask for it, you can ignore this email.</p>
+</body>
+</html>
+`))
+
+var invitationTemplate = template.Must(template.New("invitation").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; color: #333;">
+	<h2>You are invited to SheetAble</h2>
+	<p>You got invited to the sheet music library at <a href="{{.ServerUrl}}">{{.ServerUrl}}</a>.</p>
+	<p>
+		<a href="{{.Link}}" style="display: inline-block; padding: 10px 16px; background: #5b6cff; color: #fff; text-decoration: none; border-radius: 4px;">Accept the invitation</a>
+	</p>
+	<p>Or open this link: <a href="{{.Link}}">{{.Link}}</a></p>
+	<p>You choose your password when accepting. The invitation expires in {{.Expires}}.</p>
+</body>
+</html>
+`))
+
+// Short notices with a button, e.g. about registrations
+var noticeTemplate = template.Must(template.New("notice").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; color: #333;">
+	<h2>{{.Title}}</h2>
+	<p>{{.Text}}</p>
+	<p>
+		<a href="{{.Link}}" style="display: inline-block; padding: 10px 16px; background: #5b6cff; color: #fff; text-decoration: none; border-radius: 4px;">{{.LinkText}}</a>
+	</p>
+</body>
+</html>
+`))
+
+func SendPasswordResetEmail(resetToken string, emailAdress string, ttl time.Duration) {
 	if config.Config().Smtp.Enabled == "0" {
 		return
 	}
-	m := gomail.NewMessage()
 
-	// Set E-Mail sender
+	link := strings.TrimSuffix(config.Config().ServerUrl, "/") + "/reset-password/" + url.PathEscape(resetToken)
+	data := map[string]string{
+		"Email":   emailAdress,
+		"Link":    link,
+		"Expires": ttl.String(),
+	}
+	var body bytes.Buffer
+	if err := passwordResetTemplate.Execute(&body, data); err != nil {
+		log.Printf("unable to render password reset email: %s", err.Error())
+		return
+	}
+
+	m := gomail.NewMessage()
 	m.SetHeader("From", config.Config().Smtp.From)
+	m.SetHeader("To", emailAdress)
+	m.SetHeader("Subject", "Password Reset Request")
+
+	// Plain text for mail clients without HTML
+	m.SetBody("text/plain", "There was a password reset request for your account. Go to "+link+" to choose a new password. The link works once and expires in "+ttl.String()+".")
+	m.AddAlternative("text/html", body.String())
 
-	// Set E-Mail receivers
+	// Recipients stay out of the log, it would otherwise tell who asked for a reset
+	if err := sendEmail(m); err != nil {
+		log.Printf("unable to send password reset email: %s", err.Error())
+		return
+	}
+	log.Printf("sent password reset email")
+}
+
+// Unlike the password reset, the admin sending the invitation should know if it failed
+func SendInvitationEmail(token string, emailAdress string, ttl time.Duration) error {
+	if config.Config().Smtp.Enabled == "0" {
+		return nil
+	}
+
+	serverUrl := strings.TrimSuffix(config.Config().ServerUrl, "/")
+	link := InvitationLink(token)
+	data := map[string]string{
+		"ServerUrl": serverUrl,
+		"Link":      link,
+		"Expires":   ttl.String(),
+	}
+	var body bytes.Buffer
+	if err := invitationTemplate.Execute(&body, data); err != nil {
+		return err
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", config.Config().Smtp.From)
 	m.SetHeader("To", emailAdress)
+	m.SetHeader("Subject", "You are invited to SheetAble")
+	m.SetBody("text/plain", "You got invited to SheetAble at "+serverUrl+". Go to "+link+" to choose your password. The invitation expires in "+ttl.String()+".")
+	m.AddAlternative("text/html", body.String())
 
-	// Set E-Mail subject
-	m.SetHeader("Subject", "Password Reset Request")
+	if err := sendEmail(m); err != nil {
+		return err
+	}
+	log.Printf("sent invitation email")
+	return nil
+}
+
+// The registration fails if this fails, the user couldn't log in otherwise
+func SendEmailConfirmationEmail(token string, emailAdress string, ttl time.Duration) error {
+	link := strings.TrimSuffix(config.Config().ServerUrl, "/") + "/confirm-email/" + url.PathEscape(token)
+	return sendNotice([]string{emailAdress}, "Confirm your SheetAble account",
+		"Somebody registered a SheetAble account with this email address. Open the link to confirm it was you, it expires in "+ttl.String()+". If it wasn't you, you can ignore this email.",
+		link, "Confirm my email address")
+}
 
-	// Set E-Mail body. You can set plain text or html with text/html
-	m.SetBody("text/plain", "Hey there was a password reset request to your accout. Go to "+config.Config().ServerUrl+"/reset-password/"+resetPasswordId+" to update your password") // TODO: Make HTML + make resetPasswordId a frontend URL
+func SendRegistrationRequestEmail(adminEmails []string, emailAdress string) error {
+	serverUrl := strings.TrimSuffix(config.Config().ServerUrl, "/")
+	return sendNotice(adminEmails, "New SheetAble account request",
+		emailAdress+" asked for an account and is waiting for your approval.",
+		serverUrl+"/settings", "Review the request")
+}
+
+func SendRegistrationApprovedEmail(emailAdress string) error {
+	serverUrl := strings.TrimSuffix(config.Config().ServerUrl, "/")
+	return sendNotice([]string{emailAdress}, "Your SheetAble account was approved",
+		"An admin approved your account, you can log in now.",
+		serverUrl+"/login", "Log in")
+}
 
-	// Settings for SMTP server
+func SendRegistrationRejectedEmail(emailAdress string) error {
+	serverUrl := strings.TrimSuffix(config.Config().ServerUrl, "/")
+	return sendNotice([]string{emailAdress}, "Your SheetAble account request",
+		"An admin declined your account request. Reach out to them if you think this was a mistake.",
+		serverUrl, "Go to SheetAble")
+}
+
+func sendNotice(to []string, subject string, text string, link string, linkText string) error {
+	if config.Config().Smtp.Enabled == "0" || len(to) == 0 {
+		return nil
+	}
+
+	data := map[string]string{
+		"Title":    subject,
+		"Text":     text,
+		"Link":     link,
+		"LinkText": linkText,
+	}
+	var body bytes.Buffer
+	if err := noticeTemplate.Execute(&body, data); err != nil {
+		return err
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", config.Config().Smtp.From)
+	m.SetHeader("To", to...)
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/plain", text+" "+link)
+	m.AddAlternative("text/html", body.String())
+
+	if err := sendEmail(m); err != nil {
+		return err
+	}
+	log.Printf("sent %q email to %d recipients", subject, len(to))
+	return nil
+}
+
+func InvitationLink(token string) string {
+	return strings.TrimSuffix(config.Config().ServerUrl, "/") + "/invitation/" + url.PathEscape(token)
+}
+
+func sendEmail(m *gomail.Message) error {
 	d := gomail.NewDialer(config.Config().Smtp.HostServerAddr,
 		config.Config().Smtp.HostServerPort,
 		config.Config().Smtp.Username,
@@ -37,13 +194,5 @@ func SendPasswordResetEmail(resetPasswordId string, emailAdress string) {
 	// In production this should be set to false.
 	d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
 
-	// Now send E-Mail
-	if err := d.DialAndSend(m); err != nil {
-		fmt.Println(err)
-		panic(err)
-	}
-
-	fmt.Println("Sent password reset request email to: " + emailAdress)
-
-	return
+	return d.DialAndSend(m)
 }
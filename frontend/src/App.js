@@ -18,7 +18,11 @@ import UploadPage from "./Components/Upload/UploadPage";
 // Redux
 import { Provider } from "react-redux";
 import { PersistGate } from "redux-persist/integration/react";
-import { logoutUser } from "./Redux/Actions/userActions";
+import {
+  finishOidcLogin,
+  logoutUser,
+  refreshAccessToken,
+} from "./Redux/Actions/userActions";
 import { persistor, store } from "./Redux/store";
 import { SET_AUTHENTICATED } from "./Redux/types";
 
@@ -38,6 +42,9 @@ import TagsPage from "./Components/TagsPage/TagsPage";
 //import ForgotPassword from "./Components/Authentication/ForgotPasswordPage";
 import ForgotPasswordPage from "./Components/Authentication/ForgotPasswordPage";
 import ResetPasswordPage from "./Components/Authentication/ResetPasswordPage";
+import AcceptInvitationPage from "./Components/Authentication/AcceptInvitationPage";
+import RegisterPage from "./Components/Authentication/RegisterPage";
+import ConfirmEmailPage from "./Components/Authentication/ConfirmEmailPage";
 
 // Check if started in development mode, so you can modify baseURL accordingly
 if (!process.env.NODE_ENV || process.env.NODE_ENV === "development") {
@@ -46,9 +53,44 @@ if (!process.env.NODE_ENV || process.env.NODE_ENV === "development") {
   axios.defaults.baseURL = "/api";
 }
 
+// The refresh token is kept in a cookie
+axios.defaults.withCredentials = true;
+
+const endSession = () => {
+  store.dispatch(logoutUser());
+  window.location.href = "/login";
+};
+
+// Access tokens are short-lived, get a new one once and retry the request
+axios.interceptors.response.use(undefined, (err) => {
+  const request = err.config;
+  if (
+    err.response === undefined ||
+    err.response.status !== 401 ||
+    request._retried ||
+    request.url.endsWith("/token/refresh") ||
+    request.url.endsWith("/login") ||
+    !localStorage.FBIdToken
+  ) {
+    return Promise.reject(err);
+  }
+  request._retried = true;
+  return refreshAccessToken()
+    .then((token) => {
+      request.headers["Authorization"] = token;
+      return axios(request);
+    })
+    .catch((refreshErr) => {
+      endSession();
+      return Promise.reject(refreshErr);
+    });
+});
+
 // Load token from localstorage and check it
 const token = localStorage.FBIdToken;
-if (token) {
+if (window.location.pathname === "/login/oidc") {
+  store.dispatch(finishOidcLogin());
+} else if (token) {
   let decodedToken = undefined;
   try {
     decodedToken = jwtDecode(token);
@@ -60,15 +102,13 @@ if (token) {
     const ts = Date.now();
     const currentTime = Math.floor(ts / 1000) - 7200;
     if (decodedToken.exp < currentTime) {
-      store.dispatch(logoutUser());
-      window.location.href = "/login";
+      refreshAccessToken().catch(endSession);
     } else {
       store.dispatch({ type: SET_AUTHENTICATED });
       axios.defaults.headers.common["Authorization"] = token;
     }
   } else {
-    store.dispatch(logoutUser());
-    window.location.href = "/login";
+    endSession();
   }
 }
 
@@ -119,6 +159,17 @@ function App() {
                 path="/reset-password/:resetPasswordId"
                 component={ResetPasswordPage}
               />
+              <Route
+                exact
+                path="/invitation/:invitationToken"
+                component={AcceptInvitationPage}
+              />
+              <Route exact path="/register" component={RegisterPage} />
+              <Route
+                exact
+                path="/confirm-email/:confirmationToken"
+                component={ConfirmEmailPage}
+              />
               <Route component={Redirect} />
             </Switch>
           )}
@@ -0,0 +1,84 @@
+import { Button } from "@material-ui/core";
+import TextField from "@material-ui/core/TextField";
+import axios from "axios";
+import React, { useState } from "react";
+import { useParams } from "react-router-dom";
+import "./ForgotPassword.css";
+
+export default function AcceptInvitationPage() {
+  const [passwordValue, setPasswordVaule] = useState("");
+  const [confirmPasswordValue, setConfirmPasswordVaule] = useState("");
+  const [error, setError] = useState(0); // 0: nothing, 1: success; 2: err
+  const [errorMessage, setErrorMessage] = useState("");
+  const { invitationToken } = useParams();
+
+  const handleSubmit = () => {
+    axios
+      .post(`/invitations/${invitationToken}/accept`, {
+        password: passwordValue,
+      })
+      .then((res) => {
+        setError(1);
+        window.location.href = "/login";
+      })
+      .catch((err) => {
+        setError(2);
+        setErrorMessage(
+          err.response && typeof err.response.data === "string"
+            ? err.response.data
+            : "This invitation is invalid."
+        );
+        console.log(err);
+      });
+  };
+
+  return (
+    <div className="forgot-password-container reset-pass">
+      <div className="card">
+        <h1>Welcome to SheetAble</h1>
+        <h2>Choose a password to finish setting up your account.</h2>
+        <TextField
+          id="standard-basic"
+          label="Password"
+          variant="standard"
+          className="email-input"
+          type="password"
+          value={passwordValue}
+          onChange={(event) =>
+            setPasswordVaule(event.target.value) & setError(0)
+          }
+          error={error === 2}
+        />
+        <TextField
+          id="standard-basic"
+          label="Confirm Password"
+          variant="standard"
+          className="email-input"
+          type="password"
+          value={confirmPasswordValue}
+          onChange={(event) =>
+            setConfirmPasswordVaule(event.target.value) & setError(0)
+          }
+          error={error === 2}
+          helperText={
+            error === 2
+              ? errorMessage
+              : error === 1 && "Your account was created, you can log in now."
+          }
+        />
+        <div className="btn-container">
+          <Button
+            variant="contained"
+            className="btn"
+            disabled={
+              passwordValue === "" || confirmPasswordValue !== passwordValue
+            }
+            onClick={() => handleSubmit()}
+          >
+            Create Account
+          </Button>
+        </div>
+      </div>
+    </div>
+  );
+}
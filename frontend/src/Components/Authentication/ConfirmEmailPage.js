@@ -0,0 +1,48 @@
+import { Button } from "@material-ui/core";
+import axios from "axios";
+import React, { useEffect, useState } from "react";
+import { useParams } from "react-router-dom";
+import "./ForgotPassword.css";
+
+export default function ConfirmEmailPage() {
+  const [message, setMessage] = useState("Confirming your email address...");
+  const { confirmationToken } = useParams();
+
+  useEffect(() => {
+    axios
+      .post(`/register/confirm/${confirmationToken}`)
+      .then((res) => {
+        setMessage(
+          res.data.pending
+            ? "Your email address is confirmed. Your account is waiting for the approval of an admin, you get an email once it was approved."
+            : "Your email address is confirmed, you can log in now."
+        );
+      })
+      .catch((err) => {
+        setMessage(
+          err.response && typeof err.response.data === "string"
+            ? err.response.data
+            : "This confirmation link is invalid."
+        );
+        console.log(err);
+      });
+  }, [confirmationToken]);
+
+  return (
+    <div className="forgot-password-container reset-pass">
+      <div className="card">
+        <h1>Confirm your email</h1>
+        <h2>{message}</h2>
+        <div className="btn-container">
+          <Button
+            variant="contained"
+            className="btn"
+            onClick={() => (window.location.href = "/login")}
+          >
+            Log In
+          </Button>
+        </div>
+      </div>
+    </div>
+  );
+}
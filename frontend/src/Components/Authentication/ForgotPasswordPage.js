@@ -39,8 +39,9 @@ export default function ForgotPasswordPage() {
           error={error === 2}
           helperText={
             error === 2
-              ? "No account was found with this email."
-              : error === 1 && "Email was sent successfully."
+              ? "The email couldn't be sent, please try again later."
+              : error === 1 &&
+                "If an account uses this email, a reset link is on its way."
           }
         />
         <div className="btn-container">
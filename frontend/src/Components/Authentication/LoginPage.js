@@ -6,7 +6,11 @@ import PropTypes from "prop-types";
 
 // Redux stuff
 import { connect } from "react-redux";
-import { loginUser } from "../../Redux/Actions/userActions";
+import {
+  loginUser,
+  finishProxyLogin,
+} from "../../Redux/Actions/userActions";
+import axios from "axios";
 
 class LoginPage extends Component {
   constructor() {
@@ -15,12 +19,32 @@ class LoginPage extends Component {
       email: "",
       password: "",
       errors: {},
+      sso: false,
+      registration: "closed",
     };
   }
 
   componentDidMount() {
     // Change Page Title
     document.title = `SheetAble - Login`;
+
+    // Offer single sign-on if the server has it set up
+    axios
+      .get("/auth/oidc")
+      .then((res) => this.setState({ sso: res.data.enabled }))
+      .catch(() => {});
+
+    // Behind an authenticating reverse proxy the user is logged in already
+    axios
+      .get("/auth/proxy")
+      .then((res) => res.data.authenticated && this.props.finishProxyLogin())
+      .catch(() => {});
+
+    // Offer signing up if the server allows it
+    axios
+      .get("/registration")
+      .then((res) => this.setState({ registration: res.data.mode }))
+      .catch(() => {});
   }
 
   componentWillReceiveProps(nextProps) {
@@ -94,9 +118,22 @@ class LoginPage extends Component {
                   onSubmit={this.handleSubmit}
                 />
               </div>
-              <div class="signup-link">
-                Accounts can be created by the admin.
-              </div>
+              {this.state.sso && (
+                <div class="signup-link">
+                  <a href={`${axios.defaults.baseURL}/auth/oidc/login`}>
+                    Sign in with single sign-on
+                  </a>
+                </div>
+              )}
+              {this.state.registration === "closed" ? (
+                <div class="signup-link">
+                  Accounts can be created by the admin.
+                </div>
+              ) : (
+                <div class="signup-link">
+                  No account yet? <a href="/register">Sign up</a>
+                </div>
+              )}
             </form>
           ) : (
             <form onSubmit={this.handleSubmit}>
@@ -148,9 +185,22 @@ class LoginPage extends Component {
                   onSubmit={this.handleSubmit}
                 />
               </div>
-              <div class="signup-link">
-                Accounts can be created by the admin.
-              </div>
+              {this.state.sso && (
+                <div class="signup-link">
+                  <a href={`${axios.defaults.baseURL}/auth/oidc/login`}>
+                    Sign in with single sign-on
+                  </a>
+                </div>
+              )}
+              {this.state.registration === "closed" ? (
+                <div class="signup-link">
+                  Accounts can be created by the admin.
+                </div>
+              ) : (
+                <div class="signup-link">
+                  No account yet? <a href="/register">Sign up</a>
+                </div>
+              )}
             </form>
           )}
         </div>
@@ -161,6 +211,7 @@ class LoginPage extends Component {
 
 LoginPage.propTypes = {
   loginUser: PropTypes.func.isRequired,
+  finishProxyLogin: PropTypes.func.isRequired,
   user: PropTypes.object.isRequired,
   UI: PropTypes.object.isRequired,
 };
@@ -172,6 +223,7 @@ const mapStateToProps = (state) => ({
 
 const mapActionsToProps = {
   loginUser,
+  finishProxyLogin,
 };
 
 export default connect(mapStateToProps, mapActionsToProps)(LoginPage);
@@ -0,0 +1,107 @@
+import { Button } from "@material-ui/core";
+import TextField from "@material-ui/core/TextField";
+import axios from "axios";
+import React, { useState } from "react";
+import "./ForgotPassword.css";
+
+export default function RegisterPage() {
+  const [emailValue, setEmailValue] = useState("");
+  const [passwordValue, setPasswordVaule] = useState("");
+  const [confirmPasswordValue, setConfirmPasswordVaule] = useState("");
+  const [error, setError] = useState(0); // 0: nothing, 2: err; 3: waiting for the confirmation
+  const [errorMessage, setErrorMessage] = useState("");
+  const [pending, setPending] = useState(false);
+
+  const handleSubmit = () => {
+    axios
+      .post("/register", {
+        email: emailValue,
+        password: passwordValue,
+      })
+      .then((res) => {
+        setError(3);
+        setPending(res.data.pending);
+      })
+      .catch((err) => {
+        setError(2);
+        setErrorMessage(
+          err.response && typeof err.response.data === "string"
+            ? err.response.data
+            : "The account couldn't be created, please try again later."
+        );
+        console.log(err);
+      });
+  };
+
+  const helperText = () => {
+    switch (error) {
+      case 2:
+        return errorMessage;
+      case 3:
+        return pending
+          ? "We sent you an email, please confirm your address with its link. Afterwards an admin has to approve your account."
+          : "We sent you an email, please confirm your address with its link to log in.";
+      default:
+        return "";
+    }
+  };
+
+  return (
+    <div className="forgot-password-container reset-pass">
+      <div className="card">
+        <h1>Create an account</h1>
+        <h2>Sign up with your email and a password.</h2>
+        <TextField
+          id="standard-basic"
+          label="Email"
+          variant="standard"
+          className="email-input"
+          type="email"
+          value={emailValue}
+          onChange={(event) => setEmailValue(event.target.value) & setError(0)}
+          error={error === 2}
+        />
+        <TextField
+          id="standard-basic"
+          label="Password"
+          variant="standard"
+          className="email-input"
+          type="password"
+          value={passwordValue}
+          onChange={(event) =>
+            setPasswordVaule(event.target.value) & setError(0)
+          }
+          error={error === 2}
+        />
+        <TextField
+          id="standard-basic"
+          label="Confirm Password"
+          variant="standard"
+          className="email-input"
+          type="password"
+          value={confirmPasswordValue}
+          onChange={(event) =>
+            setConfirmPasswordVaule(event.target.value) & setError(0)
+          }
+          error={error === 2}
+          helperText={helperText()}
+        />
+        <div className="btn-container">
+          <Button
+            variant="contained"
+            className="btn"
+            disabled={
+              emailValue === "" ||
+              passwordValue === "" ||
+              confirmPasswordValue !== passwordValue ||
+              error === 3
+            }
+            onClick={() => handleSubmit()}
+          >
+            Sign Up
+          </Button>
+        </div>
+      </div>
+    </div>
+  );
+}
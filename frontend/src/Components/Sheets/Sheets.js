@@ -3,7 +3,7 @@ import React from "react";
 import "./Sheets.css";
 
 import { useHistory } from "react-router-dom";
-import axios from "axios";
+import { getThumbnailUrl } from "../../Utils/utils";
 
 function Sheets(props) {
   const { sheets } = props;
@@ -21,7 +21,7 @@ function Sheets(props) {
         <div className="box-container remove_shadow">
           <img
             className="thumbnail-image"
-            src={`${axios.defaults.baseURL}/sheet/thumbnail/${sheet.safe_sheet_name}`}
+            src={getThumbnailUrl(sheet.safe_sheet_name)}
             alt="Sheet Thumbnail"
           />
           <div className="sheet-name-container">
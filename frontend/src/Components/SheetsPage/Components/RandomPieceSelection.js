@@ -4,7 +4,7 @@ import LoadingAnimation from "../../../Images/Animations/Loading.svg";
 import "./BubblyButton.css";
 import { useHistory } from "react-router";
 
-import axios from "axios";
+import { getThumbnailUrl } from "../../../Utils/utils";
 
 function RandomPieceSelection({ sheetPages, page }) {
   const [loading, setLoading] = useState(true);
@@ -52,7 +52,7 @@ function RandomPieceSelection({ sheetPages, page }) {
           <div>
             <img
               className="rand-img cursor"
-              src={`${axios.defaults.baseURL}/sheet/thumbnail/${sheet.safe_sheet_name}`}
+              src={getThumbnailUrl(sheet.safe_sheet_name)}
               alt="Sheet Thumbnail"
               onClick={() =>
                 history.push(`sheet/${sheet.pdf_url.split("pdf/").pop()}`)
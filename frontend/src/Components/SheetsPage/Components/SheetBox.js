@@ -2,7 +2,7 @@ import React from "react";
 
 import { useHistory } from "react-router-dom";
 
-import axios from "axios";
+import { getThumbnailUrl } from "../../../Utils/utils";
 
 function SheetBox({ sheet }) {
   let history = useHistory();
@@ -18,7 +18,7 @@ function SheetBox({ sheet }) {
       <div className="box-container remove_shadow">
         <img
           className="thumbnail-image"
-          src={`${axios.defaults.baseURL}/sheet/thumbnail/${sheet.safe_sheet_name}`}
+          src={getThumbnailUrl(sheet.safe_sheet_name)}
           alt="Sheet Thumbnail"
         />
         <div className="sheet-name-container">
@@ -12,6 +12,9 @@ export const loginUser = (userData, history) => (dispatch) => {
   dispatch({ type: LOADING_UI });
   axios
     .post("/login", userData)
+    .then((res) =>
+      res.data.mfa_required ? completeMfaLogin(res.data.challenge_id) : res
+    )
     .then((res) => {
       setAuthorizationHeader(res.data);
       dispatch({ type: SET_AUTHENTICATED });
@@ -44,7 +47,52 @@ export const loginUser = (userData, history) => (dispatch) => {
     });
 };
 
+// Second step of the login for users with two-factor authentication
+const completeMfaLogin = (challengeId) => {
+  const code = window.prompt(
+    "Enter the code of your authenticator app or one of your recovery codes"
+  );
+  if (!code) {
+    return Promise.reject({
+      response: { data: "Two-factor code required" },
+    });
+  }
+  return axios.post("/login/mfa", { challenge_id: challengeId, code: code });
+};
+
+// The server redirects to /login/oidc after a single sign-on login, with the refresh token set as cookie
+export const finishOidcLogin = () => (dispatch) => {
+  refreshAccessToken()
+    .then(() => axios.get("/users/0"))
+    .then((res) => {
+      delete res.data.password;
+      dispatch({ type: SET_AUTHENTICATED });
+      dispatch({ type: SET_USER_DATA, payload: res.data });
+      window.location.replace("/");
+    })
+    .catch(() => {
+      window.location.replace("/login");
+    });
+};
+
+// The reverse proxy sends the user with every request, so there is no token to store
+export const finishProxyLogin = () => (dispatch) => {
+  axios
+    .get("/users/0")
+    .then((res) => {
+      delete res.data.password;
+      dispatch({ type: SET_AUTHENTICATED });
+      dispatch({ type: SET_USER_DATA, payload: res.data });
+      window.location.replace("/");
+    })
+    .catch(() => {});
+};
+
 export const logoutUser = () => (dispatch) => {
+  // End the session on the server too, so the refresh token stops working
+  if (localStorage.FBIdToken) {
+    axios.post("/logout").catch(() => {});
+  }
   localStorage.removeItem("FBIdToken");
   delete axios.defaults.headers.common["Authorization"];
   dispatch({ type: SET_UNAUTHENTICATED });
@@ -69,6 +117,13 @@ export const createUser = (userData) => (dispatch) => {
 
 
 
+// Trade the refresh token cookie for a new access token
+export const refreshAccessToken = () =>
+  axios.post("/token/refresh").then((res) => {
+    setAuthorizationHeader(res.data);
+    return `Bearer ${res.data}`;
+  });
+
 const setAuthorizationHeader = (token) => {
   const FBIdToken = `Bearer ${token}`;
   localStorage.setItem("FBIdToken", FBIdToken);
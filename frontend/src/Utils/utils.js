@@ -42,8 +42,13 @@ export function findComposerByComposers(safeComposerName, composers) {
   return composers.find((composer) => composer.safe_name === safeComposerName);
 }
 
+/* Images are authorized by the media token cookie the server sets on login */
 export function getCompImgUrl(portraitURL) {
   return portraitURL.includes("http")
     ? portraitURL
     : axios.defaults.baseURL + portraitURL;
 }
+
+export function getThumbnailUrl(safeSheetName) {
+  return `${axios.defaults.baseURL}/sheet/thumbnail/${safeSheetName}`;
+}